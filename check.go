@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/updater"
+)
+
+// runCheck reports which watched containers and Swarm services have newer
+// images without updating them: isengard check [-q]. It exits with status 2
+// if any update is pending.
+func runCheck(args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	quiet := fs.Bool("q", false, "only print containers with pending updates")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(flags, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	pending, failed, checked := 0, 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tIMAGE\tSTATUS")
+	for _, h := range s.hosts {
+		reports, err := h.updater.Check(context.Background())
+		if err != nil {
+			return hostError(h.name, err)
+		}
+		checked += len(reports)
+
+		for _, r := range reports {
+			if r.Err != nil {
+				failed++
+			}
+			if r.UpdateAvailable {
+				pending++
+			} else if *quiet {
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", displayName(h.name, r.Container.Name, r.Service), r.Container.Image, describeReport(r))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, checked)
+	}
+	if pending > 0 {
+		return errUpdatesPending
+	}
+	return nil
+}
+
+// displayName is how check and list show a container, or a Swarm service
+// with a " (service)" suffix.
+func displayName(hostName, name string, service bool) string {
+	if service {
+		return qualify(hostName, name) + " (service)"
+	}
+	return qualify(hostName, name)
+}
+
+// describeReport summarises a check result in a few words.
+func describeReport(r updater.Report) string {
+	switch {
+	case r.Err != nil:
+		return "error: " + r.Err.Error()
+	case r.Deferred > 0:
+		return fmt.Sprintf("update available (deferred %s, cooldown)", r.Deferred.Round(time.Minute))
+	case r.UpdateAvailable && r.RemoteDigest != "":
+		return fmt.Sprintf("update available (%s -> %s)", shortID(r.LocalDigest), shortID(r.RemoteDigest))
+	case r.UpdateAvailable:
+		return "update available"
+	default:
+		return "up to date"
+	}
+}
+
+// runUpdate runs an update cycle now: isengard update [name...]. With
+// several Docker hosts, containers are named as host/name.
+func runUpdate(args []string) error {
+	fs := flag.NewFlagSet("update", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(flags, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	names := map[*host][]string{}
+	for _, arg := range fs.Args() {
+		h, name, err := s.target(arg)
+		if err != nil {
+			return err
+		}
+		names[h] = append(names[h], name)
+	}
+
+	total := 0
+	for _, h := range s.hosts {
+		if fs.NArg() > 0 && len(names[h]) == 0 {
+			continue
+		}
+		updated, err := h.updater.Update(context.Background(), names[h])
+		total += updated
+		if err != nil {
+			return hostError(h.name, err)
+		}
+	}
+	fmt.Printf("%d container(s) updated\n", total)
+	return nil
+}
+
+// runList prints every running container, and every Swarm service on a
+// manager, and whether it is watched: isengard list.
+func runList(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(flags, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CONTAINER\tIMAGE\tWATCHED\tREASON")
+	for _, h := range s.hosts {
+		watches, err := h.updater.List(context.Background())
+		if err != nil {
+			return hostError(h.name, err)
+		}
+		for _, wt := range watches {
+			watched, reason := "yes", wt.Reason
+			if reason != "" {
+				watched = "no"
+			} else {
+				reason = watchReason(s.cfg.WatchAll)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", displayName(h.name, wt.Container.Name, wt.Service), wt.Container.Image, watched, reason)
+		}
+	}
+	return w.Flush()
+}
+
+// watchReason explains why a watched container is included.
+func watchReason(watchAll bool) string {
+	if watchAll {
+		return "watch-all mode"
+	}
+	return "labeled isengard.enable=true"
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/api"
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/events"
+)
+
+// runDaemon watches containers and updates them every interval until it
+// receives SIGINT or SIGTERM.
+func runDaemon(args []string) error {
+	fs := flag.NewFlagSet("daemon", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig(flags, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("starting isengard",
+		"version", version,
+		"interval", cfg.Interval,
+		"run_once", cfg.RunOnce,
+		"cleanup", cfg.Cleanup,
+		"stop_timeout", cfg.StopTimeout,
+		"self_update", cfg.SelfUpdate,
+		"state_dir", cfg.StateDir,
+		"policy_file", cfg.PolicyFile,
+		"events", cfg.Events,
+		"api_addr", cfg.APIAddr,
+		"api_token", redact(cfg.APIToken),
+		"webhook_secret", redact(cfg.WebhookSecret),
+		"registry_auth", registryHosts(cfg.RegistryAuth),
+		"hosts", hostNames(cfg.Hosts),
+	)
+
+	s, err := newSession(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	for _, h := range s.hosts {
+		info, err := h.cli.Info(context.Background())
+		if err != nil {
+			// A single daemon must be reachable; with several, one being
+			// down must not stop updates on the others.
+			if len(s.hosts) == 1 {
+				return fmt.Errorf("connecting to Docker: %w", err)
+			}
+			slog.Error("cannot connect to Docker host, will retry every cycle", "host", h.name, "error", err)
+			continue
+		}
+		slog.Info("connected to Docker",
+			"host", h.name,
+			"version", info.ServerVersion,
+			"containers", info.Containers,
+		)
+		h.updater.CleanupOldSelf(context.Background())
+	}
+
+	checkDockerConfig()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pushes := make(chan []string, 16)
+	if cfg.APIAddr != "" {
+		srv := api.New(cfg.APIAddr, cfg.APIToken, s.store)
+		if cfg.WebhookSecret != "" {
+			srv.EnableWebhooks(cfg.WebhookSecret, func(images []string) {
+				select {
+				case pushes <- images:
+				default:
+					slog.Warn("too many pending webhooks, dropping one", "images", images)
+				}
+			})
+		}
+		go func() {
+			if err := srv.ListenAndServe(ctx); err != nil {
+				slog.Error("API server failed", "error", err)
+			}
+		}()
+	} else if cfg.WebhookSecret != "" {
+		slog.Warn("webhook secret is set but the API is disabled, webhooks will not be received")
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigCh
+		slog.Info("received signal, shutting down gracefully", "signal", sig)
+		cancel()
+	}()
+
+	runCycles(ctx, s.hosts)
+
+	if cfg.RunOnce {
+		slog.Info("run-once mode, exiting")
+		return nil
+	}
+
+	ticker := time.NewTicker(cfg.Interval)
+	defer ticker.Stop()
+
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
+	var triggers <-chan hostTrigger
+	if cfg.Events {
+		triggers = watchEvents(ctx, s.hosts, cfg.EventsDebounce)
+	}
+
+	poll := time.NewTicker(configPollInterval)
+	defer poll.Stop()
+	watched := newFileWatcher(cfg.File, cfg.PolicyFile)
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("shutting down")
+			return nil
+		case <-ticker.C:
+			runCycles(ctx, s.hosts)
+		case t := <-triggers:
+			names, images := t.host.updater.IgnoreOwn(t.Containers, t.Images)
+			if len(names) == 0 && len(images) == 0 {
+				slog.Debug("Docker events caused by isengard itself, ignoring", "host", t.host.name, "containers", t.Containers, "images", t.Images)
+				continue
+			}
+			slog.Info("Docker events triggered a check", "host", t.host.name, "containers", names, "images", images)
+			if _, err := t.host.updater.UpdateTargets(ctx, names, images); err != nil {
+				slog.Error("triggered check failed", "host", t.host.name, "error", err)
+			}
+		case images := <-pushes:
+			slog.Info("registry webhook triggered an update", "images", images)
+			forEachHost(s.hosts, func(h *host) {
+				if _, err := h.updater.UpdateTargets(ctx, nil, images); err != nil {
+					slog.Error("webhook-triggered update failed", "host", h.name, "error", err)
+				}
+			})
+		case <-hupCh:
+			slog.Info("received SIGHUP, reloading configuration")
+			cfg = reloadConfig(flags, cfg, s.hosts, ticker)
+			watched = newFileWatcher(cfg.File, cfg.PolicyFile)
+		case <-poll.C:
+			if path := watched.changed(); path != "" {
+				slog.Info("configuration file changed, reloading", "path", path)
+				cfg = reloadConfig(flags, cfg, s.hosts, ticker)
+				watched = newFileWatcher(cfg.File, cfg.PolicyFile)
+			}
+		}
+	}
+}
+
+// redact hides a secret value while still showing whether it is set.
+func redact(v string) string {
+	if v == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// registryHosts lists the registries credentials were configured for,
+// without the credentials themselves.
+func registryHosts(auth map[string]config.Credential) []string {
+	hosts := make([]string, 0, len(auth))
+	for host := range auth {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// checkDockerConfig warns at startup if the Docker config path exists but is
+// a directory. This typically means Docker created it automatically when the
+// file was bind-mounted but did not exist on the host.
+func checkDockerConfig() {
+	configPath := "/root/.docker/config.json"
+	if v := os.Getenv("DOCKER_CONFIG"); v != "" {
+		configPath = v + "/config.json"
+	}
+
+	fi, err := os.Stat(configPath)
+	if err != nil {
+		return // does not exist, nothing to warn about
+	}
+	if fi.IsDir() {
+		slog.Warn("docker config path is a directory, not a file (private registry auth will not work)",
+			"path", configPath,
+			"hint", "remove the directory on the host and only mount config.json if the file exists",
+		)
+	}
+}
+
+// hostNames lists the names of the configured Docker hosts.
+func hostNames(hosts []config.Host) []string {
+	names := make([]string, len(hosts))
+	for i, h := range hosts {
+		names[i] = h.Name
+	}
+	return names
+}
+
+// runCycles runs an update cycle on every host at the same time.
+func runCycles(ctx context.Context, hosts []*host) {
+	forEachHost(hosts, func(h *host) { runCycle(ctx, h) })
+}
+
+// forEachHost calls fn for every host concurrently and waits for all calls.
+func forEachHost(hosts []*host, fn func(h *host)) {
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(h)
+		}()
+	}
+	wg.Wait()
+}
+
+func runCycle(ctx context.Context, h *host) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	updated, err := h.updater.RunCycle(ctx)
+	if err != nil {
+		slog.Error("update cycle failed", "host", h.name, "error", err)
+		return
+	}
+
+	if updated > 0 {
+		slog.Info("cycle finished", "host", h.name, "updated", updated)
+	}
+}
+
+// hostTrigger is an events trigger from one Docker host.
+type hostTrigger struct {
+	host *host
+	events.Trigger
+}
+
+// watchEvents follows the events stream of every host and merges their
+// triggers into one channel.
+func watchEvents(ctx context.Context, hosts []*host, debounce time.Duration) <-chan hostTrigger {
+	out := make(chan hostTrigger)
+	for _, h := range hosts {
+		go func() {
+			for t := range events.Watch(ctx, h.cli, debounce) {
+				select {
+				case out <- hostTrigger{host: h, Trigger: t}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/api"
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+// runHistory prints the recorded update history, optionally for a single
+// container (host/name with several Docker hosts): isengard history
+// [-kind check|update] [-since 24h] [-limit 20] [-json] [container].
+func runHistory(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	kind := fs.String("kind", "", "only show records of this kind (check or update)")
+	since := fs.String("since", "", "only show records newer than this (duration such as 24h, or RFC 3339 time)")
+	limit := fs.Int("limit", 20, "show at most this many records (0 = all)")
+	asJSON := fs.Bool("json", false, "print records as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(flags)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	filter := state.Filter{
+		Container: fs.Arg(0),
+		Kind:      state.Kind(*kind),
+		Limit:     *limit,
+	}
+	if hostName, name, ok := strings.Cut(filter.Container, "/"); ok {
+		filter.Host, filter.Container = hostName, name
+	}
+	if *since != "" {
+		filter.Since, err = api.ParseSince(*since, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+
+	records, err := store.Query(filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tKIND\tCONTAINER\tIMAGE\tRESULT\tDURATION")
+	for _, r := range records {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Local().Format(time.DateTime),
+			r.Kind,
+			qualify(r.Host, r.Container),
+			r.Image,
+			describeResult(r),
+			(time.Duration(r.DurationMS) * time.Millisecond).String(),
+		)
+	}
+	return w.Flush()
+}
+
+// describeResult summarises a history record in a few words.
+func describeResult(r state.Record) string {
+	switch {
+	case r.Error != "" && r.Restored:
+		return "failed and restored: " + r.Error
+	case r.Error != "":
+		return "error: " + r.Error
+	case r.Kind == state.KindUpdate, r.Kind == state.KindRollback:
+		return fmt.Sprintf("updated %s -> %s", shortID(r.OldImageID), shortID(r.NewImageID))
+	case r.Deferred:
+		return "update deferred (cooldown)"
+	case r.UpdateAvailable:
+		return "update available"
+	default:
+		return "up to date"
+	}
+}
+
+// shortID trims an image or container ID to its 12-character short form.
+func shortID(id string) string {
+	if len(id) > 19 && id[:7] == "sha256:" {
+		return id[7:19]
+	}
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,152 @@
+// Package api serves Isengard's HTTP API.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+// Server exposes update history over HTTP and receives registry webhooks.
+type Server struct {
+	addr  string
+	token string
+	store *state.Store
+
+	webhookSecret string
+	onPush        func(images []string)
+}
+
+// New configures a [Server] listening on addr. When token is non-empty,
+// every request except the health check must carry it as a Bearer token.
+func New(addr, token string, store *state.Store) *Server {
+	return &Server{addr: addr, token: token, store: store}
+}
+
+// Handler returns the API's HTTP routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.Handle("GET /v1/history", s.authenticated(http.HandlerFunc(s.handleHistory)))
+	if s.onPush != nil {
+		// Webhooks authenticate with their own secret, since most registries
+		// cannot send the API token.
+		mux.HandleFunc("POST /v1/webhooks/{provider}", s.handleWebhook)
+	}
+	return mux
+}
+
+// ListenAndServe serves the API until ctx is canceled, then shuts down
+// gracefully.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("API listening", "addr", s.addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+// authenticated rejects requests that do not carry the configured token.
+func (s *Server) authenticated(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or invalid token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleHistory serves GET /v1/history. Supported query parameters:
+// host, container, kind (check or update), since (RFC 3339 time or a duration
+// such as 24h) and limit.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := state.Filter{
+		Host:      q.Get("host"),
+		Container: q.Get("container"),
+		Kind:      state.Kind(q.Get("kind")),
+	}
+
+	if v := q.Get("since"); v != "" {
+		since, err := ParseSince(v, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid since: "+err.Error())
+			return
+		}
+		filter.Since = since
+	}
+
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		filter.Limit = n
+	}
+
+	records, err := s.store.Query(filter)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if records == nil {
+		records = []state.Record{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"records": records})
+}
+
+// ParseSince interprets v as either an RFC 3339 timestamp or a duration
+// before now.
+func ParseSince(v string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(v); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
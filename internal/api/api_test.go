@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+func newTestStore(t *testing.T) *state.Store {
+	t.Helper()
+	store, err := state.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Record(state.Record{Kind: state.KindCheck, Container: "web"})
+	store.Record(state.Record{Kind: state.KindUpdate, Container: "web"})
+	store.Record(state.Record{Kind: state.KindCheck, Container: "db"})
+	return store
+}
+
+func TestHistory(t *testing.T) {
+	srv := New("", "", newTestStore(t))
+
+	tests := []struct {
+		query    string
+		status   int
+		expected int
+	}{
+		{"", http.StatusOK, 3},
+		{"?container=web", http.StatusOK, 2},
+		{"?kind=update", http.StatusOK, 1},
+		{"?limit=1", http.StatusOK, 1},
+		{"?since=1h", http.StatusOK, 3},
+		{"?limit=abc", http.StatusBadRequest, 0},
+		{"?since=yesterday", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/history"+tt.query, http.NoBody))
+
+			if rec.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var body struct {
+				Records []state.Record `json:"records"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if len(body.Records) != tt.expected {
+				t.Errorf("expected %d records, got %d", tt.expected, len(body.Records))
+			}
+		})
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	srv := New("", "s3cret", newTestStore(t))
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+	}{
+		{"missing token", "/v1/history", "", http.StatusUnauthorized},
+		{"wrong token", "/v1/history", "Bearer nope", http.StatusUnauthorized},
+		{"valid token", "/v1/history", "Bearer s3cret", http.StatusOK},
+		{"health check is public", "/healthz", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, http.NoBody)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	got, err := ParseSince("2h", now)
+	if err != nil || !got.Equal(now.Add(-2*time.Hour)) {
+		t.Errorf("duration: got %v, %v", got, err)
+	}
+
+	got, err = ParseSince("2025-05-01T00:00:00Z", now)
+	if err != nil || !got.Equal(time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("timestamp: got %v, %v", got, err)
+	}
+
+	if _, err := ParseSince("last week", now); err == nil {
+		t.Error("expected error for invalid input")
+	}
+}
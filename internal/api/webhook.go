@@ -0,0 +1,265 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// maxWebhookBody bounds the size of a webhook payload.
+const maxWebhookBody = 1 << 20
+
+// webhookProvider parses one registry's push notification format into image
+// references ("host/repository:tag") and checks its authentication.
+type webhookProvider struct {
+	verify func(r *http.Request, body []byte, secret string) bool
+	parse  func(body []byte) ([]string, error)
+}
+
+var webhookProviders = map[string]webhookProvider{
+	"dockerhub":    {verify: querySecret, parse: parseDockerHub},
+	"github":       {verify: githubSignature, parse: parseGitHub},
+	"harbor":       {verify: authorizationHeader, parse: parseHarbor},
+	"quay":         {verify: querySecret, parse: parseQuay},
+	"gitlab":       {verify: gitlabToken, parse: parseDistribution},
+	"distribution": {verify: authorizationHeader, parse: parseDistribution},
+}
+
+// EnableWebhooks serves POST /v1/webhooks/{provider}, authenticated with
+// secret, and calls onPush with the image references a notification names.
+// onPush must not block.
+func (s *Server) EnableWebhooks(secret string, onPush func(images []string)) {
+	s.webhookSecret = secret
+	s.onPush = onPush
+}
+
+// handleWebhook serves POST /v1/webhooks/{provider}.
+func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("provider")
+	provider, ok := webhookProviders[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown webhook provider "+name)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "reading body: "+err.Error())
+		return
+	}
+
+	if !provider.verify(r, body, s.webhookSecret) {
+		slog.Warn("rejected webhook with invalid secret or signature", "provider", name, "remote", r.RemoteAddr)
+		writeError(w, http.StatusUnauthorized, "invalid secret or signature")
+		return
+	}
+
+	images, err := provider.parse(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid payload: "+err.Error())
+		return
+	}
+
+	slog.Info("webhook received", "provider", name, "images", images)
+	if len(images) == 0 {
+		images = []string{}
+	} else {
+		s.onPush(images)
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"images": images})
+}
+
+func equalSecret(got, want string) bool {
+	return want != "" && subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// querySecret checks a ?secret= parameter, for registries that cannot sign
+// or add headers to their webhooks (Docker Hub, Quay).
+func querySecret(r *http.Request, _ []byte, secret string) bool {
+	return equalSecret(r.URL.Query().Get("secret"), secret)
+}
+
+// authorizationHeader checks the Authorization header, with or without a
+// Bearer prefix (Harbor sends the configured value verbatim).
+func authorizationHeader(r *http.Request, _ []byte, secret string) bool {
+	got := r.Header.Get("Authorization")
+	got = strings.TrimPrefix(got, "Bearer ")
+	return equalSecret(got, secret)
+}
+
+// gitlabToken checks the X-Gitlab-Token header.
+func gitlabToken(r *http.Request, _ []byte, secret string) bool {
+	return equalSecret(r.Header.Get("X-Gitlab-Token"), secret)
+}
+
+// githubSignature checks the X-Hub-Signature-256 HMAC of the body.
+func githubSignature(r *http.Request, body []byte, secret string) bool {
+	got, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	if !ok || secret == "" {
+		return false
+	}
+	sig, err := hex.DecodeString(got)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// parseDockerHub reads a Docker Hub repository webhook.
+func parseDockerHub(body []byte) ([]string, error) {
+	var p struct {
+		PushData struct {
+			Tag string `json:"tag"`
+		} `json:"push_data"`
+		Repository struct {
+			RepoName string `json:"repo_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.Repository.RepoName == "" || p.PushData.Tag == "" {
+		return nil, fmt.Errorf("missing repository or tag")
+	}
+	return []string{"docker.io/" + p.Repository.RepoName + ":" + p.PushData.Tag}, nil
+}
+
+// parseGitHub reads a GitHub "package" or "registry_package" event for a
+// container published to GHCR. Other events (such as ping) name no images.
+func parseGitHub(body []byte) ([]string, error) {
+	type pkg struct {
+		Name        string `json:"name"`
+		Namespace   string `json:"namespace"`
+		PackageType string `json:"package_type"`
+		Owner       struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+		PackageVersion struct {
+			PackageURL        string `json:"package_url"`
+			ContainerMetadata struct {
+				Tag struct {
+					Name string `json:"name"`
+				} `json:"tag"`
+			} `json:"container_metadata"`
+		} `json:"package_version"`
+	}
+	var p struct {
+		Action          string `json:"action"`
+		Package         *pkg   `json:"package"`
+		RegistryPackage *pkg   `json:"registry_package"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+
+	pk := p.Package
+	if pk == nil {
+		pk = p.RegistryPackage
+	}
+	if pk == nil || !strings.EqualFold(pk.PackageType, "container") {
+		return nil, nil
+	}
+
+	tag := pk.PackageVersion.ContainerMetadata.Tag.Name
+	if tag == "" {
+		return nil, nil
+	}
+	if url := pk.PackageVersion.PackageURL; url != "" && strings.HasSuffix(url, ":"+tag) {
+		return []string{url}, nil
+	}
+	owner := pk.Namespace
+	if owner == "" {
+		owner = pk.Owner.Login
+	}
+	return []string{"ghcr.io/" + strings.ToLower(owner) + "/" + pk.Name + ":" + tag}, nil
+}
+
+// parseHarbor reads a Harbor PUSH_ARTIFACT event.
+func parseHarbor(body []byte) ([]string, error) {
+	var p struct {
+		Type      string `json:"type"`
+		EventData struct {
+			Resources []struct {
+				Tag         string `json:"tag"`
+				ResourceURL string `json:"resource_url"`
+			} `json:"resources"`
+		} `json:"event_data"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.Type != "PUSH_ARTIFACT" {
+		return nil, nil
+	}
+
+	var images []string
+	for _, res := range p.EventData.Resources {
+		if res.Tag != "" && res.ResourceURL != "" {
+			images = append(images, res.ResourceURL)
+		}
+	}
+	return images, nil
+}
+
+// parseQuay reads a Quay repository push notification.
+func parseQuay(body []byte) ([]string, error) {
+	var p struct {
+		DockerURL   string   `json:"docker_url"`
+		UpdatedTags []string `json:"updated_tags"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+	if p.DockerURL == "" {
+		return nil, fmt.Errorf("missing docker_url")
+	}
+
+	images := make([]string, 0, len(p.UpdatedTags))
+	for _, tag := range p.UpdatedTags {
+		images = append(images, p.DockerURL+":"+tag)
+	}
+	return images, nil
+}
+
+// parseDistribution reads a CNCF distribution (registry:2) notification
+// envelope, as also emitted by GitLab's container registry. Only manifest
+// pushes by tag name images.
+func parseDistribution(body []byte) ([]string, error) {
+	var p struct {
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+				Tag        string `json:"tag"`
+			} `json:"target"`
+			Request struct {
+				Host string `json:"host"`
+			} `json:"request"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, err
+	}
+
+	var images []string
+	for _, e := range p.Events {
+		if e.Action != "push" || e.Target.Tag == "" || e.Target.Repository == "" {
+			continue
+		}
+		ref := e.Target.Repository + ":" + e.Target.Tag
+		if e.Request.Host != "" {
+			ref = e.Request.Host + "/" + ref
+		}
+		images = append(images, ref)
+	}
+	return images, nil
+}
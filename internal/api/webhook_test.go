@@ -0,0 +1,128 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWebhookPayloads(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+		expected []string
+	}{
+		{
+			"dockerhub",
+			`{"push_data":{"tag":"latest"},"repository":{"repo_name":"acme/web"}}`,
+			[]string{"docker.io/acme/web:latest"},
+		},
+		{
+			"github",
+			`{"action":"published","package":{"name":"web","namespace":"Acme","package_type":"CONTAINER","package_version":{"container_metadata":{"tag":{"name":"v2"}}}}}`,
+			[]string{"ghcr.io/acme/web:v2"},
+		},
+		{
+			"github",
+			`{"action":"published","registry_package":{"name":"web","package_type":"container","package_version":{"package_url":"ghcr.io/acme/web:v2","container_metadata":{"tag":{"name":"v2"}}}}}`,
+			[]string{"ghcr.io/acme/web:v2"},
+		},
+		{"github", `{"zen":"Keep it logically awesome."}`, nil},
+		{
+			"harbor",
+			`{"type":"PUSH_ARTIFACT","event_data":{"resources":[{"tag":"1.0","resource_url":"harbor.example.com/lib/web:1.0"}]}}`,
+			[]string{"harbor.example.com/lib/web:1.0"},
+		},
+		{"harbor", `{"type":"DELETE_ARTIFACT","event_data":{"resources":[{"tag":"1.0","resource_url":"harbor.example.com/lib/web:1.0"}]}}`, nil},
+		{
+			"quay",
+			`{"repository":"acme/web","docker_url":"quay.io/acme/web","updated_tags":["latest","v3"]}`,
+			[]string{"quay.io/acme/web:latest", "quay.io/acme/web:v3"},
+		},
+		{
+			"distribution",
+			`{"events":[{"action":"push","target":{"repository":"web","tag":"edge"},"request":{"host":"registry.example.com:5000"}},{"action":"push","target":{"repository":"web"},"request":{"host":"registry.example.com:5000"}},{"action":"pull","target":{"repository":"web","tag":"edge"}}]}`,
+			[]string{"registry.example.com:5000/web:edge"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			images, err := webhookProviders[tt.provider].parse([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(images, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, images)
+			}
+		})
+	}
+}
+
+func TestWebhookAuthentication(t *testing.T) {
+	const secret = "s3cret"
+	body := `{"events":[{"action":"push","target":{"repository":"web","tag":"edge"},"request":{"host":"registry.example.com"}}]}`
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		value    string
+		expected int
+		pushed   bool
+	}{
+		// The body is a distribution notification: other providers accept
+		// the secret but find no image in it, or reject it as malformed.
+		{"query secret", "/v1/webhooks/quay?secret=s3cret", "", "", http.StatusBadRequest, false},
+		{"wrong query secret", "/v1/webhooks/dockerhub?secret=nope", "", "", http.StatusUnauthorized, false},
+		{"bearer", "/v1/webhooks/distribution", "Authorization", "Bearer s3cret", http.StatusAccepted, true},
+		{"plain authorization", "/v1/webhooks/harbor", "Authorization", "s3cret", http.StatusAccepted, false},
+		{"missing authorization", "/v1/webhooks/distribution", "", "", http.StatusUnauthorized, false},
+		{"gitlab token", "/v1/webhooks/gitlab", "X-Gitlab-Token", "s3cret", http.StatusAccepted, true},
+		{"query secret not accepted for gitlab", "/v1/webhooks/gitlab?secret=s3cret", "", "", http.StatusUnauthorized, false},
+		{"github signature", "/v1/webhooks/github", "X-Hub-Signature-256", signature, http.StatusAccepted, false},
+		{"bad github signature", "/v1/webhooks/github", "X-Hub-Signature-256", "sha256=00", http.StatusUnauthorized, false},
+		{"api token is not a webhook secret", "/v1/webhooks/distribution", "Authorization", "Bearer api-token", http.StatusUnauthorized, false},
+		{"unknown provider", "/v1/webhooks/nexus", "", "", http.StatusNotFound, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var pushed []string
+			srv := New("", "api-token", newTestStore(t))
+			srv.EnableWebhooks(secret, func(images []string) { pushed = images })
+
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(body))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Fatalf("expected status %d, got %d: %s", tt.expected, rec.Code, rec.Body)
+			}
+			if got := pushed != nil; got != tt.pushed {
+				t.Errorf("expected pushed=%v, got %v", tt.pushed, pushed)
+			}
+		})
+	}
+}
+
+func TestWebhooksDisabled(t *testing.T) {
+	srv := New("", "", newTestStore(t))
+
+	rec := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/webhooks/dockerhub", strings.NewReader("{}")))
+	if rec.Code != http.StatusNotFound && rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected webhooks to be unavailable, got status %d", rec.Code)
+	}
+}
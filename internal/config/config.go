@@ -1,15 +1,15 @@
-// Package config handles Isengard configuration from environment variables.
+// Package config handles Isengard configuration from command-line flags,
+// environment variables and an optional YAML config file.
 package config
 
 import (
 	"log/slog"
-	"os"
-	"strconv"
 	"time"
 )
 
 // Config controls Isengard's runtime behavior.
-// All fields map to ISENGARD_* environment variables via [Load].
+// All fields map to ISENGARD_* environment variables, config file keys and
+// command-line flags via [Load].
 type Config struct {
 	// Interval between update check cycles (ISENGARD_INTERVAL, default 30m).
 	Interval time.Duration
@@ -28,62 +28,136 @@ type Config struct {
 	// image is available (ISENGARD_SELF_UPDATE, default false).
 	// The self-update runs after all other containers have been processed.
 	SelfUpdate bool
+	// Events triggers checks when containers are started or images are
+	// pulled or tagged on the host, in addition to the interval
+	// (ISENGARD_EVENTS, default true).
+	Events bool
+	// EventsDebounce is how long to wait for a burst of Docker events to
+	// settle before checking (ISENGARD_EVENTS_DEBOUNCE, default 10s).
+	EventsDebounce time.Duration
+	// CheckConcurrency is how many containers are checked for updates at
+	// the same time (ISENGARD_CHECK_CONCURRENCY, default 4). Recreation is
+	// always one container at a time.
+	CheckConcurrency int
+	// RegistryConcurrency limits the concurrent checks against any single
+	// registry (ISENGARD_REGISTRY_CONCURRENCY, default 2).
+	RegistryConcurrency int
+	// HookTimeout bounds how long a lifecycle hook command may run inside a
+	// container before it is abandoned (ISENGARD_HOOK_TIMEOUT, default 1m).
+	// Containers can override it with the isengard.hook.timeout label.
+	HookTimeout time.Duration
+	// ServiceUpdateTimeout is how long to follow a Docker Swarm service
+	// update for its outcome before moving on (ISENGARD_SERVICE_UPDATE_TIMEOUT,
+	// default 10m). The update itself continues under Swarm's control.
+	ServiceUpdateTimeout time.Duration
+	// HooksDir is a directory of executables run at lifecycle points of each
+	// cycle (ISENGARD_HOOKS_DIR, default empty = disabled).
+	HooksDir string
+	// HookFailure decides what happens when a cycle-start or pre-update script
+	// fails: "abort" skips the cycle or container, "continue" only logs it
+	// (ISENGARD_HOOK_FAILURE, default abort).
+	HookFailure string
+	// MinAge is how long a new image must have been available before it is
+	// applied (ISENGARD_MIN_AGE, default 0 = immediately). Containers can
+	// override it with the isengard.min-age label.
+	MinAge time.Duration
+	// MinAgeSource decides how an image's age is measured: "seen" counts from
+	// when Isengard first saw the new digest on the tag, "created" uses the
+	// image's build timestamp from the registry (ISENGARD_MIN_AGE_SOURCE,
+	// default seen).
+	MinAgeSource string
+	// VerifyKeys are paths to PEM public keys; when set, every update must
+	// carry a cosign-style signature from one of them (ISENGARD_VERIFY_KEYS,
+	// comma-separated, default empty = no verification). Containers can opt
+	// out with the isengard.verify=false label.
+	VerifyKeys []string
+	// PolicyFile is a YAML file of rules that allow or deny updates
+	// (ISENGARD_POLICY_FILE, default empty = every update allowed).
+	PolicyFile string
+	// StateDir holds the persistent update history (ISENGARD_STATE_DIR,
+	// default empty = history disabled). Mount a volume here to keep it
+	// across restarts and self-updates.
+	StateDir string
+	// RollbackKeep is how many previous images to keep per container for
+	// manual rollback, tagged as isengard-rollback/<name>:<n>
+	// (ISENGARD_ROLLBACK_KEEP, default 1, 0 disables).
+	RollbackKeep int
+	// APIAddr is the listen address of the HTTP API, e.g. ":8080"
+	// (ISENGARD_API_ADDR, default empty = disabled).
+	APIAddr string
+	// APIToken, when set, must be presented as a Bearer token on every API
+	// request (ISENGARD_API_TOKEN).
+	APIToken string
+	// WebhookSecret enables the registry webhook endpoints of the HTTP API
+	// and authenticates their requests (ISENGARD_WEBHOOK_SECRET, default
+	// empty = webhooks disabled).
+	WebhookSecret string
+	// RegistryAuth holds registry credentials keyed by registry host, for
+	// use instead of or alongside a mounted config.json
+	// (ISENGARD_REGISTRY_AUTH, whitespace-separated host=username:password
+	// entries).
+	RegistryAuth map[string]Credential
+	// RegistriesConf is a Podman registries.conf whose short-name aliases
+	// decide which registry unqualified image names are checked against;
+	// *.conf files in the registries.conf.d directory next to it are read
+	// too (ISENGARD_REGISTRIES_CONF, default empty = Docker Hub).
+	RegistriesConf string
+	// Hosts are the Docker daemons to manage (ISENGARD_HOSTS, whitespace- or
+	// comma-separated name=url entries with unix://, tcp:// or ssh:// URLs;
+	// default empty = the local daemon from DOCKER_HOST).
+	Hosts []Host
+	// TLSDir holds ca.pem, cert.pem and key.pem for tcp:// hosts, in a
+	// subdirectory named after the host or directly inside it
+	// (ISENGARD_TLS_DIR, default empty = plain TCP).
+	TLSDir string
+	// SSHKey is the private key file for ssh:// hosts (ISENGARD_SSH_KEY,
+	// default empty = the agent at SSH_AUTH_SOCK).
+	SSHKey string
+	// SSHKnownHosts is the known_hosts file that ssh:// host keys are
+	// verified against (ISENGARD_SSH_KNOWN_HOSTS, default
+	// /root/.ssh/known_hosts).
+	SSHKnownHosts string
+	// File is the config file the configuration was read from, if any
+	// (-config or ISENGARD_CONFIG).
+	File string
+	// Overrides are per-container and per-image settings from the config
+	// file, applied by [Config.Labels].
+	Overrides []Override
 }
 
-// Load populates a [Config] from ISENGARD_* environment variables,
-// falling back to defaults for any variable that is unset or invalid.
-func Load() Config {
-	c := Config{
-		Interval:    30 * time.Minute,
-		RunOnce:     false,
-		Cleanup:     true,
-		WatchAll:    true,
-		StopTimeout: 30,
-		LogLevel:    slog.LevelInfo,
-	}
-
-	if v := os.Getenv("ISENGARD_INTERVAL"); v != "" {
-		d, err := time.ParseDuration(v)
-		if err == nil && d > 0 {
-			c.Interval = d
-		}
-	}
-
-	if v := os.Getenv("ISENGARD_RUN_ONCE"); v != "" {
-		c.RunOnce, _ = strconv.ParseBool(v)
-	}
-
-	if v := os.Getenv("ISENGARD_CLEANUP"); v != "" {
-		c.Cleanup, _ = strconv.ParseBool(v)
-	}
-
-	if v := os.Getenv("ISENGARD_WATCH_ALL"); v != "" {
-		c.WatchAll, _ = strconv.ParseBool(v)
-	}
-
-	if v := os.Getenv("ISENGARD_STOP_TIMEOUT"); v != "" {
-		n, err := strconv.Atoi(v)
-		if err == nil && n > 0 {
-			c.StopTimeout = n
-		}
-	}
+// Credential is a registry username and password (or token).
+type Credential struct {
+	Username string
+	Password string
+}
 
-	if v := os.Getenv("ISENGARD_SELF_UPDATE"); v != "" {
-		c.SelfUpdate, _ = strconv.ParseBool(v)
-	}
+// Host is a Docker daemon managed by Isengard.
+type Host struct {
+	// Name labels the host in logs, history and hook payloads.
+	Name string
+	// URL is the daemon address: unix:///path, tcp://host:port or
+	// ssh://[user@]host[:port][/socket].
+	URL string
+}
 
-	if v := os.Getenv("ISENGARD_LOG_LEVEL"); v != "" {
-		switch v {
-		case "debug":
-			c.LogLevel = slog.LevelDebug
-		case "warn":
-			c.LogLevel = slog.LevelWarn
-		case "error":
-			c.LogLevel = slog.LevelError
-		default:
-			c.LogLevel = slog.LevelInfo
-		}
+// defaults returns the configuration used when nothing is set.
+func defaults() Config {
+	return Config{
+		Interval:             30 * time.Minute,
+		RunOnce:              false,
+		Cleanup:              true,
+		WatchAll:             true,
+		StopTimeout:          30,
+		LogLevel:             slog.LevelInfo,
+		Events:               true,
+		EventsDebounce:       10 * time.Second,
+		CheckConcurrency:     4,
+		RegistryConcurrency:  2,
+		HookTimeout:          time.Minute,
+		ServiceUpdateTimeout: 10 * time.Minute,
+		HookFailure:          "abort",
+		RollbackKeep:         1,
+		MinAgeSource:         "seen",
+		SSHKnownHosts:        "/root/.ssh/known_hosts",
 	}
-
-	return c
 }
@@ -1,8 +1,12 @@
 package config
 
 import (
+	"flag"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -12,12 +16,16 @@ func TestLoadDefaults(t *testing.T) {
 	for _, key := range []string{
 		"ISENGARD_INTERVAL", "ISENGARD_RUN_ONCE", "ISENGARD_CLEANUP",
 		"ISENGARD_WATCH_ALL", "ISENGARD_STOP_TIMEOUT", "ISENGARD_LOG_LEVEL",
-		"ISENGARD_SELF_UPDATE",
+		"ISENGARD_SELF_UPDATE", "ISENGARD_HOOK_TIMEOUT", "ISENGARD_HOOKS_DIR",
+		"ISENGARD_HOOK_FAILURE", "ISENGARD_CONFIG",
 	} {
 		os.Unsetenv(key)
 	}
 
-	cfg := Load()
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
 
 	if cfg.Interval != 30*time.Minute {
 		t.Errorf("expected interval 30m, got %v", cfg.Interval)
@@ -40,6 +48,43 @@ func TestLoadDefaults(t *testing.T) {
 	if cfg.SelfUpdate {
 		t.Error("expected SelfUpdate false")
 	}
+	if cfg.HookTimeout != time.Minute {
+		t.Errorf("expected HookTimeout 1m, got %v", cfg.HookTimeout)
+	}
+	if cfg.HooksDir != "" {
+		t.Errorf("expected empty HooksDir, got %q", cfg.HooksDir)
+	}
+	if cfg.HookFailure != "abort" {
+		t.Errorf("expected HookFailure abort, got %q", cfg.HookFailure)
+	}
+}
+
+func TestLoadHookFailure(t *testing.T) {
+	tests := []struct {
+		envVal   string
+		expected string
+		wantErr  bool
+	}{
+		{"abort", "abort", false},
+		{"continue", "continue", false},
+		{"ignore", "abort", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.envVal, func(t *testing.T) {
+			os.Setenv("ISENGARD_HOOK_FAILURE", tt.envVal)
+			defer os.Unsetenv("ISENGARD_HOOK_FAILURE")
+
+			cfg, err := Load(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ISENGARD_HOOK_FAILURE=%q: error = %v, wantErr %v", tt.envVal, err, tt.wantErr)
+			}
+			if cfg.HookFailure != tt.expected {
+				t.Errorf("ISENGARD_HOOK_FAILURE=%q: expected %q, got %q",
+					tt.envVal, tt.expected, cfg.HookFailure)
+			}
+		})
+	}
 }
 
 func TestLoadSelfUpdate(t *testing.T) {
@@ -47,13 +92,14 @@ func TestLoadSelfUpdate(t *testing.T) {
 		name     string
 		envVal   string
 		expected bool
+		wantErr  bool
 	}{
-		{"true", "true", true},
-		{"false", "false", false},
-		{"1", "1", true},
-		{"0", "0", false},
-		{"invalid", "yes", false}, // ParseBool fails, keeps default
-		{"empty", "", false},
+		{"true", "true", true, false},
+		{"false", "false", false, false},
+		{"1", "1", true, false},
+		{"0", "0", false, false},
+		{"invalid", "yes", false, true}, // ParseBool fails, reported
+		{"empty", "", false, false},
 	}
 
 	for _, tt := range tests {
@@ -65,7 +111,10 @@ func TestLoadSelfUpdate(t *testing.T) {
 			}
 			defer os.Unsetenv("ISENGARD_SELF_UPDATE")
 
-			cfg := Load()
+			cfg, err := Load(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ISENGARD_SELF_UPDATE=%q: error = %v, wantErr %v", tt.envVal, err, tt.wantErr)
+			}
 			if cfg.SelfUpdate != tt.expected {
 				t.Errorf("ISENGARD_SELF_UPDATE=%q: expected SelfUpdate=%v, got %v",
 					tt.envVal, tt.expected, cfg.SelfUpdate)
@@ -78,7 +127,10 @@ func TestLoadInterval(t *testing.T) {
 	os.Setenv("ISENGARD_INTERVAL", "5m")
 	defer os.Unsetenv("ISENGARD_INTERVAL")
 
-	cfg := Load()
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
 	if cfg.Interval != 5*time.Minute {
 		t.Errorf("expected interval 5m, got %v", cfg.Interval)
 	}
@@ -88,9 +140,9 @@ func TestLoadInvalidInterval(t *testing.T) {
 	os.Setenv("ISENGARD_INTERVAL", "-1s")
 	defer os.Unsetenv("ISENGARD_INTERVAL")
 
-	cfg := Load()
-	if cfg.Interval != 30*time.Minute {
-		t.Errorf("expected default interval 30m for invalid input, got %v", cfg.Interval)
+	_, err := Load(nil)
+	if err == nil || !strings.Contains(err.Error(), "ISENGARD_INTERVAL") {
+		t.Errorf("expected an error naming ISENGARD_INTERVAL, got %v", err)
 	}
 }
 
@@ -98,12 +150,13 @@ func TestLoadLogLevel(t *testing.T) {
 	tests := []struct {
 		envVal   string
 		expected slog.Level
+		wantErr  bool
 	}{
-		{"debug", slog.LevelDebug},
-		{"warn", slog.LevelWarn},
-		{"error", slog.LevelError},
-		{"info", slog.LevelInfo},
-		{"unknown", slog.LevelInfo},
+		{"debug", slog.LevelDebug, false},
+		{"warn", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"info", slog.LevelInfo, false},
+		{"unknown", slog.LevelInfo, true},
 	}
 
 	for _, tt := range tests {
@@ -111,7 +164,10 @@ func TestLoadLogLevel(t *testing.T) {
 			os.Setenv("ISENGARD_LOG_LEVEL", tt.envVal)
 			defer os.Unsetenv("ISENGARD_LOG_LEVEL")
 
-			cfg := Load()
+			cfg, err := Load(nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ISENGARD_LOG_LEVEL=%q: error = %v, wantErr %v", tt.envVal, err, tt.wantErr)
+			}
 			if cfg.LogLevel != tt.expected {
 				t.Errorf("ISENGARD_LOG_LEVEL=%q: expected %v, got %v",
 					tt.envVal, tt.expected, cfg.LogLevel)
@@ -119,3 +175,295 @@ func TestLoadLogLevel(t *testing.T) {
 		})
 	}
 }
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "isengard.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, `
+interval: 10m
+stop_timeout: 60
+cleanup: false
+verify_keys:
+  - /keys/a.pub
+  - /keys/b.pub
+`))
+	t.Setenv("ISENGARD_INTERVAL", "15m")
+	t.Setenv("ISENGARD_STOP_TIMEOUT", "45")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := RegisterFlags(fs)
+	if err := fs.Parse([]string{"-interval", "20m", "-run-once"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(flags)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Interval != 20*time.Minute {
+		t.Errorf("flag should win: interval = %v, want 20m", cfg.Interval)
+	}
+	if cfg.StopTimeout != 45 {
+		t.Errorf("env should win over file: stop_timeout = %d, want 45", cfg.StopTimeout)
+	}
+	if cfg.Cleanup {
+		t.Error("file should win over default: cleanup = true, want false")
+	}
+	if !cfg.RunOnce {
+		t.Error("bare boolean flag should set run_once")
+	}
+	if strings.Join(cfg.VerifyKeys, ",") != "/keys/a.pub,/keys/b.pub" {
+		t.Errorf("verify_keys = %v", cfg.VerifyKeys)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, `
+interval: 5mm
+stop_timout: 60
+hook_failure: ignore
+containers:
+  - name: "db-["
+    min_age: soon
+    stop_timeout: later
+    stop_signal: SIGSTAHP
+`))
+	t.Setenv("ISENGARD_ROLLBACK_KEEP", "-1")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load() succeeded, want validation error")
+	}
+	for _, want := range []string{
+		`:2: interval: "5mm"`,
+		`:3: unknown setting "stop_timout"`,
+		`:4: hook_failure`,
+		`containers[0]: invalid pattern "db-["`,
+		`containers[0]: min_age`,
+		`containers[0]: stop_timeout`,
+		`containers[0]: stop_signal: unknown signal "SIGSTAHP"`,
+		"ISENGARD_ROLLBACK_KEEP",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestLoadUnknownOverrideField(t *testing.T) {
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, `
+containers:
+  - name: web
+    strategy: blue-green
+`))
+
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "strategy") {
+		t.Errorf("expected error about unknown field strategy, got %v", err)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, `
+containers:
+  - image: "ghcr.io/our-org/*"
+    min_age: 24h
+    verify: true
+  - name: "db-*"
+    enable: false
+    stop_timeout: 2m
+    stop_signal: SIGINT
+    hooks:
+      pre_update: pg_dump -f /backup/pre.sql
+`))
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	tests := []struct {
+		name, image string
+		labels      map[string]string
+		want        map[string]string
+	}{
+		{"web", "nginx", nil, map[string]string{}},
+		{"api", "ghcr.io/our-org/api:1", nil, map[string]string{"isengard.min-age": "24h", "isengard.verify": "true"}},
+		{
+			"db-main", "postgres:16", map[string]string{"isengard.enable": "true"},
+			map[string]string{
+				"isengard.enable":          "true",
+				"isengard.hook.pre-update": "pg_dump -f /backup/pre.sql",
+				"isengard.stop-timeout":    "2m",
+				"isengard.stop-signal":     "SIGINT",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cfg.Labels(tt.name, tt.image, tt.labels)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Labels() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("Labels()[%q] = %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := defaults()
+	new := defaults()
+	new.Interval = time.Hour
+	new.APIToken = "s3cret"
+	new.Overrides = []Override{{Name: "db-*"}}
+
+	changes := Diff(old, new)
+	got := map[string]Change{}
+	for _, c := range changes {
+		got[c.Field] = c
+	}
+
+	if len(changes) != 3 {
+		t.Fatalf("Diff() = %v, want 3 changes", changes)
+	}
+	if c := got["Interval"]; c.Old != "30m0s" || c.New != "1h0m0s" {
+		t.Errorf("Interval change = %+v", c)
+	}
+	if c := got["APIToken"]; c.Old != `""` || c.New != "[redacted]" {
+		t.Errorf("APIToken change = %+v, want redacted", c)
+	}
+	if c := got["Overrides"]; c.New != "1 override(s)" {
+		t.Errorf("Overrides change = %+v", c)
+	}
+	if len(Diff(old, old)) != 0 {
+		t.Error("Diff of identical configs should be empty")
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	authFile := filepath.Join(dir, "auth")
+	if err := os.WriteFile(tokenFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(authFile, []byte("ghcr.io=me:ghp_abc\ndocker.io=user:p=ss:word\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ISENGARD_API_TOKEN_FILE", tokenFile)
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, "registry_auth_file: "+authFile+"\n"))
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.APIToken != "s3cret" {
+		t.Errorf("APIToken = %q, want s3cret", cfg.APIToken)
+	}
+	if c := cfg.RegistryAuth["ghcr.io"]; c.Username != "me" || c.Password != "ghp_abc" {
+		t.Errorf("ghcr.io credential = %+v", c)
+	}
+	if c := cfg.RegistryAuth["docker.io"]; c.Username != "user" || c.Password != "p=ss:word" {
+		t.Errorf("docker.io credential = %+v", c)
+	}
+}
+
+func TestLoadFileLists(t *testing.T) {
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, `
+registry_auth:
+  - ghcr.io=me:ghp_abc
+  - registry.example.com=deploy:p,ss
+`))
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	want := map[string]Credential{
+		"ghcr.io":              {Username: "me", Password: "ghp_abc"},
+		"registry.example.com": {Username: "deploy", Password: "p,ss"},
+	}
+	if !reflect.DeepEqual(cfg.RegistryAuth, want) {
+		t.Errorf("RegistryAuth = %+v, want %+v", cfg.RegistryAuth, want)
+	}
+
+	t.Setenv("ISENGARD_CONFIG", writeConfig(t, `
+interval:
+  - 5m
+  - 10m
+`))
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "interval: expected a value") {
+		t.Errorf("expected a list for interval to be rejected, got %v", err)
+	}
+}
+
+func TestLoadSecretErrors(t *testing.T) {
+	t.Setenv("ISENGARD_API_TOKEN", "plain")
+	t.Setenv("ISENGARD_API_TOKEN_FILE", "/run/secrets/token")
+	t.Setenv("ISENGARD_REGISTRY_AUTH", "ghcr.io=nopassword")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load() succeeded, want error")
+	}
+	for _, want := range []string{"ISENGARD_API_TOKEN_FILE: cannot be combined", "ISENGARD_REGISTRY_AUTH: entry 1"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "nopassword") {
+		t.Error("error leaks the credential entry")
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected []Host
+		wantErr  string
+	}{
+		{"", nil, ""},
+		{
+			"local=unix:///var/run/docker.sock, web1=ssh://deploy@web1.example.com\ndb=tcp://10.0.0.5:2376",
+			[]Host{
+				{Name: "local", URL: "unix:///var/run/docker.sock"},
+				{Name: "web1", URL: "ssh://deploy@web1.example.com"},
+				{Name: "db", URL: "tcp://10.0.0.5:2376"},
+			},
+			"",
+		},
+		{"web1", nil, "not name=url"},
+		{"web1=http://web1:2375", nil, "not a unix://, tcp:// or ssh:// URL"},
+		{"a/b=tcp://web1:2376", nil, "must not contain /"},
+		{"web1=tcp://a:2376 web1=tcp://b:2376", nil, "listed twice"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseHosts(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
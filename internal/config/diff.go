@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Change is a setting that differs between two configurations.
+type Change struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// secretFields are never printed by [Diff].
+var secretFields = map[string]bool{"APIToken": true, "WebhookSecret": true, "RegistryAuth": true}
+
+// Diff lists the settings that differ between old and new, by [Config]
+// field name. Secret values are redacted.
+func Diff(old, new Config) []Change {
+	var changes []Change
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < ov.NumField(); i++ {
+		name := ov.Type().Field(i).Name
+		a, b := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+		change := Change{Field: name, Old: format(a), New: format(b)}
+		switch {
+		case secretFields[name]:
+			change.Old, change.New = redact(change.Old), redact(change.New)
+		case name == "Overrides":
+			change.Old = fmt.Sprintf("%d override(s)", len(old.Overrides))
+			change.New = fmt.Sprintf("%d override(s)", len(new.Overrides))
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func format(v any) string {
+	if s, ok := v.(string); ok && s == "" {
+		return `""`
+	}
+	return fmt.Sprint(v)
+}
+
+func redact(v string) string {
+	if v == `""` {
+		return v
+	}
+	return "[redacted]"
+}
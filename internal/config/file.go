@@ -0,0 +1,244 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dirdmaster/isengard/internal/container"
+)
+
+// Labels that overrides translate to. They mirror the container labels read
+// by the updater, hooks and container packages.
+const (
+	labelEnable         = "isengard.enable"
+	labelMinAge         = "isengard.min-age"
+	labelVerify         = "isengard.verify"
+	labelHookPreCheck   = "isengard.hook.pre-check"
+	labelHookPreUpdate  = "isengard.hook.pre-update"
+	labelHookPostUpdate = "isengard.hook.post-update"
+	labelHookTimeout    = "isengard.hook.timeout"
+	labelStopTimeout    = "isengard.stop-timeout"
+	labelStopSignal     = "isengard.stop-signal"
+)
+
+// Override applies settings to the containers whose name and image match
+// its glob patterns (path.Match syntax; an empty pattern matches anything).
+// Each field has the same meaning as the corresponding container label.
+type Override struct {
+	Name        string `yaml:"name"`
+	Image       string `yaml:"image"`
+	Enable      *bool  `yaml:"enable"`
+	MinAge      string `yaml:"min_age"`
+	Verify      *bool  `yaml:"verify"`
+	StopTimeout string `yaml:"stop_timeout"`
+	StopSignal  string `yaml:"stop_signal"`
+	Hooks       struct {
+		PreCheck   string `yaml:"pre_check"`
+		PreUpdate  string `yaml:"pre_update"`
+		PostUpdate string `yaml:"post_update"`
+		Timeout    string `yaml:"timeout"`
+	} `yaml:"hooks"`
+}
+
+// matches reports whether the override applies to a container.
+func (o Override) matches(name, image string) bool {
+	if o.Name != "" {
+		if ok, _ := path.Match(o.Name, name); !ok {
+			return false
+		}
+	}
+	if o.Image != "" {
+		if ok, _ := path.Match(o.Image, image); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// labels returns the container labels equivalent to the override.
+func (o Override) labels() map[string]string {
+	m := map[string]string{}
+	if o.Enable != nil {
+		m[labelEnable] = strconv.FormatBool(*o.Enable)
+	}
+	if o.MinAge != "" {
+		m[labelMinAge] = o.MinAge
+	}
+	if o.Verify != nil {
+		m[labelVerify] = strconv.FormatBool(*o.Verify)
+	}
+	for label, v := range map[string]string{
+		labelHookPreCheck:   o.Hooks.PreCheck,
+		labelHookPreUpdate:  o.Hooks.PreUpdate,
+		labelHookPostUpdate: o.Hooks.PostUpdate,
+		labelHookTimeout:    o.Hooks.Timeout,
+		labelStopTimeout:    o.StopTimeout,
+		labelStopSignal:     o.StopSignal,
+	} {
+		if v != "" {
+			m[label] = v
+		}
+	}
+	return m
+}
+
+// validate reports problems with an override's patterns and values.
+func (o Override) validate() []string {
+	var problems []string
+	for _, p := range []string{o.Name, o.Image} {
+		if _, err := path.Match(p, ""); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid pattern %q", p))
+		}
+	}
+	for field, v := range map[string]string{"min_age": o.MinAge, "hooks.timeout": o.Hooks.Timeout} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			problems = append(problems, fmt.Sprintf("%s: %q is not a valid duration", field, v))
+		}
+	}
+	if v := o.StopTimeout; v != "" {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			if d, err := time.ParseDuration(v); err != nil || d < 0 {
+				problems = append(problems, fmt.Sprintf("stop_timeout: %q is not a valid duration or number of seconds", v))
+			}
+		}
+	}
+	if v := o.StopSignal; v != "" {
+		if _, err := container.ParseSignal(v); err != nil {
+			problems = append(problems, fmt.Sprintf("stop_signal: %v", err))
+		}
+	}
+	return problems
+}
+
+// Labels returns a container's labels with matching overrides applied
+// underneath them: overrides fill in settings, later overrides win over
+// earlier ones, and labels set on the container itself win over both.
+func (c Config) Labels(name, image string, labels map[string]string) map[string]string {
+	if len(c.Overrides) == 0 {
+		return labels
+	}
+
+	merged := map[string]string{}
+	for _, o := range c.Overrides {
+		if o.matches(name, image) {
+			for k, v := range o.labels() {
+				merged[k] = v
+			}
+		}
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// fileConfig is the layout of the config file: every setting by its key,
+// plus a list of overrides.
+type fileConfig struct {
+	Settings  map[string]yaml.Node `yaml:",inline"`
+	Overrides []Override           `yaml:"containers"`
+}
+
+// loadFile applies the config file at path to c. It returns the invalid
+// fields it found, or an error if the file cannot be read or parsed.
+func loadFile(c *Config, path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var fc fileConfig
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&fc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var problems []string
+	for _, s := range settings {
+		key := s.key
+		node, ok := fc.Settings[key]
+		if secretNode, isFile := fc.Settings[key+"_file"]; isFile && secretSettings[key] {
+			if ok {
+				problems = append(problems, fmt.Sprintf("%s:%d: %s_file cannot be combined with %s", path, secretNode.Line, key, key))
+				delete(fc.Settings, key)
+				delete(fc.Settings, key+"_file")
+				continue
+			}
+			key, node, ok = key+"_file", secretNode, true
+		}
+		if !ok {
+			continue
+		}
+		delete(fc.Settings, key)
+
+		v, err := scalar(node, listSettings[key])
+		if err == nil && key != s.key {
+			v, err = readSecret(v)
+		}
+		if err == nil {
+			err = s.set(c, v)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s:%d: %s: %v", path, node.Line, key, err))
+		}
+	}
+	unknown := make([]string, 0, len(fc.Settings))
+	for key := range fc.Settings {
+		unknown = append(unknown, key)
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("%s:%d: unknown setting %q", path, fc.Settings[key].Line, key))
+	}
+
+	for i, o := range fc.Overrides {
+		for _, p := range o.validate() {
+			problems = append(problems, fmt.Sprintf("%s: containers[%d]: %s", path, i, p))
+		}
+	}
+	c.Overrides = fc.Overrides
+
+	return problems, nil
+}
+
+// listSettings may be written as a YAML list in the config file, whose
+// items are joined with the separator the setting's value uses.
+var listSettings = map[string]string{
+	"verify_keys": ",",
+	"hosts":       ",",
+	// Passwords may contain commas, so entries are joined by line.
+	"registry_auth": "\n",
+}
+
+// scalar returns the text of a YAML scalar or, if sep is set, of a sequence
+// of scalars joined with sep, so list settings such as verify_keys can be
+// written either way.
+func scalar(n yaml.Node, sep string) (string, error) {
+	switch {
+	case n.Kind == yaml.ScalarNode:
+		return n.Value, nil
+	case n.Kind == yaml.SequenceNode && sep != "":
+		items := make([]string, 0, len(n.Content))
+		for _, item := range n.Content {
+			if item.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("expected a list of values")
+			}
+			items = append(items, item.Value)
+		}
+		return strings.Join(items, sep), nil
+	}
+	return "", fmt.Errorf("expected a value")
+}
@@ -0,0 +1,385 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// setting is one configuration knob. Its key names it in the config file
+// (e.g. "stop_timeout"), as an environment variable (ISENGARD_STOP_TIMEOUT)
+// and as a flag (-stop-timeout).
+type setting struct {
+	key    string
+	usage  string
+	isBool bool
+	set    func(c *Config, v string) error
+}
+
+func (s setting) env() string {
+	return "ISENGARD_" + strings.ToUpper(s.key)
+}
+
+// fromEnv returns the setting's value from its environment variable or, for
+// secrets, the file named by its _FILE variant, along with the variable it
+// came from.
+func (s setting) fromEnv() (value, source string, err error) {
+	value, source = os.Getenv(s.env()), s.env()
+	if !secretSettings[s.key] {
+		return value, source, nil
+	}
+	path := os.Getenv(s.env() + "_FILE")
+	if path == "" {
+		return value, source, nil
+	}
+	source = s.env() + "_FILE"
+	if value != "" {
+		return "", source, fmt.Errorf("cannot be combined with %s", s.env())
+	}
+	value, err = readSecret(path)
+	return value, source, err
+}
+
+func (s setting) flag() string {
+	return strings.ReplaceAll(s.key, "_", "-")
+}
+
+var settings = []setting{
+	{"interval", "time between update cycles", false, func(c *Config, v string) error {
+		return positiveDuration(&c.Interval, v)
+	}},
+	{"run_once", "exit after a single cycle", true, func(c *Config, v string) error {
+		return parseBool(&c.RunOnce, v)
+	}},
+	{"cleanup", "remove old images after updating", true, func(c *Config, v string) error {
+		return parseBool(&c.Cleanup, v)
+	}},
+	{"watch_all", "watch all containers unless disabled by label", true, func(c *Config, v string) error {
+		return parseBool(&c.WatchAll, v)
+	}},
+	{"stop_timeout", "seconds to wait for a container to stop", false, func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("%q is not a positive number of seconds", v)
+		}
+		c.StopTimeout = n
+		return nil
+	}},
+	{"log_level", "debug, info, warn or error", false, func(c *Config, v string) error {
+		switch v {
+		case "debug":
+			c.LogLevel = slog.LevelDebug
+		case "info":
+			c.LogLevel = slog.LevelInfo
+		case "warn":
+			c.LogLevel = slog.LevelWarn
+		case "error":
+			c.LogLevel = slog.LevelError
+		default:
+			return fmt.Errorf("%q is not one of debug, info, warn, error", v)
+		}
+		return nil
+	}},
+	{"self_update", "let isengard update its own container", true, func(c *Config, v string) error {
+		return parseBool(&c.SelfUpdate, v)
+	}},
+	{"events", "check on Docker container start and image pull/tag events", true, func(c *Config, v string) error {
+		return parseBool(&c.Events, v)
+	}},
+	{"events_debounce", "quiet period before checking after Docker events", false, func(c *Config, v string) error {
+		return positiveDuration(&c.EventsDebounce, v)
+	}},
+	{"check_concurrency", "number of containers checked at the same time", false, func(c *Config, v string) error {
+		return positiveInt(&c.CheckConcurrency, v)
+	}},
+	{"registry_concurrency", "maximum concurrent checks against one registry", false, func(c *Config, v string) error {
+		return positiveInt(&c.RegistryConcurrency, v)
+	}},
+	{"hook_timeout", "maximum run time of a lifecycle hook", false, func(c *Config, v string) error {
+		return positiveDuration(&c.HookTimeout, v)
+	}},
+	{"service_update_timeout", "how long to follow a swarm service update for its outcome", false, func(c *Config, v string) error {
+		return positiveDuration(&c.ServiceUpdateTimeout, v)
+	}},
+	{"hooks_dir", "directory of host-side hook scripts", false, func(c *Config, v string) error {
+		c.HooksDir = v
+		return nil
+	}},
+	{"hook_failure", "abort or continue when a hook script fails", false, func(c *Config, v string) error {
+		return oneOf(&c.HookFailure, v, "abort", "continue")
+	}},
+	{"min_age", "minimum age of a new image before it is applied", false, func(c *Config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("%q is not a non-negative duration", v)
+		}
+		c.MinAge = d
+		return nil
+	}},
+	{"min_age_source", "seen or created", false, func(c *Config, v string) error {
+		return oneOf(&c.MinAgeSource, v, "seen", "created")
+	}},
+	{"verify_keys", "comma-separated public keys for signature verification", false, func(c *Config, v string) error {
+		c.VerifyKeys = nil
+		for _, path := range strings.Split(v, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				c.VerifyKeys = append(c.VerifyKeys, path)
+			}
+		}
+		return nil
+	}},
+	{"policy_file", "YAML file of update policy rules", false, func(c *Config, v string) error {
+		c.PolicyFile = v
+		return nil
+	}},
+	{"state_dir", "directory for the persistent update history", false, func(c *Config, v string) error {
+		c.StateDir = v
+		return nil
+	}},
+	{"rollback_keep", "previous images kept per container for rollback", false, func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("%q is not a non-negative number", v)
+		}
+		c.RollbackKeep = n
+		return nil
+	}},
+	{"api_addr", "listen address of the HTTP API", false, func(c *Config, v string) error {
+		c.APIAddr = v
+		return nil
+	}},
+	{"api_token", "bearer token required by the HTTP API", false, func(c *Config, v string) error {
+		c.APIToken = v
+		return nil
+	}},
+	{"webhook_secret", "shared secret that enables and authenticates registry webhooks", false, func(c *Config, v string) error {
+		c.WebhookSecret = v
+		return nil
+	}},
+	{"registries_conf", "Podman registries.conf with short-name aliases", false, func(c *Config, v string) error {
+		c.RegistriesConf = v
+		return nil
+	}},
+	{"hosts", "Docker hosts to manage as name=url entries", false, func(c *Config, v string) error {
+		hosts, err := parseHosts(v)
+		if err != nil {
+			return err
+		}
+		c.Hosts = hosts
+		return nil
+	}},
+	{"tls_dir", "directory of client certificates for tcp:// hosts", false, func(c *Config, v string) error {
+		c.TLSDir = v
+		return nil
+	}},
+	{"ssh_key", "private key file for ssh:// hosts", false, func(c *Config, v string) error {
+		c.SSHKey = v
+		return nil
+	}},
+	{"ssh_known_hosts", "known_hosts file for ssh:// hosts", false, func(c *Config, v string) error {
+		c.SSHKnownHosts = v
+		return nil
+	}},
+	{"registry_auth", "registry credentials as host=username:password entries", false, func(c *Config, v string) error {
+		auth, err := parseRegistryAuth(v)
+		if err != nil {
+			return err
+		}
+		c.RegistryAuth = auth
+		return nil
+	}},
+}
+
+// secretSettings may also be read from a file named by the setting's _FILE
+// variant (e.g. ISENGARD_API_TOKEN_FILE or api_token_file), so they can come
+// from Docker or Swarm secrets. Their values are never logged.
+var secretSettings = map[string]bool{"api_token": true, "webhook_secret": true, "registry_auth": true}
+
+// readSecret reads a secret file, dropping the trailing newline most
+// editors and secret stores add.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading secret: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// parseRegistryAuth parses whitespace-separated host=username:password
+// entries.
+func parseRegistryAuth(v string) (map[string]Credential, error) {
+	auth := map[string]Credential{}
+	for _, entry := range strings.Fields(v) {
+		host, creds, ok := strings.Cut(entry, "=")
+		username, password, ok2 := strings.Cut(creds, ":")
+		if !ok || !ok2 || host == "" || username == "" {
+			// Do not echo the entry, it contains a password.
+			return nil, fmt.Errorf("entry %d is not host=username:password", len(auth)+1)
+		}
+		auth[host] = Credential{Username: username, Password: password}
+	}
+	return auth, nil
+}
+
+// parseHosts parses name=url entries separated by whitespace or commas.
+func parseHosts(v string) ([]Host, error) {
+	var hosts []Host
+	seen := map[string]bool{}
+	for _, entry := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		name, rawURL, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || rawURL == "" {
+			return nil, fmt.Errorf("%q is not name=url", entry)
+		}
+		if strings.Contains(name, "/") {
+			return nil, fmt.Errorf("host name %q must not contain /", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("host %q is listed twice", name)
+		}
+		seen[name] = true
+
+		u, err := url.Parse(rawURL)
+		if err != nil || (u.Scheme != "unix" && u.Scheme != "tcp" && u.Scheme != "ssh") {
+			return nil, fmt.Errorf("host %s: %q is not a unix://, tcp:// or ssh:// URL", name, rawURL)
+		}
+		hosts = append(hosts, Host{Name: name, URL: rawURL})
+	}
+	return hosts, nil
+}
+
+func positiveDuration(dst *time.Duration, v string) error {
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("%q is not a positive duration", v)
+	}
+	*dst = d
+	return nil
+}
+
+func positiveInt(dst *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("%q is not a positive number", v)
+	}
+	*dst = n
+	return nil
+}
+
+func parseBool(dst *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", v)
+	}
+	*dst = b
+	return nil
+}
+
+func oneOf(dst *string, v string, allowed ...string) error {
+	for _, a := range allowed {
+		if v == a {
+			*dst = v
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", v, strings.Join(allowed, ", "))
+}
+
+// ValidationError lists every invalid setting found while loading.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Flags holds configuration given on the command line. The zero value (or a
+// nil *Flags) sets nothing.
+type Flags struct {
+	file   string
+	values map[string]string
+	order  []string
+}
+
+// RegisterFlags adds -config and one flag per setting (e.g. -interval,
+// -run-once) to fs.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: map[string]string{}}
+	fs.StringVar(&f.file, "config", "", "path to a YAML config file (default $ISENGARD_CONFIG)")
+	for _, s := range settings {
+		record := func(v string) error {
+			if _, ok := f.values[s.key]; !ok {
+				f.order = append(f.order, s.key)
+			}
+			f.values[s.key] = v
+			return nil
+		}
+		if s.isBool {
+			fs.BoolFunc(s.flag(), s.usage, record)
+		} else {
+			fs.Func(s.flag(), s.usage, record)
+		}
+	}
+	return f
+}
+
+// Load builds a [Config] from, in increasing order of precedence, defaults,
+// the config file named by -config or ISENGARD_CONFIG, ISENGARD_*
+// environment variables and command-line flags (flags may be nil). Every
+// invalid value is reported in a single [*ValidationError].
+func Load(flags *Flags) (Config, error) {
+	c := defaults()
+	var problems []string
+
+	path := os.Getenv("ISENGARD_CONFIG")
+	if flags != nil && flags.file != "" {
+		path = flags.file
+	}
+	if path != "" {
+		c.File = path
+		fileProblems, err := loadFile(&c, path)
+		if err != nil {
+			return Config{}, err
+		}
+		problems = append(problems, fileProblems...)
+	}
+
+	for _, s := range settings {
+		v, source, err := s.fromEnv()
+		if err == nil && v != "" {
+			err = s.set(&c, v)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
+
+	if flags != nil {
+		for _, key := range flags.order {
+			s, _ := lookup(key)
+			if err := s.set(&c, flags.values[key]); err != nil {
+				problems = append(problems, fmt.Sprintf("-%s: %v", s.flag(), err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return c, &ValidationError{Problems: problems}
+	}
+	return c, nil
+}
+
+func lookup(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
@@ -3,16 +3,32 @@ package container
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
 	"strings"
 
+	cerrdefs "github.com/containerd/errdefs"
 	containertypes "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
-	"github.com/docker/docker/client"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// Suffixes appended to a container's name while it is being recreated: the
+// replacement carries one until the original is stopped, and the original
+// the other until the replacement has started.
+const (
+	replacementSuffix = "-isengard-new"
+	originalSuffix    = "-isengard-old"
 )
 
+// ErrRestored is wrapped by the error of a failed [Recreate] that put the
+// original container back in place and running.
+var ErrRestored = errors.New("original container restored")
+
 // Info captures the subset of container state needed for update checks
 // and faithful recreation.
 type Info struct {
@@ -29,7 +45,7 @@ type Info struct {
 
 // ListRunning returns all running containers, enriching each with
 // RepoDigests from an image inspect call for digest-based update checks.
-func ListRunning(ctx context.Context, cli *client.Client) ([]Info, error) {
+func ListRunning(ctx context.Context, cli docker.API) ([]Info, error) {
 	containers, err := cli.ContainerList(ctx, containertypes.ListOptions{All: false})
 	if err != nil {
 		return nil, fmt.Errorf("listing containers: %w", err)
@@ -65,9 +81,17 @@ func ListRunning(ctx context.Context, cli *client.Client) ([]Info, error) {
 	return result, nil
 }
 
-// Recreate stops, removes, and recreates a container with the same config
-// but a new image. Returns the new container ID.
-func Recreate(ctx context.Context, cli *client.Client, containerID, newImageID string, stopTimeout int) (string, error) {
+// Recreate replaces a container with one with the same config but a new
+// image, except for the settings it inherited from the old image, which
+// follow the new image's defaults. The replacement is checked and created first, under a temporary
+// name. Only then is the original stopped and renamed out of the way, and
+// the replacement renamed and started. The original is removed once the
+// replacement runs; if any step after the create fails, the replacement is
+// removed and the original renamed back and started again, and the error
+// wraps [ErrRestored]. The original is stopped with [Stop], given labels.
+// On Podman, a container in a pod is recreated in the same pod. Returns
+// the new container ID.
+func Recreate(ctx context.Context, cli docker.API, containerID, newImageID string, labels map[string]string, stopTimeout int, engine docker.Engine) (string, error) {
 	inspect, err := cli.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return "", fmt.Errorf("inspecting container: %w", err)
@@ -78,96 +102,193 @@ func Recreate(ctx context.Context, cli *client.Client, containerID, newImageID s
 		containerName = containerName[1:]
 	}
 
+	pod := ""
+	if engine == docker.EnginePodman {
+		if pod, err = podOf(ctx, cli, containerID); err != nil {
+			return "", err
+		}
+	}
+
 	slog.Debug("captured container config",
 		"container", containerName,
 		"old_image", inspect.Config.Image,
 	)
 
-	// Stop
-	timeout := stopTimeout
-	stopOpts := containertypes.StopOptions{Timeout: &timeout}
-	if err := cli.ContainerStop(ctx, containerID, stopOpts); err != nil {
-		slog.Warn("error stopping container, forcing remove", "container", containerName, "error", err)
-	}
-
-	// Remove
-	if err := cli.ContainerRemove(ctx, containerID, containertypes.RemoveOptions{Force: true}); err != nil {
-		return "", fmt.Errorf("removing container %s: %w", containerName, err)
-	}
-
 	// Build new config
 	config := inspect.Config
 	config.Image = newImageID
 
+	// Settings inherited from the old image follow the new image's defaults
+	followImageDefaults(ctx, cli, containerName, config, inspect.Image, newImageID)
+
 	hostConfig := inspect.HostConfig
 
-	// Convert mounts back to proper mount configuration
-	if len(inspect.Mounts) > 0 && len(hostConfig.Mounts) == 0 {
-		hostConfig.Mounts = convertMounts(inspect.Mounts)
-	}
+	// Rebuild mounts, keeping volumes attached by name
+	hostConfig.Mounts = recreateMounts(hostConfig, inspect.Mounts)
 
 	// Remove mounts/volumes that overlap with binds to prevent Docker from
 	// rejecting the create with "Duplicate mount point".
 	deduplicateMounts(hostConfig)
 	deduplicateVolumes(config, hostConfig)
 
-	// Prepare networking — connect to the first network during create
+	// Make sure the replacement can be created before touching the original
+	if err := preflight(ctx, cli, inspect, hostConfig, engine); err != nil {
+		return "", err
+	}
+
+	// Create the replacement under a temporary name while the original is
+	// still running, so that a create failure leaves it untouched.
+	newID, err := create(ctx, cli, containerName+replacementSuffix, pod, engine, inspect, config, hostConfig, true)
+	if err != nil {
+		return "", fmt.Errorf("creating container %s: %w", containerName, err)
+	}
+
+	r := restorer{cli: cli, name: containerName, originalID: containerID, replacementID: newID}
+
+	// Stop
+	if err := Stop(ctx, cli, inspect, labels, stopTimeout); err != nil {
+		slog.Warn("error stopping container", "container", containerName, "error", err)
+	}
+	r.stopped = true
+
+	// Keep the original, under another name, until the replacement runs
+	if err := cli.ContainerRename(ctx, containerID, containerName+originalSuffix); err != nil {
+		return "", r.restore(ctx, fmt.Errorf("renaming container %s: %w", containerName, err))
+	}
+	r.renamed = true
+
+	// Take over the name
+	if err := cli.ContainerRename(ctx, newID, containerName); err != nil {
+		return "", r.restore(ctx, fmt.Errorf("renaming replacement of %s: %w", containerName, err))
+	}
+
+	// Start
+	if err := cli.ContainerStart(ctx, newID, containertypes.StartOptions{}); err != nil {
+		return "", r.restore(ctx, fmt.Errorf("starting container %s: %w", containerName, err))
+	}
+
+	// Remove the original
+	if err := cli.ContainerRemove(ctx, containerID, containertypes.RemoveOptions{Force: true}); err != nil {
+		slog.Warn("could not remove the replaced container", "container", containerName+originalSuffix, "error", err)
+	}
+
+	return newID, nil
+}
+
+// restorer undoes a failed [Recreate] once the original has been stopped.
+type restorer struct {
+	cli           docker.API
+	name          string // Original name of the container.
+	originalID    string
+	replacementID string
+	renamed       bool // Whether the original was renamed out of the way.
+	stopped       bool // Whether the original was stopped.
+}
+
+// restore removes the replacement and renames the original back and, if it
+// was stopped, starts it. It returns cause wrapped with [ErrRestored], or, if the original could
+// not be brought back, with the reason it could not.
+func (r restorer) restore(ctx context.Context, cause error) error {
+	slog.Warn("update failed, restoring the original container", "container", r.name, "error", cause)
+
+	// Restore even if the update was cancelled.
+	ctx = context.WithoutCancel(ctx)
+
+	// The replacement may hold the name; it must go before the original can
+	// take it back.
+	if err := r.cli.ContainerRemove(ctx, r.replacementID, containertypes.RemoveOptions{Force: true}); err != nil && !cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("%w; removing the replacement: %v", cause, err)
+	}
+	if r.renamed {
+		if err := r.cli.ContainerRename(ctx, r.originalID, r.name); err != nil {
+			return fmt.Errorf("%w; renaming the original back: %v", cause, err)
+		}
+	}
+	if r.stopped {
+		if err := r.cli.ContainerStart(ctx, r.originalID, containertypes.StartOptions{}); err != nil {
+			return fmt.Errorf("%w; restarting the original: %v", cause, err)
+		}
+	}
+	return fmt.Errorf("%w (%w)", cause, ErrRestored)
+}
+
+// create creates the replacement for an inspected container under name,
+// joining the networks the old one was connected to. A Podman container in
+// a pod is created in pod instead, where it shares the pod's networking.
+//
+// The MAC addresses the old container was configured with are kept if
+// keepMAC is set; a replacement that starts while the old container still
+// runs must not take them, or both would answer to the same address.
+func create(ctx context.Context, cli docker.API, name, pod string, engine docker.Engine, inspect containertypes.InspectResponse, config *containertypes.Config, hostConfig *containertypes.HostConfig, keepMAC bool) (string, error) {
+	if pod != "" {
+		spec, err := newPodSpec(name, pod, config.Image, config, hostConfig)
+		if err != nil {
+			return "", err
+		}
+		return createInPod(ctx, cli, spec)
+	}
+
+	// Join the primary network during create and connect the others
+	// afterwards, in a fixed order.
 	var networkingConfig *network.NetworkingConfig
-	additionalNetworks := map[string]*network.EndpointSettings{}
+	var additionalNetworks []string
+	oldName := strings.TrimPrefix(inspect.Name, "/")
 
-	if inspect.NetworkSettings != nil && len(inspect.NetworkSettings.Networks) > 0 {
-		first := true
-		for netName, netSettings := range inspect.NetworkSettings.Networks {
-			epSettings := &network.EndpointSettings{
-				Aliases: netSettings.Aliases,
-			}
-			if netSettings.IPAMConfig != nil {
-				epSettings.IPAMConfig = &network.EndpointIPAMConfig{
-					IPv4Address: netSettings.IPAMConfig.IPv4Address,
-				}
-			}
-			if first {
-				networkingConfig = &network.NetworkingConfig{
-					EndpointsConfig: map[string]*network.EndpointSettings{
-						netName: epSettings,
-					},
-				}
-				first = false
-			} else {
-				additionalNetworks[netName] = epSettings
-			}
+	networks := inspect.NetworkSettings != nil && len(inspect.NetworkSettings.Networks) > 0
+	if engine == docker.EnginePodman && !podmanNetworkMode(hostConfig.NetworkMode) {
+		networks = false
+	}
+	var order []string
+	macAddress := func(netName string) string {
+		if !keepMAC {
+			return ""
+		}
+		return configuredMAC(inspect, netName, netName == order[0])
+	}
+
+	if networks {
+		order = networkOrder(hostConfig.NetworkMode, inspect.NetworkSettings.Networks)
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				order[0]: endpointConfig(inspect.ID, oldName, inspect.NetworkSettings.Networks[order[0]], macAddress(order[0])),
+			},
 		}
+		additionalNetworks = order[1:]
 	}
 
-	// Create
-	createResp, err := cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, containerName)
+	// The deprecated Config.MacAddress has been moved to the primary
+	// network's endpoint, where Docker wants it now.
+	if networks || !keepMAC {
+		config.MacAddress = ""
+	}
+
+	createResp, err := cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, name)
 	if err != nil {
-		return "", fmt.Errorf("creating container %s: %w", containerName, err)
+		return "", err
 	}
 
 	// Connect additional networks
-	for netName, epSettings := range additionalNetworks {
+	for _, netName := range additionalNetworks {
+		epSettings := endpointConfig(inspect.ID, oldName, inspect.NetworkSettings.Networks[netName], macAddress(netName))
 		if err := cli.NetworkConnect(ctx, netName, createResp.ID, epSettings); err != nil {
-			slog.Warn("failed to connect network", "container", containerName, "network", netName, "error", err)
+			slog.Warn("failed to connect network", "container", name, "network", netName, "error", err)
 		}
 	}
 
-	// Start
-	if err := cli.ContainerStart(ctx, createResp.ID, containertypes.StartOptions{}); err != nil {
-		return "", fmt.Errorf("starting container %s: %w", containerName, err)
-	}
-
 	return createResp.ID, nil
 }
 
 // RecreateSelf recreates Isengard's own container with a safe ordering that
-// ensures the replacement is running before the old container is killed.
+// ensures the replacement is running before the old container is stopped.
 //
-// Unlike [Recreate], which does stop -> remove -> create -> start, this
-// function does: inspect -> rename self -> create replacement -> start
-// replacement -> force-remove self. This prevents the race where stopping
-// our own container kills the process before the replacement is created.
-func RecreateSelf(ctx context.Context, cli *client.Client, containerID, newImage string, _ int) (string, error) {
+// Unlike [Recreate], which does create -> stop -> rename -> start -> remove,
+// this function does: inspect -> rename self -> create replacement -> start
+// replacement. The old container keeps running until the replacement stops
+// and removes it with [Stop] when it starts, since stopping our own
+// container from this process would kill it mid-request. The caller must
+// stop updating in the meantime. If the replacement fails to start, it is
+// removed and self renamed back, and the error wraps [ErrRestored].
+func RecreateSelf(ctx context.Context, cli docker.API, containerID, newImage string, engine docker.Engine) (string, error) {
 	inspect, err := cli.ContainerInspect(ctx, containerID)
 	if err != nil {
 		return "", fmt.Errorf("inspecting container: %w", err)
@@ -178,6 +299,13 @@ func RecreateSelf(ctx context.Context, cli *client.Client, containerID, newImage
 		containerName = containerName[1:]
 	}
 
+	pod := ""
+	if engine == docker.EnginePodman {
+		if pod, err = podOf(ctx, cli, containerID); err != nil {
+			return "", err
+		}
+	}
+
 	slog.Debug("self-update: captured container config",
 		"container", containerName,
 		"old_image", inspect.Config.Image,
@@ -186,41 +314,16 @@ func RecreateSelf(ctx context.Context, cli *client.Client, containerID, newImage
 	// Build new config with updated image
 	config := inspect.Config
 	config.Image = newImage
+	followImageDefaults(ctx, cli, containerName, config, inspect.Image, newImage)
 
 	hostConfig := inspect.HostConfig
 
-	if len(inspect.Mounts) > 0 && len(hostConfig.Mounts) == 0 {
-		hostConfig.Mounts = convertMounts(inspect.Mounts)
-	}
+	hostConfig.Mounts = recreateMounts(hostConfig, inspect.Mounts)
 	deduplicateMounts(hostConfig)
 	deduplicateVolumes(config, hostConfig)
 
-	// Prepare networking
-	var networkingConfig *network.NetworkingConfig
-	additionalNetworks := map[string]*network.EndpointSettings{}
-
-	if inspect.NetworkSettings != nil && len(inspect.NetworkSettings.Networks) > 0 {
-		first := true
-		for netName, netSettings := range inspect.NetworkSettings.Networks {
-			epSettings := &network.EndpointSettings{
-				Aliases: netSettings.Aliases,
-			}
-			if netSettings.IPAMConfig != nil {
-				epSettings.IPAMConfig = &network.EndpointIPAMConfig{
-					IPv4Address: netSettings.IPAMConfig.IPv4Address,
-				}
-			}
-			if first {
-				networkingConfig = &network.NetworkingConfig{
-					EndpointsConfig: map[string]*network.EndpointSettings{
-						netName: epSettings,
-					},
-				}
-				first = false
-			} else {
-				additionalNetworks[netName] = epSettings
-			}
-		}
+	if err := preflight(ctx, cli, inspect, hostConfig, engine); err != nil {
+		return "", err
 	}
 
 	// Rename self to free up the container name for the replacement.
@@ -232,34 +335,23 @@ func RecreateSelf(ctx context.Context, cli *client.Client, containerID, newImage
 
 	// Create replacement with the original name
 	slog.Debug("self-update: creating replacement", "container", containerName, "image", newImage)
-	createResp, err := cli.ContainerCreate(ctx, config, hostConfig, networkingConfig, nil, containerName)
+	newID, err := create(ctx, cli, containerName, pod, engine, inspect, config, hostConfig, false)
 	if err != nil {
 		// Try to restore original name if create fails
 		_ = cli.ContainerRename(ctx, containerID, containerName)
 		return "", fmt.Errorf("creating replacement: %w", err)
 	}
 
-	// Connect additional networks
-	for netName, epSettings := range additionalNetworks {
-		if err := cli.NetworkConnect(ctx, netName, createResp.ID, epSettings); err != nil {
-			slog.Warn("failed to connect network", "container", containerName, "network", netName, "error", err)
-		}
+	// Start replacement. It fails if we publish host ports, which we still
+	// hold; put ourselves back under our name then.
+	slog.Info("self-update: starting replacement", "container", containerName, "new_id", newID[:12])
+	if err := cli.ContainerStart(ctx, newID, containertypes.StartOptions{}); err != nil {
+		r := restorer{cli: cli, name: containerName, originalID: containerID, replacementID: newID, renamed: true}
+		return "", r.restore(ctx, fmt.Errorf("starting replacement: %w", err))
 	}
 
-	// Start replacement
-	slog.Info("self-update: starting replacement", "container", containerName, "new_id", createResp.ID[:12])
-	if err := cli.ContainerStart(ctx, createResp.ID, containertypes.StartOptions{}); err != nil {
-		return "", fmt.Errorf("starting replacement: %w", err)
-	}
-
-	// Replacement is running. Force-remove ourselves. This sends SIGKILL and
-	// our process dies immediately, but that's fine because the new container
-	// is already running.
-	slog.Info("self-update: replacement started, removing old container")
-	_ = cli.ContainerRemove(ctx, containerID, containertypes.RemoveOptions{Force: true})
-
-	// If we reach here, something unexpected happened.
-	return createResp.ID, nil
+	slog.Info("self-update: replacement started, it will stop this container")
+	return newID, nil
 }
 
 // deduplicateVolumes removes entries from config.Volumes whose paths are
@@ -295,9 +387,9 @@ func deduplicateVolumes(config *containertypes.Config, hostConfig *containertype
 // deduplicateMounts removes entries from hostConfig.Mounts whose target paths
 // are already covered by hostConfig.Binds. When Docker inspects a container,
 // bind mounts may appear in both HostConfig.Binds and the top-level Mounts
-// array. If convertMounts then populates HostConfig.Mounts from the inspect
-// Mounts, the same path ends up in both Binds and Mounts, causing Docker to
-// reject the create with "Duplicate mount point".
+// array. Mounts rebuilt from the inspect Mounts must not repeat them, or the
+// same path ends up in both Binds and Mounts, causing Docker to reject the
+// create with "Duplicate mount point".
 func deduplicateMounts(hostConfig *containertypes.HostConfig) {
 	if len(hostConfig.Mounts) == 0 || len(hostConfig.Binds) == 0 {
 		return
@@ -320,17 +412,89 @@ func deduplicateMounts(hostConfig *containertypes.HostConfig) {
 	hostConfig.Mounts = filtered
 }
 
+// recreateMounts returns the mounts for the replacement of a container:
+// the mounts it was created with, keeping all their options, plus one for
+// every mount point its binds, mounts and tmpfs mounts do not cover, such as
+// the anonymous volumes of the image's VOLUMEs. Volumes are referenced by
+// name, so anonymous volumes are reattached to the same data instead of
+// being replaced by new, empty ones.
+func recreateMounts(hostConfig *containertypes.HostConfig, mountPoints []containertypes.MountPoint) []mount.Mount {
+	byTarget := make(map[string]containertypes.MountPoint, len(mountPoints))
+	for _, mp := range mountPoints {
+		byTarget[mp.Destination] = mp
+	}
+
+	covered := make(map[string]bool, len(hostConfig.Binds)+len(hostConfig.Mounts)+len(hostConfig.Tmpfs))
+	for _, b := range hostConfig.Binds {
+		parts := strings.SplitN(b, ":", 3)
+		if len(parts) >= 2 {
+			covered[parts[1]] = true
+		}
+	}
+	for target := range hostConfig.Tmpfs {
+		covered[target] = true
+	}
+
+	var mounts []mount.Mount
+	for _, m := range hostConfig.Mounts {
+		// A volume mount without a source got an anonymous volume.
+		if m.Type == mount.TypeVolume && m.Source == "" {
+			if mp, ok := byTarget[m.Target]; ok && mp.Name != "" {
+				m.Source = mp.Name
+			}
+		}
+		covered[m.Target] = true
+		mounts = append(mounts, m)
+	}
+
+	var uncovered []containertypes.MountPoint
+	for _, mp := range mountPoints {
+		if !covered[mp.Destination] {
+			uncovered = append(uncovered, mp)
+		}
+	}
+	return append(mounts, convertMounts(uncovered)...)
+}
+
 // convertMounts converts docker inspect mount points back to mount.Mount format.
 func convertMounts(mountPoints []containertypes.MountPoint) []mount.Mount {
 	var mounts []mount.Mount
 	for _, mp := range mountPoints {
-		m := mount.Mount{
-			Type:     mount.Type(mp.Type),
-			Source:   mp.Source,
-			Target:   mp.Destination,
-			ReadOnly: !mp.RW,
-		}
-		mounts = append(mounts, m)
+		mounts = append(mounts, convertMount(mp))
 	}
 	return mounts
 }
+
+// convertMount converts a docker inspect mount point back to a mount. A
+// volume is referenced by name rather than by its storage location on the
+// host, which would turn it into a bind mount.
+func convertMount(mp containertypes.MountPoint) mount.Mount {
+	m := mount.Mount{
+		Type:     mp.Type,
+		Source:   mp.Source,
+		Target:   mp.Destination,
+		ReadOnly: !mp.RW,
+	}
+
+	switch mp.Type {
+	case mount.TypeVolume:
+		if mp.Name != "" {
+			m.Source = mp.Name
+		}
+		var opts mount.VolumeOptions
+		if mp.Driver != "" && mp.Driver != "local" {
+			opts.DriverConfig = &mount.Driver{Name: mp.Driver}
+		}
+		opts.NoCopy = slices.Contains(strings.Split(mp.Mode, ","), "nocopy")
+		if opts.DriverConfig != nil || opts.NoCopy {
+			m.VolumeOptions = &opts
+		}
+	case mount.TypeBind:
+		if mp.Propagation != "" {
+			m.BindOptions = &mount.BindOptions{Propagation: mp.Propagation}
+		}
+	case mount.TypeTmpfs:
+		m.Source = ""
+	}
+	return m
+}
@@ -1,10 +1,17 @@
 package container
 
 import (
+	"context"
+	"errors"
+	"reflect"
+	"slices"
 	"testing"
 
 	containertypes "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
 )
 
 func TestDeduplicateVolumes_OverlapWithMounts(t *testing.T) {
@@ -193,3 +200,293 @@ func TestConvertMounts_Empty(t *testing.T) {
 		t.Errorf("expected empty result, got %d mounts", len(result))
 	}
 }
+
+// runWeb starts a "web" container from nginx:1.25 on the backend and
+// frontend networks, with a bind mount and a restart policy.
+func runWeb(d *fakedocker.Daemon) string {
+	d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:old"})
+	d.AddNetwork("backend")
+	d.AddNetwork("frontend")
+	return d.Run("web",
+		&containertypes.Config{Image: "nginx:1.25", Env: []string{"A=1"}, Labels: map[string]string{"app": "web"}},
+		&containertypes.HostConfig{
+			Binds:         []string{"/srv/web:/usr/share/nginx/html:ro"},
+			RestartPolicy: containertypes.RestartPolicy{Name: containertypes.RestartPolicyAlways},
+		},
+		"backend", "frontend",
+	)
+}
+
+func TestRecreate(t *testing.T) {
+	d := fakedocker.New()
+	oldID := runWeb(d)
+	newImageID := d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:new"})
+
+	newID, err := Recreate(context.Background(), d, oldID, "nginx:1.25", nil, 10, docker.EngineDocker)
+	if err != nil {
+		t.Fatalf("Recreate: %v", err)
+	}
+	if newID == oldID {
+		t.Fatal("Recreate returned the old container ID")
+	}
+	if names := d.ContainerNames(); !slices.Equal(names, []string{"web"}) {
+		t.Errorf("containers = %v, want [web]", names)
+	}
+
+	c, ok := d.Container("web")
+	if !ok {
+		t.Fatal("replacement not found")
+	}
+	if c.ID != newID || !c.State.Running {
+		t.Errorf("replacement %s running=%v, want %s running", c.ID, c.State.Running, newID)
+	}
+	if c.Image != newImageID {
+		t.Errorf("image = %s, want %s", c.Image, newImageID)
+	}
+	if c.Config.Labels["app"] != "web" || !slices.Equal(c.Config.Env, []string{"A=1"}) {
+		t.Errorf("config not preserved: labels %v env %v", c.Config.Labels, c.Config.Env)
+	}
+	if c.HostConfig.RestartPolicy.Name != containertypes.RestartPolicyAlways {
+		t.Errorf("restart policy = %q, want always", c.HostConfig.RestartPolicy.Name)
+	}
+	if !slices.Equal(c.HostConfig.Binds, []string{"/srv/web:/usr/share/nginx/html:ro"}) {
+		t.Errorf("binds = %v", c.HostConfig.Binds)
+	}
+	for _, n := range []string{"backend", "frontend"} {
+		if _, ok := c.NetworkSettings.Networks[n]; !ok {
+			t.Errorf("replacement not connected to %s", n)
+		}
+	}
+}
+
+func TestRecreate_MissingContainer(t *testing.T) {
+	d := fakedocker.New()
+	if _, err := Recreate(context.Background(), d, "nope", "nginx:1.25", nil, 10, docker.EngineDocker); err == nil {
+		t.Fatal("Recreate of a missing container succeeded")
+	}
+}
+
+func TestRecreate_Restore(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		wantRestored bool
+	}{
+		{name: "replacement fails to start", method: "ContainerStart", wantRestored: true},
+		{name: "original cannot be renamed", method: "ContainerRename", wantRestored: true},
+		{name: "replacement cannot be removed", method: "ContainerRemove"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := fakedocker.New()
+			oldID := runWeb(d)
+			d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:new"})
+			injected := errors.New("injected failure")
+			if tt.method == "ContainerRemove" {
+				// Fail the start, so that the restore has to remove the
+				// replacement, which then fails too.
+				d.Fail("ContainerStart", injected)
+			}
+			d.Fail(tt.method, injected)
+
+			_, err := Recreate(context.Background(), d, oldID, "nginx:1.25", nil, 10, docker.EngineDocker)
+			if !errors.Is(err, injected) {
+				t.Fatalf("Recreate error = %v, want the injected failure", err)
+			}
+			if got := errors.Is(err, ErrRestored); got != tt.wantRestored {
+				t.Fatalf("errors.Is(%v, ErrRestored) = %v, want %v", err, got, tt.wantRestored)
+			}
+			if !tt.wantRestored {
+				return
+			}
+
+			c, ok := d.Container("web")
+			if !ok || c.ID != oldID || !c.State.Running {
+				t.Errorf("original container not running under its name")
+			}
+			if names := d.ContainerNames(); !slices.Equal(names, []string{"web"}) {
+				t.Errorf("containers after restore = %v, want [web]", names)
+			}
+		})
+	}
+}
+
+func TestRecreateSelf(t *testing.T) {
+	d := fakedocker.New()
+	oldID := runWeb(d)
+	d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:new"})
+
+	newID, err := RecreateSelf(context.Background(), d, oldID, "nginx:1.25", docker.EngineDocker)
+	if err != nil {
+		t.Fatalf("RecreateSelf: %v", err)
+	}
+	if names := d.ContainerNames(); !slices.Equal(names, []string{"web", "web-old"}) {
+		t.Errorf("containers = %v, want [web web-old]", names)
+	}
+	if c, _ := d.Container("web"); c.ID != newID || !c.State.Running {
+		t.Errorf("web is %s running=%v, want replacement %s running", c.ID, c.State.Running, newID)
+	}
+
+	// The old container is left running for the replacement to stop, since
+	// stopping it ends the process doing the update.
+	if c, _ := d.Container("web-old"); c.ID != oldID || !c.State.Running {
+		t.Errorf("old container not left running as web-old")
+	}
+}
+
+func TestRecreateSelf_MACAddress(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "isengard"})
+	resp, err := d.ContainerCreate(context.Background(),
+		&containertypes.Config{Image: "isengard", MacAddress: "02:00:00:aa:bb:cc"},
+		&containertypes.HostConfig{}, nil, nil, "isengard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ContainerStart(context.Background(), resp.ID, containertypes.StartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old container keeps running next to the replacement, so its
+	// configured MAC address must not be taken over.
+	newID, err := RecreateSelf(context.Background(), d, resp.ID, "isengard", docker.EngineDocker)
+	if err != nil {
+		t.Fatalf("RecreateSelf: %v", err)
+	}
+	c, _ := d.Container(newID)
+	if mac := c.NetworkSettings.Networks["bridge"].MacAddress; mac == "02:00:00:aa:bb:cc" {
+		t.Errorf("replacement took over the running container's MAC address %s", mac)
+	}
+}
+
+func TestRecreateSelf_CreateFailureRestoresName(t *testing.T) {
+	d := fakedocker.New()
+	oldID := runWeb(d)
+	d.Fail("ContainerCreate", errors.New("no space left on device"))
+
+	if _, err := RecreateSelf(context.Background(), d, oldID, "nginx:1.25", docker.EngineDocker); err == nil {
+		t.Fatal("RecreateSelf succeeded despite a create failure")
+	}
+	c, ok := d.Container("web")
+	if !ok || c.ID != oldID || !c.State.Running {
+		t.Errorf("original container not left running under its name: %v", d.ContainerNames())
+	}
+}
+
+func TestRecreateSelf_StartFailureRestoresName(t *testing.T) {
+	d := fakedocker.New()
+	oldID := runWeb(d)
+	d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:new"})
+	d.Fail("ContainerStart", errors.New("port is already allocated"))
+
+	_, err := RecreateSelf(context.Background(), d, oldID, "nginx:1.25", docker.EngineDocker)
+	if !errors.Is(err, ErrRestored) {
+		t.Fatalf("RecreateSelf error = %v, want ErrRestored", err)
+	}
+	if names := d.ContainerNames(); !slices.Equal(names, []string{"web"}) {
+		t.Errorf("containers = %v, want [web]", names)
+	}
+	if c, _ := d.Container("web"); c.ID != oldID || !c.State.Running {
+		t.Errorf("original container not left running under its name")
+	}
+}
+
+func TestConvertMount(t *testing.T) {
+	tests := []struct {
+		name string
+		mp   containertypes.MountPoint
+		want mount.Mount
+	}{
+		{
+			name: "volume by name, not storage path",
+			mp: containertypes.MountPoint{
+				Type: mount.TypeVolume, Name: "pgdata", Source: "/var/lib/docker/volumes/pgdata/_data",
+				Destination: "/var/lib/postgresql/data", Driver: "local", RW: true,
+			},
+			want: mount.Mount{Type: mount.TypeVolume, Source: "pgdata", Target: "/var/lib/postgresql/data"},
+		},
+		{
+			name: "volume driver and nocopy",
+			mp: containertypes.MountPoint{
+				Type: mount.TypeVolume, Name: "shared", Source: "/mnt/nfs/shared",
+				Destination: "/shared", Driver: "nfs", Mode: "nocopy", RW: true,
+			},
+			want: mount.Mount{
+				Type: mount.TypeVolume, Source: "shared", Target: "/shared",
+				VolumeOptions: &mount.VolumeOptions{NoCopy: true, DriverConfig: &mount.Driver{Name: "nfs"}},
+			},
+		},
+		{
+			name: "bind propagation",
+			mp: containertypes.MountPoint{
+				Type: mount.TypeBind, Source: "/mnt", Destination: "/mnt", RW: false, Propagation: mount.PropagationRShared,
+			},
+			want: mount.Mount{
+				Type: mount.TypeBind, Source: "/mnt", Target: "/mnt", ReadOnly: true,
+				BindOptions: &mount.BindOptions{Propagation: mount.PropagationRShared},
+			},
+		},
+		{
+			name: "tmpfs",
+			mp:   containertypes.MountPoint{Type: mount.TypeTmpfs, Destination: "/run", RW: true},
+			want: mount.Mount{Type: mount.TypeTmpfs, Target: "/run"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertMount(tt.mp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertMount =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecreateMounts(t *testing.T) {
+	hostConfig := &containertypes.HostConfig{
+		Binds: []string{"/srv/conf:/etc/app:ro"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeTmpfs, Target: "/tmp", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1 << 20}},
+			{Type: mount.TypeVolume, Target: "/cache"},
+			{Type: mount.TypeVolume, Source: "logs", Target: "/logs", VolumeOptions: &mount.VolumeOptions{Subpath: "app"}},
+		},
+		Tmpfs: map[string]string{"/run": "size=64k"},
+	}
+	points := []containertypes.MountPoint{
+		{Type: mount.TypeBind, Source: "/srv/conf", Destination: "/etc/app"},
+		{Type: mount.TypeTmpfs, Destination: "/tmp", RW: true},
+		{Type: mount.TypeVolume, Name: "c0ffee", Source: "/var/lib/docker/volumes/c0ffee/_data", Destination: "/cache", RW: true},
+		{Type: mount.TypeVolume, Name: "logs", Source: "/var/lib/docker/volumes/logs/_data", Destination: "/logs", RW: true},
+		{Type: mount.TypeVolume, Name: "d00d", Source: "/var/lib/docker/volumes/d00d/_data", Destination: "/data", RW: true},
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeTmpfs, Target: "/tmp", TmpfsOptions: &mount.TmpfsOptions{SizeBytes: 1 << 20}},
+		{Type: mount.TypeVolume, Source: "c0ffee", Target: "/cache"},
+		{Type: mount.TypeVolume, Source: "logs", Target: "/logs", VolumeOptions: &mount.VolumeOptions{Subpath: "app"}},
+		{Type: mount.TypeVolume, Source: "d00d", Target: "/data"},
+	}
+	if got := recreateMounts(hostConfig, points); !reflect.DeepEqual(got, want) {
+		t.Errorf("recreateMounts =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestRecreate_AnonymousVolume(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "postgres:16"})
+	oldID := d.Run("db",
+		&containertypes.Config{Image: "postgres:16", Volumes: map[string]struct{}{"/var/lib/postgresql/data": {}}},
+		&containertypes.HostConfig{Binds: []string{"pgconf:/etc/postgresql"}},
+	)
+	old, _ := d.Container(oldID)
+
+	newID, err := Recreate(context.Background(), d, oldID, "postgres:16", nil, 10, docker.EngineDocker)
+	if err != nil {
+		t.Fatalf("Recreate: %v", err)
+	}
+	c, _ := d.Container(newID)
+	if !reflect.DeepEqual(c.Mounts, old.Mounts) {
+		t.Errorf("mounts changed:\n got %+v\nwant %+v", c.Mounts, old.Mounts)
+	}
+}
@@ -0,0 +1,162 @@
+package container
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// defaultChange is a setting a container inherited from its old image that
+// follows the new image's default.
+type defaultChange struct {
+	field string // e.g. "Cmd" or "Env PATH".
+	old   string
+	new   string
+}
+
+// followImageDefaults updates the settings config inherited from the old
+// image to the defaults of the new one, logging each one that changes. If
+// either image cannot be inspected, config is left as it is.
+func followImageDefaults(ctx context.Context, cli docker.API, name string, config *containertypes.Config, oldImage, newImage string) {
+	images := make([]ocispec.ImageConfig, 2)
+	for i, ref := range []string{oldImage, newImage} {
+		inspect, err := cli.ImageInspect(ctx, ref)
+		if err != nil {
+			slog.Warn("could not inspect image, keeping the container's settings as they are", "container", name, "image", ref, "error", err)
+			return
+		}
+		if inspect.Config != nil {
+			images[i] = inspect.Config.ImageConfig
+		}
+	}
+
+	for _, c := range applyImageDefaults(config, images[0], images[1]) {
+		slog.Info("following new image default", "container", name, "field", c.field, "old", c.old, "new", c.new)
+	}
+}
+
+// applyImageDefaults replaces the settings of config that equal the old
+// image's defaults, and so were inherited from it, with the new image's:
+// environment variables, entrypoint and command, user, exposed ports and
+// volumes. Settings that differ from the old image's were set on the
+// container and are kept. A value set on the container that happens to
+// equal the old image's default cannot be told apart and follows the new
+// image too.
+//
+// The command is only inherited along with the entrypoint, since Docker
+// drops the image's command when the entrypoint is overridden.
+func applyImageDefaults(config *containertypes.Config, oldImage, newImage ocispec.ImageConfig) []defaultChange {
+	changes := applyEnvDefaults(config, oldImage.Env, newImage.Env)
+
+	if slices.Equal(config.Entrypoint, oldImage.Entrypoint) {
+		inheritedCmd := slices.Equal(config.Cmd, oldImage.Cmd)
+		if !slices.Equal(config.Entrypoint, newImage.Entrypoint) {
+			changes = append(changes, defaultChange{"Entrypoint", strings.Join(config.Entrypoint, " "), strings.Join(newImage.Entrypoint, " ")})
+			config.Entrypoint = slices.Clone(newImage.Entrypoint)
+		}
+		if inheritedCmd && !slices.Equal(config.Cmd, newImage.Cmd) {
+			changes = append(changes, defaultChange{"Cmd", strings.Join(config.Cmd, " "), strings.Join(newImage.Cmd, " ")})
+			config.Cmd = slices.Clone(newImage.Cmd)
+		}
+	}
+
+	if config.User == oldImage.User && config.User != newImage.User {
+		changes = append(changes, defaultChange{"User", config.User, newImage.User})
+		config.User = newImage.User
+	}
+
+	ports := make(map[string]struct{}, len(config.ExposedPorts))
+	for p := range config.ExposedPorts {
+		ports[string(p)] = struct{}{}
+	}
+	if c, ok := applySetDefaults("ExposedPorts", ports, oldImage.ExposedPorts, newImage.ExposedPorts); ok {
+		changes = append(changes, c)
+		config.ExposedPorts = nat.PortSet{}
+		for p := range ports {
+			config.ExposedPorts[nat.Port(p)] = struct{}{}
+		}
+	}
+
+	if config.Volumes == nil {
+		config.Volumes = map[string]struct{}{}
+	}
+	if c, ok := applySetDefaults("Volumes", config.Volumes, oldImage.Volumes, newImage.Volumes); ok {
+		changes = append(changes, c)
+	}
+	if len(config.Volumes) == 0 {
+		config.Volumes = nil
+	}
+
+	return changes
+}
+
+// applyEnvDefaults replaces the variables in config.Env whose value the old
+// image set with the new image's value, dropping those the new image no
+// longer sets, and adds the variables only the new image sets.
+func applyEnvDefaults(config *containertypes.Config, oldEnv, newEnv []string) []defaultChange {
+	oldVars, newVars := envMap(oldEnv), envMap(newEnv)
+
+	var changes []defaultChange
+	var env []string
+	seen := map[string]bool{}
+	for _, kv := range config.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		seen[k] = true
+		old, inherited := oldVars[k]
+		if !inherited || old != v {
+			env = append(env, kv)
+			continue
+		}
+		nv, ok := newVars[k]
+		switch {
+		case !ok:
+			changes = append(changes, defaultChange{"Env " + k, v, ""})
+		case nv != v:
+			changes = append(changes, defaultChange{"Env " + k, v, nv})
+			env = append(env, k+"="+nv)
+		default:
+			env = append(env, kv)
+		}
+	}
+	for _, kv := range newEnv {
+		k, v, _ := strings.Cut(kv, "=")
+		if !seen[k] {
+			changes = append(changes, defaultChange{"Env " + k, "", v})
+			env = append(env, kv)
+		}
+	}
+	config.Env = env
+	return changes
+}
+
+// applySetDefaults removes the members of set the old image declared and
+// adds those the new one declares. It reports the change if set changed.
+func applySetDefaults(field string, set, oldImage, newImage map[string]struct{}) (defaultChange, bool) {
+	before := slices.Sorted(maps.Keys(set))
+	for k := range oldImage {
+		delete(set, k)
+	}
+	maps.Copy(set, newImage)
+	after := slices.Sorted(maps.Keys(set))
+	if slices.Equal(before, after) {
+		return defaultChange{}, false
+	}
+	return defaultChange{field, strings.Join(before, " "), strings.Join(after, " ")}, true
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,151 @@
+package container
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+)
+
+func TestApplyImageDefaults(t *testing.T) {
+	oldImage := ocispec.ImageConfig{
+		Env:          []string{"PATH=/usr/bin", "APP_VERSION=1.0", "MODE=prod"},
+		Entrypoint:   []string{"/entrypoint.sh"},
+		Cmd:          []string{"serve"},
+		User:         "app",
+		ExposedPorts: map[string]struct{}{"80/tcp": {}},
+		Volumes:      map[string]struct{}{"/data": {}},
+	}
+	newImage := ocispec.ImageConfig{
+		Env:          []string{"PATH=/opt/app/bin:/usr/bin", "APP_VERSION=2.0", "LANG=C.UTF-8"},
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Cmd:          []string{"serve", "--http"},
+		User:         "nobody",
+		ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		Volumes:      map[string]struct{}{"/var/lib/app": {}},
+	}
+
+	tests := []struct {
+		name   string
+		config containertypes.Config
+		want   containertypes.Config
+		fields []string
+	}{
+		{
+			name: "all inherited",
+			config: containertypes.Config{
+				Env:          []string{"PATH=/usr/bin", "APP_VERSION=1.0", "MODE=prod"},
+				Entrypoint:   []string{"/entrypoint.sh"},
+				Cmd:          []string{"serve"},
+				User:         "app",
+				ExposedPorts: nat.PortSet{"80/tcp": {}},
+				Volumes:      map[string]struct{}{"/data": {}},
+			},
+			want: containertypes.Config{
+				Env:          []string{"PATH=/opt/app/bin:/usr/bin", "APP_VERSION=2.0", "LANG=C.UTF-8"},
+				Entrypoint:   []string{"/docker-entrypoint.sh"},
+				Cmd:          []string{"serve", "--http"},
+				User:         "nobody",
+				ExposedPorts: nat.PortSet{"8080/tcp": {}},
+				Volumes:      map[string]struct{}{"/var/lib/app": {}},
+			},
+			fields: []string{"Env PATH", "Env APP_VERSION", "Env MODE", "Env LANG", "Entrypoint", "Cmd", "User", "ExposedPorts", "Volumes"},
+		},
+		{
+			name: "set on the container",
+			config: containertypes.Config{
+				Env:          []string{"PATH=/usr/bin", "APP_VERSION=1.0-custom", "MODE=prod", "TZ=UTC"},
+				Entrypoint:   []string{"/entrypoint.sh"},
+				Cmd:          []string{"worker"},
+				User:         "1000:1000",
+				ExposedPorts: nat.PortSet{"80/tcp": {}, "9090/tcp": {}},
+				Volumes:      map[string]struct{}{"/data": {}, "/cache": {}},
+			},
+			want: containertypes.Config{
+				Env:          []string{"PATH=/opt/app/bin:/usr/bin", "APP_VERSION=1.0-custom", "TZ=UTC", "LANG=C.UTF-8"},
+				Entrypoint:   []string{"/docker-entrypoint.sh"},
+				Cmd:          []string{"worker"},
+				User:         "1000:1000",
+				ExposedPorts: nat.PortSet{"9090/tcp": {}, "8080/tcp": {}},
+				Volumes:      map[string]struct{}{"/cache": {}, "/var/lib/app": {}},
+			},
+			fields: []string{"Env PATH", "Env MODE", "Env LANG", "Entrypoint", "ExposedPorts", "Volumes"},
+		},
+		{
+			name: "entrypoint overridden",
+			config: containertypes.Config{
+				Env:        []string{"PATH=/usr/bin"},
+				Entrypoint: []string{"/bin/sh", "-c"},
+				Cmd:        []string{"serve"},
+				User:       "app",
+			},
+			want: containertypes.Config{
+				Env:          []string{"PATH=/opt/app/bin:/usr/bin", "APP_VERSION=2.0", "LANG=C.UTF-8"},
+				Entrypoint:   []string{"/bin/sh", "-c"},
+				Cmd:          []string{"serve"},
+				User:         "nobody",
+				ExposedPorts: nat.PortSet{"8080/tcp": {}},
+				Volumes:      map[string]struct{}{"/var/lib/app": {}},
+			},
+			fields: []string{"Env PATH", "Env APP_VERSION", "Env LANG", "User", "ExposedPorts", "Volumes"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			changes := applyImageDefaults(&config, oldImage, newImage)
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("config =\n%+v\nwant\n%+v", config, tt.want)
+			}
+			var fields []string
+			for _, c := range changes {
+				fields = append(fields, c.field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("changed fields = %v, want %v", fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestApplyImageDefaults_Unchanged(t *testing.T) {
+	image := ocispec.ImageConfig{Env: []string{"PATH=/usr/bin"}, Cmd: []string{"serve"}}
+	config := containertypes.Config{Env: []string{"PATH=/usr/bin"}, Cmd: []string{"serve"}}
+	if changes := applyImageDefaults(&config, image, image); len(changes) > 0 {
+		t.Errorf("changes for identical images: %+v", changes)
+	}
+}
+
+func TestRecreate_ImageDefaults(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "app:1", Digest: "sha256:old", Config: ocispec.ImageConfig{
+		Env: []string{"PATH=/usr/bin", "APP_VERSION=1.0"},
+		Cmd: []string{"app", "serve"},
+	}})
+	oldID := d.Run("app", &containertypes.Config{Image: "app:1", Env: []string{"DEBUG=1"}}, nil)
+	d.AddImage(fakedocker.Image{Ref: "app:1", Digest: "sha256:new", Config: ocispec.ImageConfig{
+		Env: []string{"PATH=/opt/app/bin:/usr/bin", "APP_VERSION=2.0"},
+		Cmd: []string{"app", "run"},
+	}})
+
+	newID, err := Recreate(context.Background(), d, oldID, "app:1", nil, 10, docker.EngineDocker)
+	if err != nil {
+		t.Fatalf("Recreate: %v", err)
+	}
+	c, _ := d.Container(newID)
+	wantEnv := []string{"PATH=/opt/app/bin:/usr/bin", "APP_VERSION=2.0", "DEBUG=1"}
+	if !slices.Equal(c.Config.Env, wantEnv) {
+		t.Errorf("Env = %v, want %v", c.Config.Env, wantEnv)
+	}
+	if want := []string{"app", "run"}; !slices.Equal(c.Config.Cmd, want) {
+		t.Errorf("Cmd = %v, want %v", c.Config.Cmd, want)
+	}
+}
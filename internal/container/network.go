@@ -0,0 +1,80 @@
+package container
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+// endpointConfig rebuilds the settings a container was connected to a
+// network with from its inspect output: static IPv4, IPv6 and link-local
+// addresses, links, driver options, gateway priority and DNS names, without
+// the operational data the daemon fills in. The container's name and short
+// ID, which Docker adds to the DNS names by itself, are dropped so the
+// replacement answers to its own ID; every other DNS name is kept as an
+// alias. The MAC address is set to macAddress, see [configuredMAC].
+func endpointConfig(containerID, name string, ep *network.EndpointSettings, macAddress string) *network.EndpointSettings {
+	s := &network.EndpointSettings{
+		Links:      slices.Clone(ep.Links),
+		MacAddress: macAddress,
+		DriverOpts: maps.Clone(ep.DriverOpts),
+		GwPriority: ep.GwPriority,
+	}
+	if ep.IPAMConfig != nil {
+		s.IPAMConfig = &network.EndpointIPAMConfig{
+			IPv4Address:  ep.IPAMConfig.IPv4Address,
+			IPv6Address:  ep.IPAMConfig.IPv6Address,
+			LinkLocalIPs: slices.Clone(ep.IPAMConfig.LinkLocalIPs),
+		}
+	}
+
+	generated := func(n string) bool {
+		return n == name || (len(n) >= 12 && strings.HasPrefix(containerID, n))
+	}
+	for _, alias := range slices.Concat(ep.Aliases, ep.DNSNames) {
+		if alias == "" || generated(alias) || slices.Contains(s.Aliases, alias) {
+			continue
+		}
+		s.Aliases = append(s.Aliases, alias)
+	}
+	return s
+}
+
+// configuredMAC returns the MAC address an inspected container was created
+// with on a network, or "" if Docker generated the one it has. A running
+// container's endpoints report the address in use, so a generated one cannot
+// be told apart there; Docker reports the configured address of the primary
+// network separately, in Config.MacAddress. Once the container is stopped
+// its endpoints only hold the addresses it was configured with.
+func configuredMAC(inspect containertypes.InspectResponse, netName string, primary bool) string {
+	if inspect.State != nil && !inspect.State.Running {
+		if ep := inspect.NetworkSettings.Networks[netName]; ep != nil {
+			return ep.MacAddress
+		}
+		return ""
+	}
+	if primary && inspect.Config != nil {
+		return inspect.Config.MacAddress
+	}
+	return ""
+}
+
+// networkOrder returns the networks a container is connected to in the
+// order to reconnect them: the network named by its network mode first, so
+// that it stays the primary network, then the others sorted by name as
+// Docker reports them.
+func networkOrder(mode containertypes.NetworkMode, networks map[string]*network.EndpointSettings) []string {
+	primary := string(mode)
+	if mode == "" || mode.IsDefault() {
+		primary = network.NetworkBridge
+	}
+
+	names := slices.Sorted(maps.Keys(networks))
+	if i := slices.Index(names, primary); i > 0 {
+		names = slices.Insert(slices.Delete(names, i, i+1), 0, primary)
+	}
+	return names
+}
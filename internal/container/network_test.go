@@ -0,0 +1,206 @@
+package container
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestEndpointConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		ep   network.EndpointSettings
+		mac  string
+		want network.EndpointSettings
+	}{
+		{
+			name: "operational data dropped",
+			ep: network.EndpointSettings{
+				NetworkID:  "n1",
+				EndpointID: "e1",
+				Gateway:    "172.18.0.1",
+				IPAddress:  "172.18.0.5",
+				MacAddress: "02:42:ac:12:00:05",
+			},
+			want: network.EndpointSettings{},
+		},
+		{
+			name: "configured MAC address",
+			ep:   network.EndpointSettings{MacAddress: "02:00:00:aa:bb:cc"},
+			mac:  "02:00:00:aa:bb:cc",
+			want: network.EndpointSettings{MacAddress: "02:00:00:aa:bb:cc"},
+		},
+		{
+			name: "static addresses",
+			ep: network.EndpointSettings{
+				IPAMConfig: &network.EndpointIPAMConfig{
+					IPv4Address:  "10.0.0.5",
+					IPv6Address:  "fd00::5",
+					LinkLocalIPs: []string{"169.254.0.5"},
+				},
+				IPAddress:         "10.0.0.5",
+				GlobalIPv6Address: "fd00::5",
+			},
+			want: network.EndpointSettings{
+				IPAMConfig: &network.EndpointIPAMConfig{
+					IPv4Address:  "10.0.0.5",
+					IPv6Address:  "fd00::5",
+					LinkLocalIPs: []string{"169.254.0.5"},
+				},
+			},
+		},
+		{
+			name: "links, driver options and gateway priority",
+			ep: network.EndpointSettings{
+				Links:      []string{"db:database"},
+				DriverOpts: map[string]string{"com.docker.network.endpoint.ifname": "eth5"},
+				GwPriority: 10,
+			},
+			want: network.EndpointSettings{
+				Links:      []string{"db:database"},
+				DriverOpts: map[string]string{"com.docker.network.endpoint.ifname": "eth5"},
+				GwPriority: 10,
+			},
+		},
+		{
+			name: "generated names dropped, other DNS names kept",
+			ep: network.EndpointSettings{
+				Aliases:  []string{"web", "0123456789ab", "api"},
+				DNSNames: []string{"web", "api", "frontend", "0123456789ab"},
+			},
+			want: network.EndpointSettings{Aliases: []string{"api", "frontend"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := endpointConfig(testContainerID, "web", &tt.ep, tt.mac)
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("endpointConfig =\n%+v\nwant\n%+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfiguredMAC(t *testing.T) {
+	networks := map[string]*network.EndpointSettings{
+		"lan":     {MacAddress: "02:00:00:aa:bb:cc"},
+		"backend": {MacAddress: "02:42:ac:12:00:05"},
+	}
+	tests := []struct {
+		name    string
+		running bool
+		config  string
+		netName string
+		primary bool
+		want    string
+	}{
+		{"running, configured on the primary network", true, "02:00:00:aa:bb:cc", "lan", true, "02:00:00:aa:bb:cc"},
+		{"running, generated on the primary network", true, "", "lan", true, ""},
+		{"running, other network", true, "02:00:00:aa:bb:cc", "backend", false, ""},
+		{"stopped, configured", false, "", "lan", true, "02:00:00:aa:bb:cc"},
+		{"stopped, other network", false, "", "backend", false, "02:42:ac:12:00:05"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspect := containertypes.InspectResponse{
+				ContainerJSONBase: &containertypes.ContainerJSONBase{State: &containertypes.State{Running: tt.running}},
+				Config:            &containertypes.Config{MacAddress: tt.config},
+				NetworkSettings:   &containertypes.NetworkSettings{Networks: networks},
+			}
+			if got := configuredMAC(inspect, tt.netName, tt.primary); got != tt.want {
+				t.Errorf("configuredMAC = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkOrder(t *testing.T) {
+	networks := map[string]*network.EndpointSettings{"zeta": {}, "alpha": {}, "bridge": {}, "mid": {}}
+	tests := []struct {
+		mode containertypes.NetworkMode
+		want []string
+	}{
+		{"mid", []string{"mid", "alpha", "bridge", "zeta"}},
+		{"default", []string{"bridge", "alpha", "mid", "zeta"}},
+		{"", []string{"bridge", "alpha", "mid", "zeta"}},
+		{"alpha", []string{"alpha", "bridge", "mid", "zeta"}},
+		{"container:db", []string{"alpha", "bridge", "mid", "zeta"}},
+	}
+	for _, tt := range tests {
+		if got := networkOrder(tt.mode, networks); !slices.Equal(got, tt.want) {
+			t.Errorf("networkOrder(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestRecreate_Networks(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "app"})
+	for _, n := range []string{"lan", "backend", "monitoring"} {
+		d.AddNetwork(n)
+	}
+
+	resp, err := d.ContainerCreate(context.Background(),
+		&containertypes.Config{Image: "app"},
+		&containertypes.HostConfig{NetworkMode: "lan"},
+		&network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{
+			"lan": {
+				IPAMConfig: &network.EndpointIPAMConfig{IPv4Address: "192.168.1.20", IPv6Address: "2001:db8::20"},
+				MacAddress: "02:00:00:aa:bb:cc",
+			},
+			"backend":    {Aliases: []string{"api"}, GwPriority: -1},
+			"monitoring": {DriverOpts: map[string]string{"opt": "1"}},
+		}},
+		nil, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ContainerStart(context.Background(), resp.ID, containertypes.StartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	old, _ := d.Container(resp.ID)
+	oldMAC := old.NetworkSettings.Networks["backend"].MacAddress
+
+	newID, err := Recreate(context.Background(), d, resp.ID, "app", nil, 10, docker.EngineDocker)
+	if err != nil {
+		t.Fatalf("Recreate: %v", err)
+	}
+	c, _ := d.Container(newID)
+	nets := c.NetworkSettings.Networks
+
+	lan := nets["lan"]
+	if lan == nil || lan.MacAddress != "02:00:00:aa:bb:cc" || lan.IPAMConfig == nil ||
+		lan.IPAMConfig.IPv4Address != "192.168.1.20" || lan.IPAMConfig.IPv6Address != "2001:db8::20" {
+		t.Errorf("lan endpoint not preserved: %+v", lan)
+	}
+	if be := nets["backend"]; be == nil || !slices.Equal(be.Aliases, []string{"api"}) || be.GwPriority != -1 {
+		t.Errorf("backend endpoint not preserved: %+v", be)
+	} else if be.MacAddress == oldMAC {
+		t.Errorf("generated MAC address %s carried over to backend", be.MacAddress)
+	}
+	if mon := nets["monitoring"]; mon == nil || mon.DriverOpts["opt"] != "1" {
+		t.Errorf("monitoring endpoint not preserved: %+v", mon)
+	}
+
+	var connects []string
+	for _, call := range d.Calls() {
+		if n, ok := strings.CutPrefix(call, "NetworkConnect "); ok {
+			connects = append(connects, n)
+		}
+	}
+	if !slices.Equal(connects, []string{"backend", "monitoring"}) {
+		t.Errorf("networks connected in order %v, want [backend monitoring]", connects)
+	}
+}
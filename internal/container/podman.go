@@ -0,0 +1,335 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// podOf returns the ID of the Podman pod a container belongs to, or "" if
+// it is not in a pod. Pod membership is only visible in Podman's native
+// inspect output.
+func podOf(ctx context.Context, cli docker.API, containerID string) (string, error) {
+	var inspect struct {
+		Pod string `json:"Pod"`
+	}
+	if err := docker.Libpod(ctx, cli, http.MethodGet, "/containers/"+containerID+"/json", nil, &inspect); err != nil {
+		return "", fmt.Errorf("inspecting pod membership: %w", err)
+	}
+	return inspect.Pod, nil
+}
+
+// podSpec is the subset of Podman's container create spec that Isengard
+// fills in to recreate a pod member. Networking, ports and the hostname
+// belong to the pod and are not set per container.
+type podSpec struct {
+	Name          string            `json:"name"`
+	Pod           string            `json:"pod"`
+	Image         string            `json:"image"`
+	Entrypoint    []string          `json:"entrypoint,omitempty"`
+	Command       []string          `json:"command,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	WorkDir       string            `json:"work_dir,omitempty"`
+	User          string            `json:"user,omitempty"`
+	Terminal      bool              `json:"terminal,omitempty"`
+	Stdin         bool              `json:"stdin,omitempty"`
+	Privileged    bool              `json:"privileged,omitempty"`
+	ReadOnly      bool              `json:"read_only_filesystem,omitempty"`
+	CapAdd        []string          `json:"cap_add,omitempty"`
+	CapDrop       []string          `json:"cap_drop,omitempty"`
+	RestartPolicy string            `json:"restart_policy,omitempty"`
+	RestartTries  *uint             `json:"restart_tries,omitempty"`
+	StopTimeout   *uint             `json:"stop_timeout,omitempty"`
+	Mounts        []podMount        `json:"mounts,omitempty"`
+	Volumes       []podVolume       `json:"volumes,omitempty"`
+	LogConfig     *podLogConfig     `json:"log_configuration,omitempty"`
+
+	Devices         []podDevice                  `json:"devices,omitempty"`
+	Resources       *podResources                `json:"resource_limits,omitempty"`
+	Sysctl          map[string]string            `json:"sysctl,omitempty"`
+	Rlimits         []podRlimit                  `json:"r_limits,omitempty"`
+	SelinuxOpts     []string                     `json:"selinux_opts,omitempty"`
+	ApparmorProfile string                       `json:"apparmor_profile,omitempty"`
+	SeccompProfile  string                       `json:"seccomp_profile_path,omitempty"`
+	NoNewPrivileges bool                         `json:"no_new_privileges,omitempty"`
+	HealthConfig    *containertypes.HealthConfig `json:"healthconfig,omitempty"`
+}
+
+// podDevice is a device as Podman's create spec takes it, with the whole
+// "host:container:permissions" string in path.
+type podDevice struct {
+	Path string `json:"path"`
+}
+
+type podResources struct {
+	Memory *podMemory `json:"memory,omitempty"`
+	CPU    *podCPU    `json:"cpu,omitempty"`
+	Pids   *podPids   `json:"pids,omitempty"`
+}
+
+type podMemory struct {
+	Limit       *int64 `json:"limit,omitempty"`
+	Reservation *int64 `json:"reservation,omitempty"`
+	Swap        *int64 `json:"swap,omitempty"`
+}
+
+type podCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+	Mems   string  `json:"mems,omitempty"`
+}
+
+type podPids struct {
+	Limit int64 `json:"limit"`
+}
+
+type podRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type podMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type podVolume struct {
+	Name    string   `json:"Name"`
+	Dest    string   `json:"Dest"`
+	Options []string `json:"Options,omitempty"`
+}
+
+type podLogConfig struct {
+	Driver  string            `json:"driver,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// newPodSpec translates the Docker-compatible inspect output of a pod member
+// into a create spec for the same pod with a new image. It fails if the
+// container has security options the spec has no place for, rather than
+// recreating it without them.
+func newPodSpec(name, pod, image string, config *containertypes.Config, hostConfig *containertypes.HostConfig) (podSpec, error) {
+	s := podSpec{
+		Name:       name,
+		Pod:        pod,
+		Image:      image,
+		Entrypoint: config.Entrypoint,
+		Command:    config.Cmd,
+		Labels:     config.Labels,
+		WorkDir:    config.WorkingDir,
+		User:       config.User,
+		Terminal:   config.Tty,
+		Stdin:      config.OpenStdin,
+		Privileged: hostConfig.Privileged,
+		ReadOnly:   hostConfig.ReadonlyRootfs,
+		CapAdd:     hostConfig.CapAdd,
+		CapDrop:    hostConfig.CapDrop,
+		Sysctl:     hostConfig.Sysctls,
+	}
+
+	if len(config.Env) > 0 {
+		s.Env = make(map[string]string, len(config.Env))
+		for _, kv := range config.Env {
+			k, v, _ := strings.Cut(kv, "=")
+			s.Env[k] = v
+		}
+	}
+
+	if p := hostConfig.RestartPolicy; p.Name != "" && p.Name != containertypes.RestartPolicyDisabled {
+		s.RestartPolicy = string(p.Name)
+		if p.MaximumRetryCount > 0 {
+			tries := uint(p.MaximumRetryCount)
+			s.RestartTries = &tries
+		}
+	}
+	if config.StopTimeout != nil && *config.StopTimeout >= 0 {
+		timeout := uint(*config.StopTimeout)
+		s.StopTimeout = &timeout
+	}
+	if hostConfig.LogConfig.Type != "" {
+		s.LogConfig = &podLogConfig{Driver: hostConfig.LogConfig.Type, Options: hostConfig.LogConfig.Config}
+	}
+	if hc := config.Healthcheck; hc != nil && len(hc.Test) > 0 {
+		s.HealthConfig = hc
+	}
+
+	for _, d := range hostConfig.Devices {
+		dev := d.PathOnHost
+		if d.PathInContainer != "" {
+			dev += ":" + d.PathInContainer
+		}
+		if d.CgroupPermissions != "" {
+			dev += ":" + d.CgroupPermissions
+		}
+		s.Devices = append(s.Devices, podDevice{Path: dev})
+	}
+	s.Resources = podResourceLimits(hostConfig.Resources)
+	for _, u := range hostConfig.Ulimits {
+		s.Rlimits = append(s.Rlimits, podRlimit{Type: "RLIMIT_" + strings.ToUpper(u.Name), Hard: uint64(u.Hard), Soft: uint64(u.Soft)})
+	}
+	if err := s.setSecurityOpts(hostConfig.SecurityOpt); err != nil {
+		return podSpec{}, err
+	}
+
+	// Binds are "source:destination[:options]"; a source that is not a path
+	// names a volume.
+	for _, b := range hostConfig.Binds {
+		parts := strings.SplitN(b, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		var opts []string
+		if len(parts) == 3 {
+			opts = strings.Split(parts[2], ",")
+		}
+		if strings.HasPrefix(parts[0], "/") {
+			s.Mounts = append(s.Mounts, podMount{Destination: parts[1], Type: "bind", Source: parts[0], Options: append(opts, "rbind")})
+		} else {
+			s.Volumes = append(s.Volumes, podVolume{Name: parts[0], Dest: parts[1], Options: opts})
+		}
+	}
+	for _, m := range hostConfig.Mounts {
+		var opts []string
+		if m.ReadOnly {
+			opts = append(opts, "ro")
+		}
+		switch m.Type {
+		case mount.TypeVolume:
+			s.Volumes = append(s.Volumes, podVolume{Name: m.Source, Dest: m.Target, Options: opts})
+		case mount.TypeBind:
+			if m.BindOptions != nil && m.BindOptions.Propagation != "" {
+				opts = append(opts, string(m.BindOptions.Propagation))
+			}
+			s.Mounts = append(s.Mounts, podMount{Destination: m.Target, Type: "bind", Source: m.Source, Options: append(opts, "rbind")})
+		case mount.TypeTmpfs:
+			s.Mounts = append(s.Mounts, podMount{Destination: m.Target, Type: "tmpfs", Source: "tmpfs", Options: opts})
+		}
+	}
+	for _, dest := range slices.Sorted(maps.Keys(hostConfig.Tmpfs)) {
+		var opts []string
+		if o := hostConfig.Tmpfs[dest]; o != "" {
+			opts = strings.Split(o, ",")
+		}
+		s.Mounts = append(s.Mounts, podMount{Destination: dest, Type: "tmpfs", Source: "tmpfs", Options: opts})
+	}
+
+	return s, nil
+}
+
+// podResourceLimits translates the CPU, memory and PID limits of a
+// container, or returns nil if it has none. NanoCPUs become a quota over
+// the default 100ms period, as Podman sets --cpus.
+func podResourceLimits(r containertypes.Resources) *podResources {
+	var res podResources
+	if r.Memory > 0 || r.MemoryReservation > 0 || r.MemorySwap != 0 {
+		res.Memory = &podMemory{}
+		if r.Memory > 0 {
+			res.Memory.Limit = &r.Memory
+		}
+		if r.MemoryReservation > 0 {
+			res.Memory.Reservation = &r.MemoryReservation
+		}
+		if r.MemorySwap != 0 {
+			res.Memory.Swap = &r.MemorySwap
+		}
+	}
+
+	cpu := podCPU{Cpus: r.CpusetCpus, Mems: r.CpusetMems}
+	if r.CPUShares > 0 {
+		shares := uint64(r.CPUShares)
+		cpu.Shares = &shares
+	}
+	switch {
+	case r.NanoCPUs > 0:
+		period, quota := uint64(100000), r.NanoCPUs*100000/1e9
+		cpu.Period, cpu.Quota = &period, &quota
+	case r.CPUQuota > 0 || r.CPUPeriod > 0:
+		if r.CPUQuota > 0 {
+			cpu.Quota = &r.CPUQuota
+		}
+		if r.CPUPeriod > 0 {
+			period := uint64(r.CPUPeriod)
+			cpu.Period = &period
+		}
+	}
+	if cpu != (podCPU{}) {
+		res.CPU = &cpu
+	}
+
+	if r.PidsLimit != nil && *r.PidsLimit != 0 {
+		res.Pids = &podPids{Limit: *r.PidsLimit}
+	}
+
+	if res == (podResources{}) {
+		return nil
+	}
+	return &res
+}
+
+// setSecurityOpts translates Docker security options ("label=...",
+// "apparmor=...", "seccomp=<path>" or "seccomp=unconfined",
+// "no-new-privileges") into the spec.
+func (s *podSpec) setSecurityOpts(opts []string) error {
+	for _, opt := range opts {
+		k, v, ok := strings.Cut(opt, "=")
+		if !ok {
+			k, v, ok = strings.Cut(opt, ":")
+		}
+		switch {
+		case k == "label" && ok:
+			s.SelinuxOpts = append(s.SelinuxOpts, v)
+		case k == "apparmor" && ok:
+			s.ApparmorProfile = v
+		case k == "seccomp" && ok && (v == "unconfined" || strings.HasPrefix(v, "/")):
+			// Podman takes a profile path; an inline JSON profile is refused.
+			s.SeccompProfile = v
+		case k == "no-new-privileges" && (!ok || v == "true"):
+			s.NoNewPrivileges = true
+		case k == "no-new-privileges" && v == "false":
+		default:
+			return fmt.Errorf("security option %q cannot be carried over to a pod member", opt)
+		}
+	}
+	return nil
+}
+
+// createInPod creates a container in a Podman pod through the native API,
+// since the Docker-compatible create endpoint cannot join a pod.
+func createInPod(ctx context.Context, cli docker.API, spec podSpec) (string, error) {
+	var resp struct {
+		ID string `json:"Id"`
+	}
+	if err := docker.Libpod(ctx, cli, http.MethodPost, "/containers/create", spec, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// podmanNetworkMode reports whether a Podman network mode attaches the
+// container to named networks that must be passed when it is recreated.
+// Rootless, host, pod and shared-namespace modes have none.
+func podmanNetworkMode(mode containertypes.NetworkMode) bool {
+	switch {
+	case mode.IsHost(), mode.IsNone(), mode.IsContainer():
+		return false
+	case mode == "slirp4netns", mode == "pasta", mode == "private":
+		return false
+	case strings.HasPrefix(string(mode), "ns:"), strings.HasPrefix(string(mode), "slirp4netns:"), strings.HasPrefix(string(mode), "pasta:"):
+		return false
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestNewPodSpec(t *testing.T) {
+	stopTimeout := 20
+	config := &containertypes.Config{
+		Image:       "docker.io/library/nginx:1.25",
+		Env:         []string{"A=1", "B=x=y", "EMPTY="},
+		Cmd:         []string{"nginx", "-g", "daemon off;"},
+		Labels:      map[string]string{"app": "web"},
+		WorkingDir:  "/srv",
+		User:        "101",
+		StopTimeout: &stopTimeout,
+	}
+	hostConfig := &containertypes.HostConfig{
+		Binds: []string{"/srv/html:/usr/share/nginx/html:ro", "cache:/var/cache/nginx"},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeTmpfs, Target: "/tmp"},
+			{Type: mount.TypeVolume, Source: "logs", Target: "/var/log/nginx", ReadOnly: true},
+		},
+		RestartPolicy: containertypes.RestartPolicy{Name: containertypes.RestartPolicyOnFailure, MaximumRetryCount: 3},
+		LogConfig:     containertypes.LogConfig{Type: "journald"},
+	}
+
+	s, err := newPodSpec("web", "pod123", "docker.io/library/nginx:1.26", config, hostConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Name != "web" || s.Pod != "pod123" || s.Image != "docker.io/library/nginx:1.26" {
+		t.Errorf("identity = %q, %q, %q", s.Name, s.Pod, s.Image)
+	}
+	if want := map[string]string{"A": "1", "B": "x=y", "EMPTY": ""}; !reflect.DeepEqual(s.Env, want) {
+		t.Errorf("Env = %v, want %v", s.Env, want)
+	}
+	if s.RestartPolicy != "on-failure" || s.RestartTries == nil || *s.RestartTries != 3 {
+		t.Errorf("restart = %q, %v", s.RestartPolicy, s.RestartTries)
+	}
+	if s.StopTimeout == nil || *s.StopTimeout != 20 {
+		t.Errorf("StopTimeout = %v", s.StopTimeout)
+	}
+	if s.LogConfig == nil || s.LogConfig.Driver != "journald" {
+		t.Errorf("LogConfig = %v", s.LogConfig)
+	}
+
+	wantMounts := []podMount{
+		{Destination: "/usr/share/nginx/html", Type: "bind", Source: "/srv/html", Options: []string{"ro", "rbind"}},
+		{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs"},
+	}
+	if !reflect.DeepEqual(s.Mounts, wantMounts) {
+		t.Errorf("Mounts = %+v, want %+v", s.Mounts, wantMounts)
+	}
+	wantVolumes := []podVolume{
+		{Name: "cache", Dest: "/var/cache/nginx"},
+		{Name: "logs", Dest: "/var/log/nginx", Options: []string{"ro"}},
+	}
+	if !reflect.DeepEqual(s.Volumes, wantVolumes) {
+		t.Errorf("Volumes = %+v, want %+v", s.Volumes, wantVolumes)
+	}
+}
+
+func TestNewPodSpec_Settings(t *testing.T) {
+	i64 := func(n int64) *int64 { return &n }
+	u64 := func(n uint64) *uint64 { return &n }
+
+	tests := []struct {
+		name       string
+		config     containertypes.Config
+		hostConfig containertypes.HostConfig
+		field      func(podSpec) any
+		want       any
+	}{
+		{
+			name: "devices",
+			hostConfig: containertypes.HostConfig{Resources: containertypes.Resources{Devices: []containertypes.DeviceMapping{
+				{PathOnHost: "/dev/dri", PathInContainer: "/dev/dri", CgroupPermissions: "rwm"},
+				{PathOnHost: "/dev/ttyUSB0", PathInContainer: "/dev/zigbee"},
+			}}},
+			field: func(s podSpec) any { return s.Devices },
+			want:  []podDevice{{Path: "/dev/dri:/dev/dri:rwm"}, {Path: "/dev/ttyUSB0:/dev/zigbee"}},
+		},
+		{
+			name: "CPU and memory limits",
+			hostConfig: containertypes.HostConfig{Resources: containertypes.Resources{
+				Memory: 512 << 20, MemoryReservation: 256 << 20, MemorySwap: -1,
+				NanoCPUs: 1500000000, CPUShares: 512, CpusetCpus: "0-1",
+				PidsLimit: i64(100),
+			}},
+			field: func(s podSpec) any { return s.Resources },
+			want: &podResources{
+				Memory: &podMemory{Limit: i64(512 << 20), Reservation: i64(256 << 20), Swap: i64(-1)},
+				CPU:    &podCPU{Shares: u64(512), Quota: i64(150000), Period: u64(100000), Cpus: "0-1"},
+				Pids:   &podPids{Limit: 100},
+			},
+		},
+		{
+			name:  "no limits",
+			field: func(s podSpec) any { return s.Resources },
+			want:  (*podResources)(nil),
+		},
+		{
+			name:       "sysctls",
+			hostConfig: containertypes.HostConfig{Sysctls: map[string]string{"net.ipv4.ip_forward": "1"}},
+			field:      func(s podSpec) any { return s.Sysctl },
+			want:       map[string]string{"net.ipv4.ip_forward": "1"},
+		},
+		{
+			name: "ulimits",
+			hostConfig: containertypes.HostConfig{Resources: containertypes.Resources{Ulimits: []*containertypes.Ulimit{
+				{Name: "nofile", Soft: 1024, Hard: 65536},
+			}}},
+			field: func(s podSpec) any { return s.Rlimits },
+			want:  []podRlimit{{Type: "RLIMIT_NOFILE", Hard: 65536, Soft: 1024}},
+		},
+		{
+			name: "security options",
+			hostConfig: containertypes.HostConfig{SecurityOpt: []string{
+				"label=type:container_runtime_t", "apparmor=unconfined", "seccomp=/etc/seccomp.json", "no-new-privileges:true",
+			}},
+			field: func(s podSpec) any {
+				return []any{s.SelinuxOpts, s.ApparmorProfile, s.SeccompProfile, s.NoNewPrivileges}
+			},
+			want: []any{[]string{"type:container_runtime_t"}, "unconfined", "/etc/seccomp.json", true},
+		},
+		{
+			name: "healthcheck",
+			config: containertypes.Config{Healthcheck: &containertypes.HealthConfig{
+				Test: []string{"CMD", "curl", "-f", "http://localhost"}, Interval: 30 * time.Second, Retries: 3,
+			}},
+			field: func(s podSpec) any { return s.HealthConfig },
+			want: &containertypes.HealthConfig{
+				Test: []string{"CMD", "curl", "-f", "http://localhost"}, Interval: 30 * time.Second, Retries: 3,
+			},
+		},
+		{
+			name:       "tmpfs",
+			hostConfig: containertypes.HostConfig{Tmpfs: map[string]string{"/run": "rw,size=64m", "/tmp": ""}},
+			field:      func(s podSpec) any { return s.Mounts },
+			want: []podMount{
+				{Destination: "/run", Type: "tmpfs", Source: "tmpfs", Options: []string{"rw", "size=64m"}},
+				{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newPodSpec("web", "pod123", "app:2", &tt.config, &tt.hostConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.field(s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPodSpec_UnsupportedSecurityOpt(t *testing.T) {
+	for _, opt := range []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`, "systempaths=unconfined"} {
+		hostConfig := &containertypes.HostConfig{SecurityOpt: []string{opt}}
+		if _, err := newPodSpec("web", "pod123", "app:2", &containertypes.Config{}, hostConfig); err == nil {
+			t.Errorf("newPodSpec accepted security option %q", opt)
+		}
+	}
+}
+
+func TestPodmanNetworkMode(t *testing.T) {
+	tests := []struct {
+		mode containertypes.NetworkMode
+		want bool
+	}{
+		{"bridge", true},
+		{"podman", true},
+		{"mynet", true},
+		{"host", false},
+		{"none", false},
+		{"slirp4netns", false},
+		{"slirp4netns:port_handler=slirp4netns", false},
+		{"pasta", false},
+		{"container:abc123", false},
+		{"ns:/run/netns/x", false},
+	}
+
+	for _, tt := range tests {
+		if got := podmanNetworkMode(tt.mode); got != tt.want {
+			t.Errorf("podmanNetworkMode(%q) = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// preflight checks that the replacement for an inspected container can be
+// created and started with hostConfig, so that an update bound to fail is
+// abandoned while the original still runs. Every network and named volume
+// it uses must exist, a container whose network namespace it joins must be
+// running, every device it maps must exist, and no other container may
+// publish the host ports it binds.
+//
+// Devices are looked up in the filesystem Isengard runs in, which has the
+// host's devices only if /dev is mounted into it. Ports held by processes
+// outside Docker are only checked by the daemon when the replacement starts.
+func preflight(ctx context.Context, cli docker.API, inspect containertypes.InspectResponse, hostConfig *containertypes.HostConfig, engine docker.Engine) error {
+	var problems []string
+
+	if inspect.NetworkSettings != nil && (engine != docker.EnginePodman || podmanNetworkMode(hostConfig.NetworkMode)) {
+		for _, name := range networkOrder(hostConfig.NetworkMode, inspect.NetworkSettings.Networks) {
+			if name == network.NetworkHost || name == network.NetworkNone {
+				continue
+			}
+			_, err := cli.NetworkInspect(ctx, name, network.InspectOptions{})
+			switch {
+			case cerrdefs.IsNotFound(err):
+				problems = append(problems, fmt.Sprintf("network %s does not exist", name))
+			case err != nil:
+				return fmt.Errorf("inspecting network %s: %w", name, err)
+			}
+		}
+	}
+
+	if mode := hostConfig.NetworkMode; mode.IsContainer() {
+		peer, err := cli.ContainerInspect(ctx, mode.ConnectedContainer())
+		switch {
+		case cerrdefs.IsNotFound(err):
+			problems = append(problems, fmt.Sprintf("container %s, whose network it shares, does not exist", mode.ConnectedContainer()))
+		case err != nil:
+			return fmt.Errorf("inspecting container %s: %w", mode.ConnectedContainer(), err)
+		case peer.State == nil || !peer.State.Running:
+			problems = append(problems, fmt.Sprintf("container %s, whose network it shares, is not running", mode.ConnectedContainer()))
+		}
+	}
+
+	// Docker would create a missing volume empty, hiding the data the
+	// container had.
+	for _, name := range namedVolumes(hostConfig) {
+		_, err := cli.VolumeInspect(ctx, name)
+		switch {
+		case cerrdefs.IsNotFound(err):
+			problems = append(problems, fmt.Sprintf("volume %s does not exist", name))
+		case err != nil:
+			return fmt.Errorf("inspecting volume %s: %w", name, err)
+		}
+	}
+
+	// A missing device only fails the start, after the original has been
+	// stopped.
+	for _, dev := range hostConfig.Devices {
+		if _, err := os.Stat(dev.PathOnHost); errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, fmt.Sprintf("device %s does not exist", dev.PathOnHost))
+		}
+	}
+
+	conflicts, err := portConflicts(ctx, cli, inspect.ID, hostConfig.PortBindings)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, conflicts...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("preflight check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// namedVolumes returns the volumes a container mounts by name, through
+// binds and volume mounts.
+func namedVolumes(hostConfig *containertypes.HostConfig) []string {
+	var names []string
+	for _, b := range hostConfig.Binds {
+		source, _, ok := strings.Cut(b, ":")
+		if ok && source != "" && !strings.HasPrefix(source, "/") {
+			names = append(names, source)
+		}
+	}
+	for _, m := range hostConfig.Mounts {
+		if m.Type == mount.TypeVolume && m.Source != "" {
+			names = append(names, m.Source)
+		}
+	}
+	return names
+}
+
+// portConflicts describes the host ports in bindings that a running
+// container other than self already publishes.
+func portConflicts(ctx context.Context, cli docker.API, self string, bindings nat.PortMap) ([]string, error) {
+	if len(bindings) == 0 {
+		return nil, nil
+	}
+	running, err := cli.ContainerList(ctx, containertypes.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+
+	var problems []string
+	for port, binds := range bindings {
+		for _, b := range binds {
+			first, last, ok := hostPortRange(b.HostPort)
+			if !ok {
+				continue
+			}
+			for _, c := range running {
+				if c.ID == self {
+					continue
+				}
+				for _, p := range c.Ports {
+					if p.Type == port.Proto() && p.PublicPort >= first && p.PublicPort <= last && sameHostIP(b.HostIP, p.IP) {
+						problems = append(problems, fmt.Sprintf("host port %d/%s is already published by %s", p.PublicPort, p.Type, containerName(c)))
+					}
+				}
+			}
+		}
+	}
+	return problems, nil
+}
+
+// hostPortRange parses a host port binding, "8080" or "8080-8090". An empty
+// binding lets Docker pick a free port and is reported as not ok.
+func hostPortRange(s string) (first, last uint16, ok bool) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	a, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return 0, 0, false
+	}
+	b := a
+	if isRange {
+		if b, err = strconv.ParseUint(hi, 10, 16); err != nil {
+			return 0, 0, false
+		}
+	}
+	return uint16(a), uint16(b), true
+}
+
+// sameHostIP reports whether two host addresses can clash, which they do
+// when they are equal or either one means all addresses.
+func sameHostIP(a, b string) bool {
+	wildcard := func(ip string) bool { return ip == "" || ip == "0.0.0.0" || ip == "::" }
+	return a == b || wildcard(a) || wildcard(b)
+}
+
+func containerName(c containertypes.Summary) string {
+	if len(c.Names) > 0 {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return c.ID[:12]
+}
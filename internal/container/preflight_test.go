@@ -0,0 +1,153 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+)
+
+func TestHostPortRange(t *testing.T) {
+	tests := []struct {
+		in          string
+		first, last uint16
+		ok          bool
+	}{
+		{"8080", 8080, 8080, true},
+		{"8000-8010", 8000, 8010, true},
+		{"", 0, 0, false},
+		{"http", 0, 0, false},
+		{"8000-x", 0, 0, false},
+	}
+	for _, tt := range tests {
+		first, last, ok := hostPortRange(tt.in)
+		if first != tt.first || last != tt.last || ok != tt.ok {
+			t.Errorf("hostPortRange(%q) = %d, %d, %v, want %d, %d, %v", tt.in, first, last, ok, tt.first, tt.last, tt.ok)
+		}
+	}
+}
+
+func TestSameHostIP(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"", "127.0.0.1", true},
+		{"0.0.0.0", "10.0.0.1", true},
+		{"::", "::1", true},
+		{"127.0.0.1", "127.0.0.1", true},
+		{"127.0.0.1", "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := sameHostIP(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameHostIP(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRecreate_Preflight(t *testing.T) {
+	web := nat.PortMap{"80/tcp": {{HostPort: "8080"}}}
+
+	tests := []struct {
+		name    string
+		host    *containertypes.HostConfig
+		setup   func(d *fakedocker.Daemon)
+		wantErr string
+	}{
+		{
+			name: "network removed",
+			host: &containertypes.HostConfig{NetworkMode: "backend"},
+			setup: func(d *fakedocker.Daemon) {
+				d.RemoveNetwork("backend")
+			},
+			wantErr: "network backend does not exist",
+		},
+		{
+			name: "volume removed",
+			host: &containertypes.HostConfig{Mounts: []mount.Mount{{Type: mount.TypeVolume, Source: "data", Target: "/data"}}},
+			setup: func(d *fakedocker.Daemon) {
+				d.RemoveVolume("data")
+			},
+			wantErr: "volume data does not exist",
+		},
+		{
+			name: "device removed",
+			host: &containertypes.HostConfig{Resources: containertypes.Resources{
+				Devices: []containertypes.DeviceMapping{{PathOnHost: "/dev/isengard-missing", PathInContainer: "/dev/ttyUSB0", CgroupPermissions: "rwm"}},
+			}},
+			setup:   func(d *fakedocker.Daemon) {},
+			wantErr: "device /dev/isengard-missing does not exist",
+		},
+		{
+			name: "create fails",
+			host: &containertypes.HostConfig{PortBindings: web},
+			setup: func(d *fakedocker.Daemon) {
+				d.Fail("ContainerCreate", errors.New("invalid mount config"))
+			},
+			wantErr: "invalid mount config",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := fakedocker.New()
+			d.AddImage(fakedocker.Image{Ref: "app"})
+			d.AddNetwork("backend")
+			d.AddVolume("data")
+			oldID := d.Run("app", &containertypes.Config{Image: "app"}, tt.host)
+			tt.setup(d)
+
+			_, err := Recreate(context.Background(), d, oldID, "app", nil, 10, docker.EngineDocker)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Recreate error = %v, want %q", err, tt.wantErr)
+			}
+
+			// The original is untouched and nothing was left behind.
+			if c, _ := d.Container("app"); c.ID != oldID || !c.State.Running {
+				t.Errorf("original container not left running")
+			}
+			for _, call := range d.Calls() {
+				if strings.HasPrefix(call, "ContainerStop") || strings.HasPrefix(call, "ContainerRemove "+oldID) {
+					t.Errorf("original container touched: %s", call)
+				}
+			}
+			if names := d.ContainerNames(); slices.Contains(names, "app"+replacementSuffix) {
+				t.Errorf("temporary replacement left behind: %v", names)
+			}
+		})
+	}
+}
+
+func TestPortConflicts(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "app"})
+	selfID := d.Run("self", &containertypes.Config{Image: "app"}, &containertypes.HostConfig{
+		PortBindings: nat.PortMap{"80/tcp": {{HostIP: "127.0.0.1", HostPort: "8080"}}},
+	})
+	d.Run("proxy", &containertypes.Config{Image: "app"}, &containertypes.HostConfig{
+		PortBindings: nat.PortMap{"443/tcp": {{HostPort: "8443"}}, "53/udp": {{HostPort: "5353"}}},
+	})
+
+	bindings := nat.PortMap{
+		"80/tcp":  {{HostIP: "127.0.0.1", HostPort: "8080"}},
+		"443/tcp": {{HostIP: "127.0.0.1", HostPort: "8440-8449"}},
+		"53/tcp":  {{HostPort: "5353"}},
+		"9/tcp":   {{HostPort: ""}},
+	}
+	got, err := portConflicts(context.Background(), d, selfID, bindings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"host port 8443/tcp is already published by proxy"}
+	if !slices.Equal(got, want) {
+		t.Errorf("portConflicts = %v, want %v", got, want)
+	}
+}
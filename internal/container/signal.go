@@ -0,0 +1,57 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// signalNames are the Linux signals the daemon accepts by name, without
+// their SIG prefix. Real-time signals are handled separately.
+var signalNames = map[string]bool{
+	"ABRT": true, "ALRM": true, "BUS": true, "CHLD": true, "CLD": true,
+	"CONT": true, "FPE": true, "HUP": true, "ILL": true, "INT": true,
+	"IO": true, "IOT": true, "KILL": true, "PIPE": true, "POLL": true,
+	"PROF": true, "PWR": true, "QUIT": true, "SEGV": true, "STKFLT": true,
+	"STOP": true, "SYS": true, "TERM": true, "TRAP": true, "TSTP": true,
+	"TTIN": true, "TTOU": true, "URG": true, "USR1": true, "USR2": true,
+	"VTALRM": true, "WINCH": true, "XCPU": true, "XFSZ": true,
+}
+
+// maxSignal is the highest Linux signal number.
+const maxSignal = 64
+
+// ParseSignal validates a signal given as a number ("3") or a name, with or
+// without its SIG prefix and in any case ("SIGQUIT", "quit", "RTMIN+3"). It
+// returns the signal in the form sent to the daemon: the number, or the name
+// in upper case with the SIG prefix.
+func ParseSignal(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > maxSignal {
+			return "", fmt.Errorf("signal %d out of range", n)
+		}
+		return s, nil
+	}
+
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	if signalNames[name] || realtimeSignal(name) {
+		return "SIG" + name, nil
+	}
+	return "", fmt.Errorf("unknown signal %q", s)
+}
+
+// realtimeSignal reports whether name is RTMIN, RTMAX, RTMIN+n or RTMAX-n
+// for a real-time signal Linux has (RTMIN is 34 and RTMAX 64).
+func realtimeSignal(name string) bool {
+	if name == "RTMIN" || name == "RTMAX" {
+		return true
+	}
+	for _, prefix := range []string{"RTMIN+", "RTMAX-"} {
+		if offset, ok := strings.CutPrefix(name, prefix); ok {
+			n, err := strconv.Atoi(offset)
+			return err == nil && n >= 1 && n <= 15
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package container
+
+import "testing"
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "SIGQUIT", want: "SIGQUIT"},
+		{in: "quit", want: "SIGQUIT"},
+		{in: " SigTerm ", want: "SIGTERM"},
+		{in: "3", want: "3"},
+		{in: "64", want: "64"},
+		{in: "SIGRTMIN+3", want: "SIGRTMIN+3"},
+		{in: "rtmax-15", want: "SIGRTMAX-15"},
+		{in: "0", wantErr: true},
+		{in: "65", wantErr: true},
+		{in: "SIGRTMIN+16", wantErr: true},
+		{in: "SIGSTAHP", wantErr: true},
+		{in: "SIG", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSignal(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSignal(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSignal(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package container
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	containertypes "github.com/docker/docker/api/types/container"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// Labels that change how a container is stopped.
+const (
+	// labelStopTimeout overrides the container's stop timeout, as a duration
+	// ("90s", "2m") or a number of seconds.
+	labelStopTimeout = "isengard.stop-timeout"
+	// labelStopSignal names a signal sent before the container's stop
+	// signal, e.g. SIGQUIT for a graceful nginx shutdown.
+	labelStopSignal = "isengard.stop-signal"
+)
+
+// Stop stops an inspected container the way Isengard stops containers it
+// replaces. If it has an isengard.stop-signal label, that signal is sent
+// first and the container given its stop timeout to exit. It is then stopped
+// with its own stop signal, SIGTERM unless it was created with another one,
+// and after another stop timeout the daemon kills it.
+//
+// The stop timeout is the isengard.stop-timeout label if it is valid, else
+// the timeout the container was created with, else fallback, in seconds.
+// Labels are read from labels, the container's labels with config file
+// overrides applied, or from the container itself if labels is nil.
+func Stop(ctx context.Context, cli docker.API, inspect containertypes.InspectResponse, labels map[string]string, fallback int) error {
+	if labels == nil {
+		labels = inspect.Config.Labels
+	}
+	name := strings.TrimPrefix(inspect.Name, "/")
+	timeout := stopTimeout(inspect.Config, labels, fallback)
+
+	if sig := stopSignal(labels); sig != "" {
+		slog.Debug("sending stop signal", "container", name, "signal", sig, "timeout", timeout)
+		if err := cli.ContainerKill(ctx, inspect.ID, sig); err != nil {
+			slog.Warn("could not send stop signal", "container", name, "signal", sig, "error", err)
+		} else if !exited(ctx, cli, inspect.ID, timeout) {
+			slog.Info("container still running after stop signal", "container", name, "signal", sig, "timeout", timeout)
+		}
+	}
+
+	// Stop even if the container already exited, so that its restart policy
+	// does not bring it back.
+	return cli.ContainerStop(ctx, inspect.ID, containertypes.StopOptions{
+		Signal:  inspect.Config.StopSignal,
+		Timeout: &timeout,
+	})
+}
+
+// stopTimeout returns the stop timeout of a container in seconds.
+func stopTimeout(config *containertypes.Config, labels map[string]string, fallback int) int {
+	if v, ok := labels[labelStopTimeout]; ok {
+		if n, ok := parseStopTimeout(v); ok {
+			return n
+		}
+		slog.Warn("invalid stop timeout label, ignoring it", "value", v)
+	}
+	if config.StopTimeout != nil {
+		return *config.StopTimeout
+	}
+	return fallback
+}
+
+// stopSignal returns the signal named by the isengard.stop-signal label, or
+// "" if there is none or it is not a signal.
+func stopSignal(labels map[string]string) string {
+	v, ok := labels[labelStopSignal]
+	if !ok || strings.TrimSpace(v) == "" {
+		return ""
+	}
+	sig, err := ParseSignal(v)
+	if err != nil {
+		slog.Warn("invalid stop signal label, ignoring it", "value", v, "error", err)
+		return ""
+	}
+	return sig
+}
+
+// parseStopTimeout parses a stop timeout label, rounding durations up to
+// whole seconds.
+func parseStopTimeout(v string) (int, bool) {
+	v = strings.TrimSpace(v)
+	if n, err := strconv.Atoi(v); err == nil {
+		return n, n >= 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, false
+	}
+	return int((d + time.Second - 1) / time.Second), true
+}
+
+// exited waits up to timeout seconds for a container to stop running and
+// reports whether it did. A negative timeout waits indefinitely.
+func exited(ctx context.Context, cli docker.API, containerID string, timeout int) bool {
+	if timeout >= 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	resultC, errC := cli.ContainerWait(ctx, containerID, containertypes.WaitConditionNotRunning)
+	select {
+	case <-resultC:
+		return true
+	case <-errC:
+		return false
+	}
+}
@@ -0,0 +1,123 @@
+package container
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+)
+
+func TestStopTimeout(t *testing.T) {
+	five := 5
+	tests := []struct {
+		name   string
+		config containertypes.Config
+		want   int
+	}{
+		{"default", containertypes.Config{}, 30},
+		{"container timeout", containertypes.Config{StopTimeout: &five}, 5},
+		{"label seconds", containertypes.Config{StopTimeout: &five, Labels: map[string]string{labelStopTimeout: "90"}}, 90},
+		{"label duration", containertypes.Config{Labels: map[string]string{labelStopTimeout: "2m"}}, 120},
+		{"label rounded up", containertypes.Config{Labels: map[string]string{labelStopTimeout: "1500ms"}}, 2},
+		{"label zero", containertypes.Config{Labels: map[string]string{labelStopTimeout: "0"}}, 0},
+		{"label invalid", containertypes.Config{StopTimeout: &five, Labels: map[string]string{labelStopTimeout: "soon"}}, 5},
+		{"label negative", containertypes.Config{Labels: map[string]string{labelStopTimeout: "-5s"}}, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stopTimeout(&tt.config, tt.config.Labels, 30); got != tt.want {
+				t.Errorf("stopTimeout = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	three := 3
+	tests := []struct {
+		name        string
+		config      containertypes.Config
+		ignore      string // Signal the container does not exit on.
+		wantKill    bool
+		wantSignal  string
+		wantTimeout int
+	}{
+		{
+			name:        "defaults",
+			config:      containertypes.Config{Image: "app"},
+			wantTimeout: 10,
+		},
+		{
+			name:        "container stop signal and timeout",
+			config:      containertypes.Config{Image: "app", StopSignal: "SIGINT", StopTimeout: &three},
+			wantSignal:  "SIGINT",
+			wantTimeout: 3,
+		},
+		{
+			name: "custom signal",
+			config: containertypes.Config{Image: "app", Labels: map[string]string{
+				labelStopSignal:  "SIGQUIT",
+				labelStopTimeout: "20",
+			}},
+			wantKill:    true,
+			wantTimeout: 20,
+		},
+		{
+			name:        "custom signal without prefix",
+			config:      containertypes.Config{Image: "app", Labels: map[string]string{labelStopSignal: "quit"}},
+			wantKill:    true,
+			wantTimeout: 10,
+		},
+		{
+			name:        "invalid custom signal",
+			config:      containertypes.Config{Image: "app", Labels: map[string]string{labelStopSignal: "SIGSTAHP"}},
+			wantTimeout: 10,
+		},
+		{
+			name: "custom signal ignored",
+			config: containertypes.Config{Image: "app", StopSignal: "SIGINT", Labels: map[string]string{
+				labelStopSignal:  "SIGQUIT",
+				labelStopTimeout: "0",
+			}},
+			ignore:      "SIGQUIT",
+			wantKill:    true,
+			wantSignal:  "SIGINT",
+			wantTimeout: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := fakedocker.New()
+			d.AddImage(fakedocker.Image{Ref: "app"})
+			d.Signal = func(_, signal string) bool { return signal != tt.ignore }
+			id := d.Run("app", &tt.config, nil)
+			inspect, _ := d.ContainerInspect(context.Background(), id)
+
+			if err := Stop(context.Background(), d, inspect, nil, 10); err != nil {
+				t.Fatalf("Stop: %v", err)
+			}
+
+			if c, _ := d.Container(id); c.State.Running {
+				t.Error("container still running")
+			}
+			if killed := slices.Contains(d.Calls(), "ContainerKill "+id+" SIGQUIT"); killed != tt.wantKill {
+				t.Errorf("custom signal sent = %v, want %v", killed, tt.wantKill)
+			}
+			if !tt.wantKill && slices.ContainsFunc(d.Calls(), func(c string) bool { return strings.HasPrefix(c, "ContainerKill") }) {
+				t.Errorf("a signal was sent before stopping: %v", d.Calls())
+			}
+			opts, ok := d.StopOptions(id)
+			if !ok {
+				t.Fatal("container was not stopped through the API")
+			}
+			if opts.Signal != tt.wantSignal || opts.Timeout == nil || *opts.Timeout != tt.wantTimeout {
+				t.Errorf("stopped with signal %q timeout %v, want %q %d", opts.Signal, opts.Timeout, tt.wantSignal, tt.wantTimeout)
+			}
+		})
+	}
+}
@@ -3,27 +3,71 @@ package docker
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/client"
 
 	"github.com/dirdmaster/isengard/internal/registry"
 )
 
-// NewClient connects to the local Docker daemon using DOCKER_HOST
-// and related environment variables, with automatic API version negotiation.
-func NewClient() (*client.Client, error) {
-	return client.NewClientWithOpts(
-		client.FromEnv,
-		client.WithAPIVersionNegotiation(),
-	)
+// Endpoint describes how to reach a Docker daemon.
+type Endpoint struct {
+	// Host is the daemon URL: unix:///path, tcp://host:port or
+	// ssh://[user@]host[:port][/socket]. Empty uses DOCKER_HOST and related
+	// environment variables.
+	Host string
+	// TLSDir holds ca.pem, cert.pem and key.pem for a tcp:// host. Empty
+	// connects without TLS.
+	TLSDir string
+	// SSHKey and SSHKnownHosts authenticate an ssh:// host, see [SSHDialer].
+	SSHKey        string
+	SSHKnownHosts string
+}
+
+// NewClient connects to the Docker daemon at ep, with automatic API version
+// negotiation. Connections are made lazily, so an unreachable daemon is only
+// reported by the first request.
+func NewClient(ep Endpoint) (*client.Client, error) {
+	opts := []client.Opt{client.WithAPIVersionNegotiation()}
+
+	u, err := url.Parse(ep.Host)
+	switch {
+	case ep.Host == "":
+		opts = append(opts, client.FromEnv)
+	case err != nil:
+		return nil, fmt.Errorf("parsing Docker host %q: %w", ep.Host, err)
+	case u.Scheme == "ssh":
+		dialer, err := NewSSHDialer(u, ep.SSHKey, ep.SSHKnownHosts)
+		if err != nil {
+			return nil, err
+		}
+		// The host only names the daemon in request URLs; connections go
+		// through the dialer.
+		opts = append(opts, client.WithHost("http://docker.invalid"), client.WithDialContext(dialer.DialContext))
+	default:
+		opts = append(opts, client.WithHost(ep.Host))
+		if u.Scheme == "tcp" && ep.TLSDir != "" {
+			opts = append(opts, client.WithTLSClientConfig(
+				filepath.Join(ep.TLSDir, "ca.pem"),
+				filepath.Join(ep.TLSDir, "cert.pem"),
+				filepath.Join(ep.TLSDir, "key.pem"),
+			))
+		}
+	}
+	return client.NewClientWithOpts(opts...)
 }
 
 // PullImage pulls the latest version of an image and returns the new image ID.
 // It uses credentials from ~/.docker/config.json via the registry package.
-func PullImage(ctx context.Context, cli *client.Client, imageRef string) (string, error) {
+func PullImage(ctx context.Context, cli API, imageRef string) (string, error) {
 	opts := image.PullOptions{}
 
 	if auth := registry.AuthForImage(imageRef); auth != "" {
@@ -47,8 +91,17 @@ func PullImage(ctx context.Context, cli *client.Client, imageRef string) (string
 	return inspect.ID, nil
 }
 
+// ImageCreated returns the build time of a local image.
+func ImageCreated(ctx context.Context, cli API, imageID string) (time.Time, error) {
+	inspect, err := cli.ImageInspect(ctx, imageID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, inspect.Created)
+}
+
 // RemoveImage removes an image by ID, ignoring errors (image may be in use).
-func RemoveImage(ctx context.Context, cli *client.Client, imageID string) {
+func RemoveImage(ctx context.Context, cli API, imageID string) {
 	opts := image.RemoveOptions{PruneChildren: true}
 	_, err := cli.ImageRemove(ctx, imageID, opts)
 	if err != nil {
@@ -57,3 +110,36 @@ func RemoveImage(ctx context.Context, cli *client.Client, imageID string) {
 		slog.Info("removed old image", "image", imageID[:12])
 	}
 }
+
+// TagImage adds a tag (e.g. "isengard-rollback/web:3") to a local image.
+func TagImage(ctx context.Context, cli API, imageID, ref string) error {
+	return cli.ImageTag(ctx, imageID, ref)
+}
+
+// UntagImage removes a single tag. Docker deletes the image as well if this
+// was its last reference and no container uses it.
+func UntagImage(ctx context.Context, cli API, ref string) error {
+	_, err := cli.ImageRemove(ctx, ref, image.RemoveOptions{PruneChildren: true})
+	return err
+}
+
+// TagsInRepository returns the local tags of a repository (e.g.
+// "isengard-rollback/web"), mapped to the image ID each tag points to.
+func TagsInRepository(ctx context.Context, cli API, repository string) (map[string]string, error) {
+	images, err := cli.ImageList(ctx, image.ListOptions{
+		Filters: filters.NewArgs(filters.Arg("reference", repository)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{}
+	for _, img := range images {
+		for _, rt := range img.RepoTags {
+			if repo, tag, ok := strings.Cut(rt, ":"); ok && repo == repository {
+				tags[tag] = img.ID
+			}
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+)
+
+// Engine identifies the container engine serving the Docker API.
+type Engine string
+
+const (
+	EngineDocker Engine = "docker"
+	EnginePodman Engine = "podman"
+)
+
+// DetectEngine asks the daemon which engine it is. Podman's
+// Docker-compatible API names itself in the platform and components of its
+// version response.
+func DetectEngine(ctx context.Context, cli API) (Engine, error) {
+	v, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("reading daemon version: %w", err)
+	}
+	return engineOf(v), nil
+}
+
+func engineOf(v types.Version) Engine {
+	if strings.Contains(strings.ToLower(v.Platform.Name), "podman") {
+		return EnginePodman
+	}
+	for _, c := range v.Components {
+		if strings.Contains(strings.ToLower(c.Name), "podman") {
+			return EnginePodman
+		}
+	}
+	return EngineDocker
+}
+
+// libpodVersion prefixes native Podman API paths. Podman 4 and later serve
+// their current API under any version prefix.
+const libpodVersion = "/v4.0.0"
+
+// httpAPI is implemented by API clients that expose their HTTP connection,
+// as [*client.Client] does.
+type httpAPI interface {
+	DaemonHost() string
+	HTTPClient() *http.Client
+}
+
+// Libpod calls Podman's native API, for the few things its
+// Docker-compatible API does not expose, over the connection cli uses. in is
+// sent as the JSON request body unless nil, and the JSON response is decoded
+// into out unless nil.
+func Libpod(ctx context.Context, cli API, method, path string, in, out any) error {
+	h, ok := cli.(httpAPI)
+	if !ok {
+		return fmt.Errorf("%s %s: client has no HTTP connection for the native Podman API", method, path)
+	}
+	base, err := baseURL(h)
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("encoding request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, base+libpodVersion+"/libpod"+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := h.HTTPClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+		if e.Message == "" {
+			e.Message = resp.Status
+		}
+		return fmt.Errorf("%s %s: %s", method, path, e.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// baseURL returns the URL requests to the daemon are made against. Local
+// sockets are reached through the client's dialer whatever the host, as the
+// Docker client itself does.
+func baseURL(cli httpAPI) (string, error) {
+	u, err := client.ParseHostURL(cli.DaemonHost())
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "unix", "npipe":
+		return "http://" + client.DummyHost, nil
+	case "tcp":
+		if t, ok := cli.HTTPClient().Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+			return "https://" + u.Host, nil
+		}
+		return "http://" + u.Host, nil
+	default:
+		return u.Scheme + "://" + u.Host, nil
+	}
+}
@@ -0,0 +1,28 @@
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestEngineOf(t *testing.T) {
+	tests := []struct {
+		name string
+		v    types.Version
+		want Engine
+	}{
+		{"docker", types.Version{Platform: struct{ Name string }{"Docker Engine - Community"}, Components: []types.ComponentVersion{{Name: "Engine"}, {Name: "containerd"}}}, EngineDocker},
+		{"podman platform", types.Version{Platform: struct{ Name string }{"linux/amd64/fedora-40"}, Components: []types.ComponentVersion{{Name: "Podman Engine"}}}, EnginePodman},
+		{"podman name only in platform", types.Version{Platform: struct{ Name string }{"Podman Engine"}}, EnginePodman},
+		{"empty", types.Version{}, EngineDocker},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := engineOf(tt.v); got != tt.want {
+				t.Errorf("engineOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultRemoteSocket is the daemon socket used when an ssh:// URL has no path.
+const defaultRemoteSocket = "/var/run/docker.sock"
+
+// SSHDialer reaches a remote Docker socket over SSH by forwarding a Unix
+// socket connection (direct-streamlocal), like `ssh -L` does, so nothing but
+// sshd is needed on the remote host. One SSH connection is shared by all
+// requests and re-established when it breaks.
+type SSHDialer struct {
+	addr   string
+	socket string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewSSHDialer prepares a dialer for an ssh://[user@]host[:port][/socket]
+// URL. It authenticates with the private key file keyPath, or with the agent
+// at SSH_AUTH_SOCK when keyPath is empty, and verifies the host key against
+// knownHostsPath.
+func NewSSHDialer(u *url.URL, keyPath, knownHostsPath string) (*SSHDialer, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("ssh URL %q has no host", u.Redacted())
+	}
+
+	hostKeys, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading ssh known hosts: %w", err)
+	}
+
+	auth, err := sshAuth(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	username := u.User.Username()
+	if username == "" {
+		if cur, err := user.Current(); err == nil {
+			username = cur.Username
+		}
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "22"
+	}
+	socket := u.Path
+	if socket == "" {
+		socket = defaultRemoteSocket
+	}
+
+	return &SSHDialer{
+		addr:   net.JoinHostPort(u.Hostname(), port),
+		socket: socket,
+		config: &ssh.ClientConfig{
+			User:            username,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: hostKeys,
+			Timeout:         10 * time.Second,
+		},
+	}, nil
+}
+
+// sshAuth loads the private key at keyPath, or falls back to the SSH agent.
+func sshAuth(keyPath string) (ssh.AuthMethod, error) {
+	if keyPath != "" {
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading ssh key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ssh key %s: %w", keyPath, err)
+		}
+		return ssh.PublicKeys(signer), nil
+	}
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("ssh host needs ISENGARD_SSH_KEY or an agent at SSH_AUTH_SOCK")
+	}
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to ssh agent: %w", err)
+		}
+		defer conn.Close()
+		return agent.NewClient(conn).Signers()
+	}), nil
+}
+
+// DialContext opens a connection to the remote Docker socket. The network
+// and address requested by the Docker client are ignored.
+func (d *SSHDialer) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for attempt := 0; ; attempt++ {
+		if d.client == nil {
+			c, err := d.connect(ctx)
+			if err != nil {
+				return nil, err
+			}
+			d.client = c
+		}
+
+		conn, err := d.client.Dial("unix", d.socket)
+		if err == nil {
+			return conn, nil
+		}
+
+		// The connection may have gone stale; reconnect once.
+		d.client.Close()
+		d.client = nil
+		if attempt > 0 {
+			return nil, fmt.Errorf("opening %s on %s: %w", d.socket, d.addr, err)
+		}
+	}
+}
+
+func (d *SSHDialer) connect(ctx context.Context) (*ssh.Client, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", d.addr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.addr, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh handshake with %s: %w", d.addr, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
@@ -0,0 +1,142 @@
+// Package events turns the Docker events stream into debounced update-check
+// triggers, so newly started containers and freshly pulled or re-tagged
+// images are checked without waiting for the next scheduled cycle.
+package events
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// reconnectDelay is how long to wait before resubscribing after the event
+// stream fails.
+const reconnectDelay = 5 * time.Second
+
+// Trigger names the containers and image references that changed. Images
+// are the references that were pulled or tagged, e.g. "nginx:latest".
+type Trigger struct {
+	Containers []string
+	Images     []string
+}
+
+// Watch subscribes to container start and image pull/tag events until ctx is
+// cancelled. Events are collected until debounce has passed without a new
+// one, then delivered as a single [Trigger]. The stream is re-established
+// after errors.
+func Watch(ctx context.Context, cli docker.API, debounce time.Duration) <-chan Trigger {
+	out := make(chan Trigger)
+	msgs := make(chan events.Message)
+
+	go subscribe(ctx, cli, msgs)
+	go debounceLoop(ctx, msgs, debounce, out)
+	return out
+}
+
+// subscribe forwards matching events to msgs, resubscribing after errors.
+func subscribe(ctx context.Context, cli docker.API, msgs chan<- events.Message) {
+	f := filters.NewArgs(
+		filters.Arg("type", string(events.ContainerEventType)),
+		filters.Arg("type", string(events.ImageEventType)),
+		filters.Arg("event", string(events.ActionStart)),
+		filters.Arg("event", string(events.ActionPull)),
+		filters.Arg("event", string(events.ActionTag)),
+	)
+
+	for ctx.Err() == nil {
+		stream, errs := cli.Events(ctx, events.ListOptions{Filters: f})
+		slog.Debug("subscribed to Docker events")
+
+	recv:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				if ctx.Err() == nil {
+					slog.Warn("Docker event stream failed, reconnecting", "error", err, "delay", reconnectDelay)
+				}
+				break recv
+			case m := <-stream:
+				select {
+				case msgs <- m:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// debounceLoop batches messages and sends a Trigger once debounce has
+// passed since the last relevant message.
+func debounceLoop(ctx context.Context, msgs <-chan events.Message, debounce time.Duration, out chan<- Trigger) {
+	containers := map[string]bool{}
+	images := map[string]bool{}
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-msgs:
+			if !collect(m, containers, images) {
+				continue
+			}
+			timer = time.After(debounce)
+		case <-timer:
+			t := Trigger{Containers: keys(containers), Images: keys(images)}
+			clear(containers)
+			clear(images)
+			timer = nil
+			select {
+			case out <- t:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// collect records what an event refers to and reports whether it was
+// relevant.
+func collect(m events.Message, containers, images map[string]bool) bool {
+	name := m.Actor.Attributes["name"]
+	switch {
+	case m.Type == events.ContainerEventType && m.Action == events.ActionStart && name != "":
+		containers[name] = true
+	case m.Type == events.ImageEventType && (m.Action == events.ActionPull || m.Action == events.ActionTag):
+		// Pull events carry the reference as the actor ID; tag events carry
+		// the image ID there and the new reference in the name attribute.
+		if name == "" {
+			name = m.Actor.ID
+		}
+		if name == "" {
+			return false
+		}
+		images[name] = true
+	default:
+		return false
+	}
+	return true
+}
+
+func keys(m map[string]bool) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
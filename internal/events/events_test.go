@@ -0,0 +1,91 @@
+package events
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+func containerStart(name string) events.Message {
+	return events.Message{
+		Type:   events.ContainerEventType,
+		Action: events.ActionStart,
+		Actor:  events.Actor{ID: "abc", Attributes: map[string]string{"name": name}},
+	}
+}
+
+func imageEvent(action events.Action, id, name string) events.Message {
+	attrs := map[string]string{}
+	if name != "" {
+		attrs["name"] = name
+	}
+	return events.Message{
+		Type:   events.ImageEventType,
+		Action: action,
+		Actor:  events.Actor{ID: id, Attributes: attrs},
+	}
+}
+
+func TestDebounceLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs := make(chan events.Message)
+	out := make(chan Trigger)
+	go debounceLoop(ctx, msgs, 50*time.Millisecond, out)
+
+	for _, m := range []events.Message{
+		containerStart("web"),
+		containerStart("web"),
+		imageEvent(events.ActionPull, "nginx:latest", ""),
+		imageEvent(events.ActionTag, "sha256:abc", "redis:7"),
+		{Type: events.ContainerEventType, Action: events.ActionStop},
+		containerStart("db"),
+	} {
+		msgs <- m
+	}
+
+	select {
+	case got := <-out:
+		if !slices.Equal(got.Containers, []string{"db", "web"}) {
+			t.Errorf("Containers = %v, want [db web]", got.Containers)
+		}
+		if !slices.Equal(got.Images, []string{"nginx:latest", "redis:7"}) {
+			t.Errorf("Images = %v, want [nginx:latest redis:7]", got.Images)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no trigger delivered")
+	}
+
+	// A new burst after delivery produces a separate trigger.
+	msgs <- containerStart("api")
+	select {
+	case got := <-out:
+		if !slices.Equal(got.Containers, []string{"api"}) || len(got.Images) != 0 {
+			t.Errorf("second trigger = %+v, want only api", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no second trigger delivered")
+	}
+}
+
+func TestDebounceIgnoresIrrelevantEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	msgs := make(chan events.Message)
+	out := make(chan Trigger)
+	go debounceLoop(ctx, msgs, 20*time.Millisecond, out)
+
+	msgs <- events.Message{Type: events.ContainerEventType, Action: events.ActionDie}
+	msgs <- imageEvent(events.ActionDelete, "sha256:abc", "")
+
+	select {
+	case got := <-out:
+		t.Errorf("unexpected trigger %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
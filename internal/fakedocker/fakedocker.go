@@ -0,0 +1,1045 @@
+// Package fakedocker provides an in-memory Docker daemon implementing
+// [docker.API], so the update flow can be tested without a real daemon.
+//
+// The daemon keeps containers, images, networks and Swarm services in
+// memory and enforces the rules Isengard relies on: names are unique,
+// running containers cannot be removed without force, images in use cannot
+// be removed, and networks must exist before containers join them.
+// Containers inherit the defaults of their image's config at create. Images
+// become pullable once they are published to the daemon's simulated
+// registry with [Daemon.Publish].
+package fakedocker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/docker/api/types/system"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	dockerspec "github.com/moby/docker-image-spec/specs-go/v1"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+var _ docker.API = (*Daemon)(nil)
+
+// Image describes an image to add to the daemon or publish to its registry.
+type Image struct {
+	// Ref is the image reference, e.g. "nginx:1.25".
+	Ref string
+	// Digest is the manifest digest the registry serves Ref under. Images
+	// with a digest get a matching RepoDigests entry when pulled or added.
+	Digest string
+	// Created is the build time; it defaults to the zero time.
+	Created time.Time
+	// Config holds the defaults containers created from the image inherit.
+	Config ocispec.ImageConfig
+}
+
+// ID returns the image ID the daemon assigns to img, derived from its
+// reference and digest.
+func (img Image) ID() string {
+	sum := sha256.Sum256([]byte(img.Ref + "@" + img.Digest))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Daemon is an in-memory Docker daemon. The zero value is not usable; call
+// [New]. All methods are safe for concurrent use.
+type Daemon struct {
+	// Exec, if set, runs commands started with ContainerExecCreate and
+	// returns their exit code and output. By default they succeed silently.
+	Exec func(containerID string, cmd []string) (int, string)
+	// Signal, if set, reports whether a container exits when it is sent
+	// signal with ContainerKill. By default every signal stops it. It is
+	// called with the daemon locked and must not call the daemon.
+	Signal func(containerID, signal string) bool
+
+	mu          sync.Mutex
+	containers  map[string]*fakeContainer
+	images      map[string]*fakeImage
+	registry    map[string]Image
+	networks    map[string]bool
+	volumes     map[string]bool
+	services    map[string]*swarm.Service
+	execs       map[string]*fakeExec
+	stops       map[string]containertypes.StopOptions
+	subscribers []chan events.Message
+	failures    map[string]error
+	calls       []string
+	swarm       swarm.Info
+	version     types.Version
+	serial      int
+	updateState swarm.UpdateState
+}
+
+type fakeContainer struct {
+	inspect containertypes.InspectResponse
+}
+
+type fakeImage struct {
+	id          string
+	tags        []string
+	repoDigests []string
+	created     time.Time
+	config      ocispec.ImageConfig
+}
+
+type fakeExec struct {
+	containerID string
+	cmd         []string
+	exitCode    int
+}
+
+// New returns an empty daemon with the default bridge, host and none
+// networks, identifying itself as Docker.
+func New() *Daemon {
+	return &Daemon{
+		containers:  map[string]*fakeContainer{},
+		images:      map[string]*fakeImage{},
+		registry:    map[string]Image{},
+		networks:    map[string]bool{"bridge": true, "host": true, "none": true},
+		volumes:     map[string]bool{},
+		services:    map[string]*swarm.Service{},
+		execs:       map[string]*fakeExec{},
+		stops:       map[string]containertypes.StopOptions{},
+		failures:    map[string]error{},
+		version:     types.Version{Version: "28.5.2", Components: []types.ComponentVersion{{Name: "Engine", Version: "28.5.2"}}},
+		updateState: swarm.UpdateStateCompleted,
+	}
+}
+
+// SetVersion replaces the daemon's version response, e.g. to pose as Podman.
+func (d *Daemon) SetVersion(v types.Version) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.version = v
+}
+
+// SetSwarm sets the Swarm state reported by Info.
+func (d *Daemon) SetSwarm(info swarm.Info) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.swarm = info
+}
+
+// SetServiceUpdateState sets the state that service updates end in, to
+// simulate Swarm pausing or rolling back an update.
+func (d *Daemon) SetServiceUpdateState(state swarm.UpdateState) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.updateState = state
+}
+
+// Fail makes the next call to the named API method (e.g. "ContainerStart")
+// return err.
+func (d *Daemon) Fail(method string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures[method] = err
+}
+
+// Calls returns the API calls made so far, as "Method arg", e.g.
+// "ContainerStop web".
+func (d *Daemon) Calls() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.calls)
+}
+
+// call records an API call and returns the error injected for it, if any.
+// The caller must hold d.mu.
+func (d *Daemon) call(method, arg string) error {
+	d.calls = append(d.calls, strings.TrimSpace(method+" "+arg))
+	if err, ok := d.failures[method]; ok {
+		delete(d.failures, method)
+		return err
+	}
+	return nil
+}
+
+// AddNetwork creates a user-defined network.
+func (d *Daemon) AddNetwork(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.networks[name] = true
+}
+
+// RemoveNetwork deletes a network, whether or not containers use it.
+func (d *Daemon) RemoveNetwork(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.networks, name)
+}
+
+// RemoveVolume deletes a volume, whether or not containers use it.
+func (d *Daemon) RemoveVolume(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.volumes, name)
+}
+
+// AddVolume creates a named volume.
+func (d *Daemon) AddVolume(name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.volumes[name] = true
+}
+
+// AddImage stores an image locally, as if it had been pulled, and returns
+// its ID.
+func (d *Daemon) AddImage(img Image) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.storeImage(img)
+}
+
+// Publish makes img the image the simulated registry serves for img.Ref.
+func (d *Daemon) Publish(img Image) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.registry[normalize(img.Ref)] = img
+}
+
+// Run creates and starts a container from config, which must name an image
+// the daemon has, attached to networks (the bridge network if none are
+// given). It returns the container ID and panics on failure.
+func (d *Daemon) Run(name string, config *containertypes.Config, hostConfig *containertypes.HostConfig, networks ...string) string {
+	if hostConfig == nil {
+		hostConfig = &containertypes.HostConfig{}
+	}
+	var nc *network.NetworkingConfig
+	if len(networks) > 0 {
+		nc = &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+		for _, n := range networks {
+			nc.EndpointsConfig[n] = &network.EndpointSettings{}
+		}
+	}
+
+	ctx := context.Background()
+	resp, err := d.ContainerCreate(ctx, config, hostConfig, nc, nil, name)
+	if err != nil {
+		panic(err)
+	}
+	if err := d.ContainerStart(ctx, resp.ID, containertypes.StartOptions{}); err != nil {
+		panic(err)
+	}
+	return resp.ID
+}
+
+// Container returns the inspect output of the container with the given
+// name or ID.
+func (d *Daemon) Container(nameOrID string) (containertypes.InspectResponse, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.findContainer(nameOrID)
+	if c == nil {
+		return containertypes.InspectResponse{}, false
+	}
+	return clone(c.inspect), true
+}
+
+// ContainerNames returns the names of all containers, running or not.
+func (d *Daemon) ContainerNames() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for _, c := range d.containers {
+		names = append(names, strings.TrimPrefix(c.inspect.Name, "/"))
+	}
+	slices.Sort(names)
+	return names
+}
+
+// HasImage reports whether the daemon has a local image with the given
+// reference or ID.
+func (d *Daemon) HasImage(refOrID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.findImage(refOrID) != nil
+}
+
+// AddService creates a Swarm service from spec and returns its ID.
+func (d *Daemon) AddService(spec swarm.ServiceSpec) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.newID()
+	d.services[id] = &swarm.Service{ID: id, Meta: swarm.Meta{Version: swarm.Version{Index: 1}}, Spec: clone(spec)}
+	return id
+}
+
+// Service returns the service with the given name or ID.
+func (d *Daemon) Service(nameOrID string) (swarm.Service, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := d.findService(nameOrID)
+	if s == nil {
+		return swarm.Service{}, false
+	}
+	return clone(*s), true
+}
+
+// Emit delivers an event to every Events subscriber that has room for it.
+// Container starts, image pulls and tags emit their events by themselves.
+func (d *Daemon) Emit(m events.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.emit(m)
+}
+
+// emit delivers an event. The caller must hold d.mu.
+func (d *Daemon) emit(m events.Message) {
+	for _, s := range d.subscribers {
+		select {
+		case s <- m:
+		default:
+		}
+	}
+}
+
+// ContainerList lists running containers, or all of them with options.All.
+// Filters are not supported.
+func (d *Daemon) ContainerList(_ context.Context, options containertypes.ListOptions) ([]containertypes.Summary, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerList", ""); err != nil {
+		return nil, err
+	}
+
+	var list []containertypes.Summary
+	for _, c := range d.containers {
+		in := c.inspect
+		if !options.All && !in.State.Running {
+			continue
+		}
+		list = append(list, containertypes.Summary{
+			ID:      in.ID,
+			Names:   []string{in.Name},
+			Image:   in.Config.Image,
+			ImageID: in.Image,
+			Labels:  labels(in.Config.Labels),
+			State:   in.State.Status,
+			Created: d.createdUnix(in),
+			Ports:   ports(in),
+		})
+	}
+	slices.SortFunc(list, func(a, b containertypes.Summary) int { return strings.Compare(a.Names[0], b.Names[0]) })
+	return list, nil
+}
+
+func (d *Daemon) createdUnix(in containertypes.InspectResponse) int64 {
+	t, _ := time.Parse(time.RFC3339Nano, in.Created)
+	return t.Unix()
+}
+
+// ContainerInspect returns a copy of a container's state.
+func (d *Daemon) ContainerInspect(_ context.Context, containerID string) (containertypes.InspectResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerInspect", containerID); err != nil {
+		return containertypes.InspectResponse{}, err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return containertypes.InspectResponse{}, noSuchContainer(containerID)
+	}
+	return clone(c.inspect), nil
+}
+
+// ContainerCreate creates a stopped container. Without a networking config
+// it joins the network named by hostConfig.NetworkMode, or bridge.
+func (d *Daemon) ContainerCreate(_ context.Context, config *containertypes.Config, hostConfig *containertypes.HostConfig, networkingConfig *network.NetworkingConfig, _ *ocispec.Platform, containerName string) (containertypes.CreateResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerCreate", containerName); err != nil {
+		return containertypes.CreateResponse{}, err
+	}
+
+	if containerName != "" && d.findByName(containerName) != nil {
+		return containertypes.CreateResponse{}, fmt.Errorf("conflict: the container name %q is already in use: %w", "/"+containerName, cerrdefs.ErrConflict)
+	}
+	img := d.findImage(config.Image)
+	if img == nil {
+		return containertypes.CreateResponse{}, fmt.Errorf("no such image: %s: %w", config.Image, cerrdefs.ErrNotFound)
+	}
+	if hostConfig == nil {
+		hostConfig = &containertypes.HostConfig{}
+	}
+
+	id := d.newID()
+	if containerName == "" {
+		containerName = "container_" + id[:8]
+	}
+
+	endpoints := map[string]*network.EndpointSettings{}
+	if networkingConfig != nil && len(networkingConfig.EndpointsConfig) > 0 {
+		for name, ep := range networkingConfig.EndpointsConfig {
+			if !d.networks[name] {
+				return containertypes.CreateResponse{}, noSuchNetwork(name)
+			}
+			endpoints[name] = endpoint(name, containerName, id, ep)
+		}
+	} else {
+		mode := string(hostConfig.NetworkMode)
+		if mode == "" || mode == "default" {
+			mode = "bridge"
+		}
+		if d.networks[mode] {
+			endpoints[mode] = endpoint(mode, containerName, id, nil)
+		}
+	}
+
+	cfg := clone(*config)
+	inherit(&cfg, img.config)
+	hc := clone(*hostConfig)
+
+	// Like Docker, report the MAC address asked for on the primary network
+	// in Config.MacAddress, and accept it there too.
+	primary := string(hc.NetworkMode)
+	if primary == "" || primary == "default" {
+		primary = "bridge"
+	}
+	var requested *network.EndpointSettings
+	if networkingConfig != nil {
+		requested = networkingConfig.EndpointsConfig[primary]
+	}
+	if ep := endpoints[primary]; ep != nil {
+		if requested != nil && requested.MacAddress != "" {
+			cfg.MacAddress = requested.MacAddress
+		} else if cfg.MacAddress != "" {
+			ep.MacAddress = cfg.MacAddress
+		}
+	}
+
+	mounts := mountPoints(&cfg, &hc, d.newID)
+	for _, m := range mounts {
+		if m.Type == mount.TypeVolume {
+			d.volumes[m.Name] = true
+		}
+	}
+	d.containers[id] = &fakeContainer{inspect: containertypes.InspectResponse{
+		ContainerJSONBase: &containertypes.ContainerJSONBase{
+			ID:         id,
+			Name:       "/" + containerName,
+			Created:    time.Now().UTC().Format(time.RFC3339Nano),
+			Image:      img.id,
+			HostConfig: &hc,
+			State:      &containertypes.State{Status: containertypes.StateCreated},
+		},
+		Config:          &cfg,
+		Mounts:          mounts,
+		NetworkSettings: &containertypes.NetworkSettings{Networks: endpoints},
+	}}
+	return containertypes.CreateResponse{ID: id}, nil
+}
+
+// ContainerStart starts a container.
+func (d *Daemon) ContainerStart(_ context.Context, containerID string, _ containertypes.StartOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerStart", containerID); err != nil {
+		return err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return noSuchContainer(containerID)
+	}
+	for _, other := range d.containers {
+		if other == c || !other.inspect.State.Running {
+			continue
+		}
+		for _, p := range published(c.inspect.HostConfig) {
+			for _, q := range ports(other.inspect) {
+				if p.PublicPort == q.PublicPort && p.Type == q.Type && (p.IP == q.IP || p.IP == "0.0.0.0" || q.IP == "0.0.0.0") {
+					return fmt.Errorf("Bind for %s:%d failed: port is already allocated", p.IP, p.PublicPort)
+				}
+			}
+		}
+	}
+	c.inspect.State.Running = true
+	c.inspect.State.Status = containertypes.StateRunning
+	c.inspect.State.StartedAt = time.Now().UTC().Format(time.RFC3339Nano)
+	d.emit(events.Message{
+		Type:   events.ContainerEventType,
+		Action: events.ActionStart,
+		Actor:  events.Actor{ID: c.inspect.ID, Attributes: map[string]string{"name": strings.TrimPrefix(c.inspect.Name, "/")}},
+	})
+	return nil
+}
+
+// ContainerStop stops a container.
+func (d *Daemon) ContainerStop(_ context.Context, containerID string, options containertypes.StopOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerStop", containerID); err != nil {
+		return err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return noSuchContainer(containerID)
+	}
+	d.stops[c.inspect.ID] = options
+	c.inspect.State.Running = false
+	c.inspect.State.Status = containertypes.StateExited
+	return nil
+}
+
+// StopOptions returns the options the container with the given ID was last
+// stopped with, also after it has been removed.
+func (d *Daemon) StopOptions(containerID string) (containertypes.StopOptions, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	options, ok := d.stops[containerID]
+	return options, ok
+}
+
+// ContainerKill sends a signal to a running container, which exits unless
+// [Daemon.Signal] says otherwise. It is recorded as "ContainerKill id
+// signal".
+func (d *Daemon) ContainerKill(_ context.Context, containerID, signal string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerKill", containerID+" "+signal); err != nil {
+		return err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return noSuchContainer(containerID)
+	}
+	if !c.inspect.State.Running {
+		return fmt.Errorf("container %s is not running: %w", containerID, cerrdefs.ErrConflict)
+	}
+	if d.Signal == nil || d.Signal(c.inspect.ID, signal) {
+		c.inspect.State.Running = false
+		c.inspect.State.Status = containertypes.StateExited
+	}
+	return nil
+}
+
+// ContainerWait waits until a container is not running, whatever the
+// condition, or ctx is done.
+func (d *Daemon) ContainerWait(ctx context.Context, containerID string, _ containertypes.WaitCondition) (<-chan containertypes.WaitResponse, <-chan error) {
+	resultC := make(chan containertypes.WaitResponse, 1)
+	errC := make(chan error, 1)
+
+	running := func() (bool, error) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		c := d.findContainer(containerID)
+		if c == nil {
+			return false, noSuchContainer(containerID)
+		}
+		return c.inspect.State.Running, nil
+	}
+
+	d.mu.Lock()
+	err := d.call("ContainerWait", containerID)
+	d.mu.Unlock()
+	if err != nil {
+		errC <- err
+		return resultC, errC
+	}
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			switch ok, err := running(); {
+			case err != nil:
+				errC <- err
+				return
+			case !ok:
+				resultC <- containertypes.WaitResponse{}
+				return
+			}
+			select {
+			case <-ctx.Done():
+				errC <- ctx.Err()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return resultC, errC
+}
+
+// ContainerRemove removes a container; a running one only with Force.
+func (d *Daemon) ContainerRemove(_ context.Context, containerID string, options containertypes.RemoveOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerRemove", containerID); err != nil {
+		return err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return noSuchContainer(containerID)
+	}
+	if c.inspect.State.Running && !options.Force {
+		return fmt.Errorf("cannot remove container %q: container is running: %w", c.inspect.Name, cerrdefs.ErrConflict)
+	}
+	delete(d.containers, c.inspect.ID)
+	return nil
+}
+
+// ContainerRename renames a container.
+func (d *Daemon) ContainerRename(_ context.Context, containerID, newContainerName string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerRename", containerID); err != nil {
+		return err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return noSuchContainer(containerID)
+	}
+	if other := d.findByName(newContainerName); other != nil && other != c {
+		return fmt.Errorf("conflict: the container name %q is already in use: %w", "/"+newContainerName, cerrdefs.ErrConflict)
+	}
+	c.inspect.Name = "/" + newContainerName
+	return nil
+}
+
+// ContainerExecCreate prepares a command to run in a running container.
+func (d *Daemon) ContainerExecCreate(_ context.Context, containerID string, options containertypes.ExecOptions) (containertypes.ExecCreateResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerExecCreate", containerID); err != nil {
+		return containertypes.ExecCreateResponse{}, err
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return containertypes.ExecCreateResponse{}, noSuchContainer(containerID)
+	}
+	if !c.inspect.State.Running {
+		return containertypes.ExecCreateResponse{}, fmt.Errorf("container %s is not running: %w", containerID, cerrdefs.ErrConflict)
+	}
+	id := d.newID()
+	d.execs[id] = &fakeExec{containerID: c.inspect.ID, cmd: slices.Clone(options.Cmd)}
+	return containertypes.ExecCreateResponse{ID: id}, nil
+}
+
+// ContainerExecAttach runs a prepared command through Exec and streams its
+// output, multiplexed as Docker does for non-TTY execs.
+func (d *Daemon) ContainerExecAttach(_ context.Context, execID string, _ containertypes.ExecAttachOptions) (types.HijackedResponse, error) {
+	d.mu.Lock()
+	if err := d.call("ContainerExecAttach", execID); err != nil {
+		d.mu.Unlock()
+		return types.HijackedResponse{}, err
+	}
+	e, ok := d.execs[execID]
+	run := d.Exec
+	d.mu.Unlock()
+	if !ok {
+		return types.HijackedResponse{}, fmt.Errorf("no such exec: %s: %w", execID, cerrdefs.ErrNotFound)
+	}
+
+	exitCode, output := 0, ""
+	if run != nil {
+		exitCode, output = run(e.containerID, e.cmd)
+	}
+	d.mu.Lock()
+	e.exitCode = exitCode
+	d.mu.Unlock()
+
+	return hijacked(output), nil
+}
+
+// ContainerExecInspect reports the exit code of an attached exec.
+func (d *Daemon) ContainerExecInspect(_ context.Context, execID string) (containertypes.ExecInspect, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ContainerExecInspect", execID); err != nil {
+		return containertypes.ExecInspect{}, err
+	}
+	e, ok := d.execs[execID]
+	if !ok {
+		return containertypes.ExecInspect{}, fmt.Errorf("no such exec: %s: %w", execID, cerrdefs.ErrNotFound)
+	}
+	return containertypes.ExecInspect{ExecID: execID, ContainerID: e.containerID, ExitCode: e.exitCode}, nil
+}
+
+// NetworkConnect attaches a container to an existing network.
+func (d *Daemon) NetworkConnect(_ context.Context, networkID, containerID string, config *network.EndpointSettings) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("NetworkConnect", networkID); err != nil {
+		return err
+	}
+	if !d.networks[networkID] {
+		return noSuchNetwork(networkID)
+	}
+	c := d.findContainer(containerID)
+	if c == nil {
+		return noSuchContainer(containerID)
+	}
+	if _, ok := c.inspect.NetworkSettings.Networks[networkID]; ok {
+		return fmt.Errorf("endpoint with name %s already exists in network %s: %w", c.inspect.Name, networkID, cerrdefs.ErrConflict)
+	}
+	c.inspect.NetworkSettings.Networks[networkID] = endpoint(networkID, strings.TrimPrefix(c.inspect.Name, "/"), c.inspect.ID, config)
+	return nil
+}
+
+// NetworkInspect returns a network by name.
+func (d *Daemon) NetworkInspect(_ context.Context, networkID string, _ network.InspectOptions) (network.Inspect, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("NetworkInspect", networkID); err != nil {
+		return network.Inspect{}, err
+	}
+	if !d.networks[networkID] {
+		return network.Inspect{}, noSuchNetwork(networkID)
+	}
+	return network.Inspect{Name: networkID, ID: networkID, Driver: "bridge", Scope: "local"}, nil
+}
+
+// VolumeInspect returns a volume by name.
+func (d *Daemon) VolumeInspect(_ context.Context, volumeID string) (volume.Volume, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("VolumeInspect", volumeID); err != nil {
+		return volume.Volume{}, err
+	}
+	if !d.volumes[volumeID] {
+		return volume.Volume{}, fmt.Errorf("get %s: no such volume: %w", volumeID, cerrdefs.ErrNotFound)
+	}
+	return volume.Volume{Name: volumeID, Driver: "local", Mountpoint: "/var/lib/docker/volumes/" + volumeID + "/_data", Scope: "local"}, nil
+}
+
+// ImagePull fetches the image published for refStr and tags it locally.
+func (d *Daemon) ImagePull(_ context.Context, refStr string, _ image.PullOptions) (io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ImagePull", refStr); err != nil {
+		return nil, err
+	}
+	img, ok := d.registry[normalize(refStr)]
+	if !ok {
+		return nil, fmt.Errorf("pull access denied for %s, repository does not exist or may require authorization: %w", refStr, cerrdefs.ErrNotFound)
+	}
+	img.Ref = refStr
+	d.storeImage(img)
+	d.emit(events.Message{Type: events.ImageEventType, Action: events.ActionPull, Actor: events.Actor{ID: refStr}})
+	return io.NopCloser(strings.NewReader(`{"status":"Status: Downloaded newer image for ` + refStr + `"}` + "\n")), nil
+}
+
+// ImageInspect returns a local image by reference or ID.
+func (d *Daemon) ImageInspect(_ context.Context, imageID string, _ ...client.ImageInspectOption) (image.InspectResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ImageInspect", imageID); err != nil {
+		return image.InspectResponse{}, err
+	}
+	img := d.findImage(imageID)
+	if img == nil {
+		return image.InspectResponse{}, fmt.Errorf("no such image: %s: %w", imageID, cerrdefs.ErrNotFound)
+	}
+	return image.InspectResponse{
+		ID:          img.id,
+		RepoTags:    slices.Clone(img.tags),
+		RepoDigests: slices.Clone(img.repoDigests),
+		Created:     img.created.UTC().Format(time.RFC3339Nano),
+		Config:      &dockerspec.DockerOCIImageConfig{ImageConfig: clone(img.config)},
+	}, nil
+}
+
+// ImageList lists local images. Only the "reference" filter is supported,
+// matching a repository or an exact reference.
+func (d *Daemon) ImageList(_ context.Context, options image.ListOptions) ([]image.Summary, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ImageList", ""); err != nil {
+		return nil, err
+	}
+
+	refs := options.Filters.Get("reference")
+	var list []image.Summary
+	for _, img := range d.images {
+		if len(refs) > 0 && !slices.ContainsFunc(img.tags, func(tag string) bool {
+			repo, _, _ := strings.Cut(tag, ":")
+			return slices.Contains(refs, repo) || slices.Contains(refs, tag)
+		}) {
+			continue
+		}
+		list = append(list, image.Summary{
+			ID:          img.id,
+			RepoTags:    slices.Clone(img.tags),
+			RepoDigests: slices.Clone(img.repoDigests),
+			Created:     img.created.Unix(),
+		})
+	}
+	slices.SortFunc(list, func(a, b image.Summary) int { return strings.Compare(a.ID, b.ID) })
+	return list, nil
+}
+
+// ImageRemove removes a tag, and the image once it has no tags left. An
+// image used by a container is only removed with Force.
+func (d *Daemon) ImageRemove(_ context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ImageRemove", imageID); err != nil {
+		return nil, err
+	}
+	img := d.findImage(imageID)
+	if img == nil {
+		return nil, fmt.Errorf("no such image: %s: %w", imageID, cerrdefs.ErrNotFound)
+	}
+
+	var resp []image.DeleteResponse
+	byTag := img.id != imageID && !strings.HasPrefix(img.id, "sha256:"+imageID) && !strings.HasPrefix(img.id, imageID)
+	if byTag && len(img.tags) > 1 {
+		tag := normalize(imageID)
+		img.tags = slices.DeleteFunc(img.tags, func(t string) bool { return normalize(t) == tag })
+		return []image.DeleteResponse{{Untagged: imageID}}, nil
+	}
+
+	if !options.Force {
+		for _, c := range d.containers {
+			if c.inspect.Image == img.id {
+				return nil, fmt.Errorf("conflict: unable to remove image %s, image is being used by container %s: %w", imageID, c.inspect.ID[:12], cerrdefs.ErrConflict)
+			}
+		}
+	}
+	for _, t := range img.tags {
+		resp = append(resp, image.DeleteResponse{Untagged: t})
+	}
+	delete(d.images, img.id)
+	return append(resp, image.DeleteResponse{Deleted: img.id}), nil
+}
+
+// ImageTag adds target as a tag of the source image, moving it off any
+// image that had it.
+func (d *Daemon) ImageTag(_ context.Context, source, target string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ImageTag", target); err != nil {
+		return err
+	}
+	img := d.findImage(source)
+	if img == nil {
+		return fmt.Errorf("no such image: %s: %w", source, cerrdefs.ErrNotFound)
+	}
+	d.tag(img, target)
+	d.emit(events.Message{
+		Type:   events.ImageEventType,
+		Action: events.ActionTag,
+		Actor:  events.Actor{ID: img.id, Attributes: map[string]string{"name": normalize(target)}},
+	})
+	return nil
+}
+
+// ServiceList lists the Swarm services.
+func (d *Daemon) ServiceList(_ context.Context, _ swarm.ServiceListOptions) ([]swarm.Service, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ServiceList", ""); err != nil {
+		return nil, err
+	}
+	var list []swarm.Service
+	for _, s := range d.services {
+		list = append(list, clone(*s))
+	}
+	slices.SortFunc(list, func(a, b swarm.Service) int { return strings.Compare(a.Spec.Name, b.Spec.Name) })
+	return list, nil
+}
+
+// ServiceInspectWithRaw returns a Swarm service; the raw output is nil.
+func (d *Daemon) ServiceInspectWithRaw(_ context.Context, serviceID string, _ swarm.ServiceInspectOptions) (swarm.Service, []byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ServiceInspectWithRaw", serviceID); err != nil {
+		return swarm.Service{}, nil, err
+	}
+	s := d.findService(serviceID)
+	if s == nil {
+		return swarm.Service{}, nil, fmt.Errorf("service %s not found: %w", serviceID, cerrdefs.ErrNotFound)
+	}
+	return clone(*s), nil, nil
+}
+
+// ServiceUpdate replaces a service's spec. The update finishes at once, in
+// the state set with [Daemon.SetServiceUpdateState].
+func (d *Daemon) ServiceUpdate(_ context.Context, serviceID string, version swarm.Version, service swarm.ServiceSpec, _ swarm.ServiceUpdateOptions) (swarm.ServiceUpdateResponse, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ServiceUpdate", serviceID); err != nil {
+		return swarm.ServiceUpdateResponse{}, err
+	}
+	s := d.findService(serviceID)
+	if s == nil {
+		return swarm.ServiceUpdateResponse{}, fmt.Errorf("service %s not found: %w", serviceID, cerrdefs.ErrNotFound)
+	}
+	if version.Index != s.Version.Index {
+		return swarm.ServiceUpdateResponse{}, fmt.Errorf("update out of sequence: %w", cerrdefs.ErrInvalidArgument)
+	}
+
+	previous := s.Spec
+	s.PreviousSpec = &previous
+	s.Spec = clone(service)
+	if d.updateState == swarm.UpdateStateRollbackCompleted {
+		s.Spec = previous
+	}
+	s.Version.Index++
+	s.UpdateStatus = &swarm.UpdateStatus{State: d.updateState}
+	return swarm.ServiceUpdateResponse{}, nil
+}
+
+// Events streams the messages passed to [Daemon.Emit] until ctx is done.
+// Filters are not supported.
+func (d *Daemon) Events(ctx context.Context, _ events.ListOptions) (<-chan events.Message, <-chan error) {
+	msgs := make(chan events.Message, 16)
+	errs := make(chan error, 1)
+
+	d.mu.Lock()
+	_ = d.call("Events", "")
+	d.subscribers = append(d.subscribers, msgs)
+	d.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		d.mu.Lock()
+		d.subscribers = slices.DeleteFunc(d.subscribers, func(s chan events.Message) bool { return s == msgs })
+		d.mu.Unlock()
+		errs <- ctx.Err()
+	}()
+	return msgs, errs
+}
+
+// Info reports the number of containers and the Swarm state.
+func (d *Daemon) Info(_ context.Context) (system.Info, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("Info", ""); err != nil {
+		return system.Info{}, err
+	}
+	return system.Info{ID: "fakedocker", Name: "fakedocker", Containers: len(d.containers), Images: len(d.images), Swarm: d.swarm}, nil
+}
+
+// ServerVersion returns the version set with [Daemon.SetVersion].
+func (d *Daemon) ServerVersion(_ context.Context) (types.Version, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.call("ServerVersion", ""); err != nil {
+		return types.Version{}, err
+	}
+	return clone(d.version), nil
+}
+
+// Close does nothing.
+func (d *Daemon) Close() error {
+	return nil
+}
+
+// newID returns a new 64-character hex ID. The caller must hold d.mu.
+func (d *Daemon) newID() string {
+	d.serial++
+	sum := sha256.Sum256([]byte(fmt.Sprintf("fakedocker-%d", d.serial)))
+	return hex.EncodeToString(sum[:])
+}
+
+// storeImage adds img locally, or tags the existing image with the same
+// ID, and returns the ID. The caller must hold d.mu.
+func (d *Daemon) storeImage(img Image) string {
+	id := img.ID()
+	stored, ok := d.images[id]
+	if !ok {
+		stored = &fakeImage{id: id, created: img.Created, config: clone(img.Config)}
+		d.images[id] = stored
+	}
+	if img.Digest != "" {
+		rd := repository(normalize(img.Ref)) + "@" + img.Digest
+		if !slices.Contains(stored.repoDigests, rd) {
+			stored.repoDigests = append(stored.repoDigests, rd)
+		}
+	}
+	d.tag(stored, img.Ref)
+	return id
+}
+
+// tag points ref at img, removing it from any other image. Images left
+// without tags stay as dangling images. The caller must hold d.mu.
+func (d *Daemon) tag(img *fakeImage, ref string) {
+	ref = normalize(ref)
+	for _, other := range d.images {
+		other.tags = slices.DeleteFunc(other.tags, func(t string) bool { return t == ref })
+	}
+	img.tags = append(img.tags, ref)
+}
+
+// findImage looks an image up by ID, ID prefix or reference. The caller
+// must hold d.mu.
+func (d *Daemon) findImage(refOrID string) *fakeImage {
+	if img, ok := d.images[refOrID]; ok {
+		return img
+	}
+	ref := normalize(refOrID)
+	for _, img := range d.images {
+		if slices.Contains(img.tags, ref) {
+			return img
+		}
+	}
+	if len(refOrID) >= 12 && isHex(strings.TrimPrefix(refOrID, "sha256:")) {
+		for id, img := range d.images {
+			if strings.HasPrefix(id, refOrID) || strings.HasPrefix(id, "sha256:"+refOrID) {
+				return img
+			}
+		}
+	}
+	return nil
+}
+
+// findContainer looks a container up by ID, ID prefix or name. The caller
+// must hold d.mu.
+func (d *Daemon) findContainer(nameOrID string) *fakeContainer {
+	if c, ok := d.containers[nameOrID]; ok {
+		return c
+	}
+	if c := d.findByName(nameOrID); c != nil {
+		return c
+	}
+	if len(nameOrID) >= 12 {
+		for id, c := range d.containers {
+			if strings.HasPrefix(id, nameOrID) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Daemon) findByName(name string) *fakeContainer {
+	name = "/" + strings.TrimPrefix(name, "/")
+	for _, c := range d.containers {
+		if c.inspect.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *Daemon) findService(nameOrID string) *swarm.Service {
+	if s, ok := d.services[nameOrID]; ok {
+		return s
+	}
+	for _, s := range d.services {
+		if s.Spec.Name == nameOrID {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package fakedocker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+func TestContainerLifecycle(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	d.AddImage(Image{Ref: "nginx"})
+
+	id := d.Run("web", &container.Config{Image: "nginx"}, nil)
+
+	if _, err := d.ContainerCreate(ctx, &container.Config{Image: "nginx"}, nil, nil, nil, "web"); !cerrdefs.IsConflict(err) {
+		t.Errorf("create with a taken name: err = %v, want conflict", err)
+	}
+	if _, err := d.ContainerCreate(ctx, &container.Config{Image: "redis"}, nil, nil, nil, "cache"); !cerrdefs.IsNotFound(err) {
+		t.Errorf("create from a missing image: err = %v, want not found", err)
+	}
+	if err := d.ContainerRemove(ctx, "web", container.RemoveOptions{}); !cerrdefs.IsConflict(err) {
+		t.Errorf("remove running container: err = %v, want conflict", err)
+	}
+
+	list, err := d.ContainerList(ctx, container.ListOptions{})
+	if err != nil || len(list) != 1 || list[0].ID != id || list[0].Names[0] != "/web" {
+		t.Fatalf("ContainerList = %+v, %v", list, err)
+	}
+
+	if err := d.ContainerStop(ctx, id, container.StopOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := d.ContainerList(ctx, container.ListOptions{}); len(list) != 0 {
+		t.Errorf("stopped container listed as running")
+	}
+	if err := d.ContainerRemove(ctx, id, container.RemoveOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.Container("web"); ok {
+		t.Error("removed container still exists")
+	}
+}
+
+func TestInspectReturnsCopy(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	d.AddImage(Image{Ref: "nginx"})
+	d.Run("web", &container.Config{Image: "nginx", Env: []string{"A=1"}}, nil)
+
+	in, err := d.ContainerInspect(ctx, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.Config.Env[0] = "A=2"
+	if c, _ := d.Container("web"); c.Config.Env[0] != "A=1" {
+		t.Errorf("modifying inspect output changed the daemon's state")
+	}
+}
+
+func TestPullMovesTag(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	oldID := d.AddImage(Image{Ref: "nginx:1.25", Digest: "sha256:old"})
+	d.Run("web", &container.Config{Image: "nginx:1.25"}, nil)
+
+	if _, err := d.ImagePull(ctx, "nginx:1.25", image.PullOptions{}); err == nil {
+		t.Fatal("pull of an unpublished image succeeded")
+	}
+
+	d.Publish(Image{Ref: "nginx:1.25", Digest: "sha256:new"})
+	rc, err := d.ImagePull(ctx, "nginx:1.25", image.PullOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, rc)
+	rc.Close()
+
+	in, err := d.ImageInspect(ctx, "nginx:1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.ID == oldID || len(in.RepoDigests) != 1 || in.RepoDigests[0] != "nginx@sha256:new" {
+		t.Errorf("after pull: %+v", in)
+	}
+
+	// The old image lost its tag but is still used by the container.
+	if _, err := d.ImageRemove(ctx, oldID, image.RemoveOptions{}); !cerrdefs.IsConflict(err) {
+		t.Errorf("remove image in use: err = %v, want conflict", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	ctx := context.Background()
+	d := New()
+	d.AddImage(Image{Ref: "nginx"})
+	d.Run("web", &container.Config{Image: "nginx"}, nil)
+	d.Exec = func(_ string, cmd []string) (int, string) { return 3, "ran " + cmd[0] }
+
+	created, err := d.ContainerExecCreate(ctx, "web", container.ExecOptions{Cmd: []string{"true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attach, err := d.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader); err != nil {
+		t.Fatal(err)
+	}
+	attach.Close()
+
+	inspect, err := d.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "ran true" || inspect.ExitCode != 3 {
+		t.Errorf("exec output %q exit %d, want %q exit 3", stdout.String(), inspect.ExitCode, "ran true")
+	}
+}
+
+func TestFail(t *testing.T) {
+	d := New()
+	boom := errors.New("boom")
+	d.Fail("Info", boom)
+
+	if _, err := d.Info(context.Background()); !errors.Is(err, boom) {
+		t.Errorf("first call: err = %v, want boom", err)
+	}
+	if _, err := d.Info(context.Background()); err != nil {
+		t.Errorf("second call: err = %v, want nil", err)
+	}
+}
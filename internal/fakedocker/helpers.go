@@ -0,0 +1,249 @@
+package fakedocker
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types"
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func noSuchContainer(id string) error {
+	return fmt.Errorf("no such container: %s: %w", id, cerrdefs.ErrNotFound)
+}
+
+func noSuchNetwork(name string) error {
+	return fmt.Errorf("network %s not found: %w", name, cerrdefs.ErrNotFound)
+}
+
+// clone deep-copies v through JSON, so callers can modify what the daemon
+// returns without changing its state, as with a real daemon.
+func clone[T any](v T) T {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	var out T
+	if err := json.Unmarshal(data, &out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// normalize adds the implicit "latest" tag to an image reference. IDs and
+// digest references are returned unchanged.
+func normalize(ref string) string {
+	if strings.HasPrefix(ref, "sha256:") || strings.Contains(ref, "@") {
+		return ref
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+// repository strips the tag from a normalized reference.
+func repository(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+	return ref
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// endpoint returns the settings a container gets on network, keeping the
+// aliases, links and static addresses that were asked for and adding the
+// operational data Docker fills in: a MAC address and, on user-defined
+// networks, the DNS names the container answers to.
+func endpoint(name, containerName, containerID string, requested *network.EndpointSettings) *network.EndpointSettings {
+	ep := &network.EndpointSettings{}
+	if requested != nil {
+		ep = requested.Copy()
+	}
+	ep.NetworkID = name
+	if ep.MacAddress == "" {
+		ep.MacAddress = "02:42:" + strings.Join([]string{containerID[0:2], containerID[2:4], containerID[4:6], containerID[6:8]}, ":")
+	}
+	if name != "bridge" && name != "host" && name != "none" {
+		ep.DNSNames = append(append([]string{containerName}, ep.Aliases...), containerID[:12])
+	}
+	return ep
+}
+
+// mountPoints describes the binds and mounts of hostConfig, and the
+// anonymous volumes created for the image volumes in config they leave
+// uncovered, as inspect reports them. Anonymous volumes are named with
+// newName.
+func mountPoints(config *containertypes.Config, hostConfig *containertypes.HostConfig, newName func() string) []containertypes.MountPoint {
+	volume := func(name, target string, rw bool) containertypes.MountPoint {
+		return containertypes.MountPoint{
+			Type:        mount.TypeVolume,
+			Name:        name,
+			Source:      "/var/lib/docker/volumes/" + name + "/_data",
+			Destination: target,
+			Driver:      "local",
+			RW:          rw,
+		}
+	}
+
+	var points []containertypes.MountPoint
+	covered := map[string]bool{}
+	for _, b := range hostConfig.Binds {
+		parts := strings.SplitN(b, ":", 3)
+		if len(parts) < 2 {
+			continue
+		}
+		rw := len(parts) < 3 || !strings.Contains(","+parts[2]+",", ",ro,")
+		p := containertypes.MountPoint{Type: mount.TypeBind, Source: parts[0], Destination: parts[1], RW: rw, Propagation: mount.PropagationRPrivate}
+		if !strings.HasPrefix(parts[0], "/") {
+			p = volume(parts[0], parts[1], rw)
+		}
+		if len(parts) == 3 {
+			p.Mode = parts[2]
+		}
+		points = append(points, p)
+		covered[parts[1]] = true
+	}
+	for _, m := range hostConfig.Mounts {
+		var p containertypes.MountPoint
+		switch m.Type {
+		case mount.TypeVolume:
+			name := m.Source
+			if name == "" {
+				name = newName()
+			}
+			p = volume(name, m.Target, !m.ReadOnly)
+		case mount.TypeBind:
+			p = containertypes.MountPoint{Type: m.Type, Source: m.Source, Destination: m.Target, RW: !m.ReadOnly, Propagation: mount.PropagationRPrivate}
+			if m.BindOptions != nil && m.BindOptions.Propagation != "" {
+				p.Propagation = m.BindOptions.Propagation
+			}
+		default:
+			p = containertypes.MountPoint{Type: m.Type, Destination: m.Target, RW: !m.ReadOnly}
+		}
+		points = append(points, p)
+		covered[m.Target] = true
+	}
+	for target := range hostConfig.Tmpfs {
+		covered[target] = true
+	}
+	for _, target := range slices.Sorted(maps.Keys(config.Volumes)) {
+		if !covered[target] {
+			points = append(points, volume(newName(), target, true))
+		}
+	}
+	return points
+}
+
+// inherit fills in the settings a container takes from its image when
+// they are not set on it, as Docker does at create: environment variables
+// the container does not set, the entrypoint, the command unless the
+// entrypoint was overridden, the user, working directory, exposed ports and
+// volumes.
+func inherit(config *containertypes.Config, img ocispec.ImageConfig) {
+	set := map[string]bool{}
+	for _, kv := range config.Env {
+		k, _, _ := strings.Cut(kv, "=")
+		set[k] = true
+	}
+	var env []string
+	for _, kv := range img.Env {
+		if k, _, _ := strings.Cut(kv, "="); !set[k] {
+			env = append(env, kv)
+		}
+	}
+	config.Env = append(env, config.Env...)
+
+	if len(config.Entrypoint) == 0 {
+		config.Entrypoint = slices.Clone(img.Entrypoint)
+		if len(config.Cmd) == 0 {
+			config.Cmd = slices.Clone(img.Cmd)
+		}
+	}
+	if config.User == "" {
+		config.User = img.User
+	}
+	if config.WorkingDir == "" {
+		config.WorkingDir = img.WorkingDir
+	}
+	for port := range img.ExposedPorts {
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = nat.PortSet{}
+		}
+		config.ExposedPorts[nat.Port(port)] = struct{}{}
+	}
+	for target := range img.Volumes {
+		if config.Volumes == nil {
+			config.Volumes = map[string]struct{}{}
+		}
+		config.Volumes[target] = struct{}{}
+	}
+}
+
+// ports lists the host ports a container publishes while it runs.
+func ports(in containertypes.InspectResponse) []containertypes.Port {
+	if !in.State.Running {
+		return nil
+	}
+	return published(in.HostConfig)
+}
+
+// published lists the host ports hostConfig binds.
+func published(hostConfig *containertypes.HostConfig) []containertypes.Port {
+	var list []containertypes.Port
+	for port, bindings := range hostConfig.PortBindings {
+		for _, b := range bindings {
+			public, err := strconv.ParseUint(b.HostPort, 10, 16)
+			if err != nil {
+				continue
+			}
+			ip := b.HostIP
+			if ip == "" {
+				ip = "0.0.0.0"
+			}
+			list = append(list, containertypes.Port{IP: ip, PrivatePort: uint16(port.Int()), PublicPort: uint16(public), Type: port.Proto()})
+		}
+	}
+	return list
+}
+
+// labels copies a label map so summaries do not share it with inspect
+// output.
+func labels(l map[string]string) map[string]string {
+	if l == nil {
+		return nil
+	}
+	return maps.Clone(l)
+}
+
+// hijacked returns an exec attach response that streams output on stdout
+// and then ends.
+func hijacked(output string) types.HijackedResponse {
+	server, conn := net.Pipe()
+	go func() {
+		defer server.Close()
+		if output != "" {
+			_, _ = stdcopy.NewStdWriter(server, stdcopy.Stdout).Write([]byte(output))
+		}
+	}()
+	return types.NewHijackedResponse(conn, "application/vnd.docker.multiplexed-stream")
+}
@@ -0,0 +1,482 @@
+// Package fakeregistry provides an in-process stand-in for a Docker registry
+// v2 API, so registry lookups can be tested without network access.
+//
+// It serves manifests, multi-arch indexes, blobs, tag lists and the OCI
+// referrers API from memory. It can require anonymous or authenticated
+// Bearer tokens issued by its own token endpoint, or Basic credentials;
+// report Docker Hub style rate limits; and fail chosen requests. Point the
+// registry package at it with registry.SetEndpoints, mapping [Registry.Host]
+// to [Registry.URL].
+package fakeregistry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Media types of the documents the registry serves.
+const (
+	MediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+)
+
+// service is the name the registry gives itself in auth challenges.
+const service = "fakeregistry"
+
+// Image describes a single-platform image to push with [Registry.PushImage].
+type Image struct {
+	// Created is written to the image config.
+	Created time.Time
+	// Labels are written to the image config.
+	Labels map[string]string
+}
+
+// Registry is a running fake registry. Create one with [New] and stop it
+// with [Registry.Close]. All methods are safe for concurrent use.
+type Registry struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	repos     map[string]*repository
+	auth      string // "", "token" or "basic"
+	username  string
+	password  string
+	tokens    map[string]bool
+	limit     int
+	remaining int
+	failures  []failure
+	requests  []string
+}
+
+type repository struct {
+	manifests map[string]manifest
+	tags      map[string]string
+	blobs     map[string][]byte
+}
+
+type manifest struct {
+	mediaType    string
+	artifactType string
+	subject      string
+	body         []byte
+}
+
+type failure struct {
+	path   string
+	status int
+}
+
+// New starts a registry that serves anything it has to anyone.
+func New() *Registry {
+	r := &Registry{repos: map[string]*repository{}, tokens: map[string]bool{}}
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// Close shuts the registry down.
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+// URL returns the base URL of the registry, e.g. "http://127.0.0.1:41231".
+func (r *Registry) URL() string {
+	return r.server.URL
+}
+
+// Host returns the host and port of the registry, to use in image
+// references such as Host()+"/library/nginx:1.25".
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.server.URL, "http://")
+}
+
+// RequireToken makes the registry answer unauthenticated requests with a
+// Bearer challenge pointing at its token endpoint. If username is not
+// empty, the token endpoint requires these Basic credentials; otherwise it
+// hands out anonymous tokens.
+func (r *Registry) RequireToken(username, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auth, r.username, r.password = "token", username, password
+}
+
+// RequireBasic makes the registry require Basic credentials on every
+// request.
+func (r *Registry) RequireBasic(username, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auth, r.username, r.password = "basic", username, password
+}
+
+// SetRateLimit makes manifest requests report a Docker Hub style rate
+// limit of limit pulls with remaining left. Each manifest GET uses up one;
+// once none are left, manifest requests fail with 429 Too Many Requests.
+func (r *Registry) SetRateLimit(limit, remaining int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limit, r.remaining = limit, remaining
+}
+
+// Fail makes the next request whose path contains path, e.g. "/manifests/"
+// or "/token", fail with status.
+func (r *Registry) Fail(path string, status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, failure{path: path, status: status})
+}
+
+// Requests returns the requests served so far, as "METHOD /path".
+func (r *Registry) Requests() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+// PutBlob stores a blob in repo and returns its digest.
+func (r *Registry) PutBlob(repo string, data []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := digest(data)
+	r.repo(repo).blobs[d] = data
+	return d
+}
+
+// PutManifest stores a manifest of the given media type in repo, tagged
+// with tag unless it is empty, and returns its digest. The manifest is
+// listed by the referrers API of its subject, if it has one.
+func (r *Registry) PutManifest(repo, tag, mediaType string, body []byte) string {
+	var doc struct {
+		ArtifactType string `json:"artifactType"`
+		Subject      *struct {
+			Digest string `json:"digest"`
+		} `json:"subject"`
+	}
+	_ = json.Unmarshal(body, &doc)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := digest(body)
+	m := manifest{mediaType: mediaType, artifactType: doc.ArtifactType, body: body}
+	if doc.Subject != nil {
+		m.subject = doc.Subject.Digest
+	}
+	rp := r.repo(repo)
+	rp.manifests[d] = m
+	if tag != "" {
+		rp.tags[tag] = d
+	}
+	return d
+}
+
+// PushImage stores the config and manifest of img in repo, tagged with tag
+// unless it is empty, and returns the manifest digest.
+func (r *Registry) PushImage(repo, tag string, img Image) string {
+	config := map[string]any{
+		"created":      img.Created.UTC(),
+		"os":           "linux",
+		"architecture": "amd64",
+		"config":       map[string]any{"Labels": img.Labels},
+	}
+	configData, err := json.Marshal(config)
+	if err != nil {
+		panic(err)
+	}
+	configDigest := r.PutBlob(repo, configData)
+
+	return r.PutManifest(repo, tag, MediaTypeManifest, mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeManifest,
+		"config":        descriptor(MediaTypeConfig, configDigest, len(configData), nil),
+		"layers":        []any{},
+	}))
+}
+
+// PushIndex pushes one image per platform, keyed like "linux/arm64", and a
+// multi-arch index of them tagged with tag. It returns the index digest.
+func (r *Registry) PushIndex(repo, tag string, images map[string]Image) string {
+	platforms := make([]string, 0, len(images))
+	for p := range images {
+		platforms = append(platforms, p)
+	}
+	slices.Sort(platforms)
+
+	var manifests []any
+	for _, p := range platforms {
+		d := r.PushImage(repo, "", images[p])
+		goos, goarch, _ := strings.Cut(p, "/")
+		r.mu.Lock()
+		size := len(r.repo(repo).manifests[d].body)
+		r.mu.Unlock()
+		manifests = append(manifests, descriptor(MediaTypeManifest, d, size, map[string]string{"os": goos, "architecture": goarch}))
+	}
+
+	return r.PutManifest(repo, tag, MediaTypeIndex, mustJSON(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeIndex,
+		"manifests":     manifests,
+	}))
+}
+
+// repo returns the repository named name, creating it if needed. The caller
+// must hold r.mu.
+func (r *Registry) repo(name string) *repository {
+	rp, ok := r.repos[name]
+	if !ok {
+		rp = &repository{manifests: map[string]manifest{}, tags: map[string]string{}, blobs: map[string][]byte{}}
+		r.repos[name] = rp
+	}
+	return rp
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req.Method+" "+req.URL.Path)
+
+	for i, f := range r.failures {
+		if strings.Contains(req.URL.Path, f.path) {
+			r.failures = slices.Delete(r.failures, i, i+1)
+			writeError(w, f.status, "UNAVAILABLE", "injected failure")
+			return
+		}
+	}
+
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	path, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+
+	name, kind, ref := route(path)
+	if !r.authorized(w, req, name) {
+		return
+	}
+
+	switch kind {
+	case "":
+		if path != "" {
+			writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "unknown endpoint")
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case "manifests":
+		r.serveManifest(w, req, name, ref)
+	case "blobs":
+		r.serveBlob(w, name, ref)
+	case "tags":
+		r.serveTags(w, name)
+	case "referrers":
+		r.serveReferrers(w, req, name, ref)
+	}
+}
+
+// route splits a path below /v2/ into the repository name, the kind of
+// resource and its reference.
+func route(path string) (name, kind, ref string) {
+	if name, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		return name, "tags", ""
+	}
+	for _, k := range []string{"manifests", "blobs", "referrers"} {
+		if i := strings.LastIndex(path, "/"+k+"/"); i >= 0 {
+			return path[:i], k, path[i+len(k)+2:]
+		}
+	}
+	return "", "", ""
+}
+
+// authorized checks the credentials of a request and answers it with a
+// challenge if they are missing or wrong. The caller must hold r.mu.
+func (r *Registry) authorized(w http.ResponseWriter, req *http.Request, name string) bool {
+	switch r.auth {
+	case "basic":
+		if u, p, ok := req.BasicAuth(); ok && u == r.username && p == r.password {
+			return true
+		}
+		w.Header().Set("Www-Authenticate", `Basic realm="`+service+`"`)
+	case "token":
+		if t, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer "); ok && r.tokens[t] {
+			return true
+		}
+		challenge := fmt.Sprintf(`Bearer realm="%s/token",service="%s"`, r.server.URL, service)
+		if name != "" {
+			challenge += fmt.Sprintf(`,scope="repository:%s:pull"`, name)
+		}
+		w.Header().Set("Www-Authenticate", challenge)
+	default:
+		return true
+	}
+	writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	return false
+}
+
+// serveToken issues a Bearer token, checking Basic credentials if the
+// registry has any. The caller must hold r.mu.
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	if r.auth != "token" {
+		http.NotFound(w, req)
+		return
+	}
+	if r.username != "" {
+		if u, p, ok := req.BasicAuth(); !ok || u != r.username || p != r.password {
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "invalid credentials")
+			return
+		}
+	}
+	if req.URL.Query().Get("service") != service {
+		writeError(w, http.StatusBadRequest, "DENIED", "unknown service")
+		return
+	}
+
+	token := "token-" + strconv.Itoa(len(r.tokens)+1)
+	r.tokens[token] = true
+	writeJSON(w, "application/json", map[string]string{"token": token, "access_token": token})
+}
+
+// serveManifest serves a manifest by tag or digest. The caller must hold
+// r.mu.
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	if r.limit > 0 {
+		w.Header().Set("RateLimit-Limit", fmt.Sprintf("%d;w=21600", r.limit))
+		w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d;w=21600", r.remaining))
+		if r.remaining <= 0 {
+			w.Header().Set("Retry-After", "60")
+			writeError(w, http.StatusTooManyRequests, "TOOMANYREQUESTS", "You have reached your pull rate limit.")
+			return
+		}
+	}
+
+	rp, ok := r.repos[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	d := ref
+	if t, ok := rp.tags[ref]; ok {
+		d = t
+	}
+	m, ok := rp.manifests[d]
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	if req.Method == http.MethodGet && r.limit > 0 {
+		r.remaining--
+		w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d;w=21600", r.remaining))
+	}
+	w.Header().Set("Content-Type", m.mediaType)
+	w.Header().Set("Docker-Content-Digest", d)
+	w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(m.body)
+	}
+}
+
+// serveBlob serves a blob by digest. The caller must hold r.mu.
+func (r *Registry) serveBlob(w http.ResponseWriter, name, d string) {
+	rp, ok := r.repos[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	data, ok := rp.blobs[d]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", d)
+	_, _ = w.Write(data)
+}
+
+// serveTags lists the tags of a repository. The caller must hold r.mu.
+func (r *Registry) serveTags(w http.ResponseWriter, name string) {
+	rp, ok := r.repos[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	tags := make([]string, 0, len(rp.tags))
+	for t := range rp.tags {
+		tags = append(tags, t)
+	}
+	slices.Sort(tags)
+	writeJSON(w, "application/json", map[string]any{"name": name, "tags": tags})
+}
+
+// serveReferrers lists the manifests whose subject is d, filtered by the
+// artifactType query parameter. The caller must hold r.mu.
+func (r *Registry) serveReferrers(w http.ResponseWriter, req *http.Request, name, d string) {
+	manifests := []any{}
+	if rp, ok := r.repos[name]; ok {
+		filter := req.URL.Query().Get("artifactType")
+		digests := make([]string, 0, len(rp.manifests))
+		for md := range rp.manifests {
+			digests = append(digests, md)
+		}
+		slices.Sort(digests)
+		for _, md := range digests {
+			m := rp.manifests[md]
+			if m.subject != d || (filter != "" && m.artifactType != filter) {
+				continue
+			}
+			desc := descriptor(m.mediaType, md, len(m.body), nil)
+			desc["artifactType"] = m.artifactType
+			manifests = append(manifests, desc)
+		}
+	}
+	writeJSON(w, MediaTypeIndex, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     MediaTypeIndex,
+		"manifests":     manifests,
+	})
+}
+
+func descriptor(mediaType, d string, size int, platform map[string]string) map[string]any {
+	desc := map[string]any{"mediaType": mediaType, "digest": d, "size": size}
+	if platform != nil {
+		desc["platform"] = platform
+	}
+	return desc
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func mustJSON(v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, v any) {
+	w.Header().Set("Content-Type", contentType)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with a registry v2 error document.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
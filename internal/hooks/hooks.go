@@ -0,0 +1,181 @@
+// Package hooks runs user-defined lifecycle commands around an update:
+// commands inside the container via the Docker exec API, and operator-provided
+// scripts mounted into Isengard's own container.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	containertypes "github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// Phase identifies the point in the update lifecycle at which a hook runs.
+type Phase string
+
+const (
+	// PreCheck runs in the running container before the update check.
+	// A failure skips the container for this cycle.
+	PreCheck Phase = "pre-check"
+	// PreUpdate runs in the old container before it is stopped.
+	// A failure aborts the update and leaves the old container running.
+	PreUpdate Phase = "pre-update"
+	// PostUpdate runs in the new container after it has started.
+	// A failure is logged but cannot undo the update.
+	PostUpdate Phase = "post-update"
+)
+
+const (
+	labelPrefix  = "isengard.hook."
+	labelTimeout = "isengard.hook.timeout"
+)
+
+// Label returns the container label that holds the command for this phase
+// (e.g. "isengard.hook.pre-update").
+func (p Phase) Label() string {
+	return labelPrefix + string(p)
+}
+
+// ExitError reports a hook command that ran to completion with a non-zero
+// exit code.
+type ExitError struct {
+	Phase    Phase
+	ExitCode int
+	Output   string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s hook exited with code %d", e.Phase, e.ExitCode)
+}
+
+// Command returns the hook command configured for phase in labels, or an
+// empty string if the container defines none.
+func Command(labels map[string]string, phase Phase) string {
+	return strings.TrimSpace(labels[phase.Label()])
+}
+
+// Timeout returns the hook timeout for a container: the isengard.hook.timeout
+// label if it holds a valid positive duration, otherwise fallback.
+func Timeout(labels map[string]string, fallback time.Duration) time.Duration {
+	v, ok := labels[labelTimeout]
+	if !ok {
+		return fallback
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil || d <= 0 {
+		slog.Warn("invalid hook timeout label, using default", "value", v, "default", fallback)
+		return fallback
+	}
+	return d
+}
+
+// Run executes the hook for phase inside the given container if its labels
+// define one. It returns nil when no hook is configured or the command exits
+// with code 0. A non-zero exit yields an [*ExitError]; exceeding the timeout
+// or an exec API failure yields a plain error.
+func Run(ctx context.Context, cli docker.API, containerID, name string, labels map[string]string, phase Phase, defaultTimeout time.Duration) error {
+	cmd := Command(labels, phase)
+	if cmd == "" {
+		return nil
+	}
+
+	timeout := Timeout(labels, defaultTimeout)
+	slog.Info("running hook", "container", name, "hook", phase, "command", cmd, "timeout", timeout)
+
+	start := time.Now()
+	exitCode, output, err := execInContainer(ctx, cli, containerID, cmd, timeout)
+	if err != nil {
+		slog.Warn("hook failed", "container", name, "hook", phase, "error", err)
+		return fmt.Errorf("%s hook: %w", phase, err)
+	}
+
+	if exitCode != 0 {
+		slog.Warn("hook exited with non-zero code",
+			"container", name,
+			"hook", phase,
+			"exit_code", exitCode,
+			"output", output,
+		)
+		return &ExitError{Phase: phase, ExitCode: exitCode, Output: output}
+	}
+
+	slog.Info("hook finished",
+		"container", name,
+		"hook", phase,
+		"duration", time.Since(start).Round(time.Millisecond),
+		"output", output,
+	)
+	return nil
+}
+
+// execInContainer runs cmd through /bin/sh in the container and waits for it to finish,
+// returning its exit code and combined stdout/stderr.
+func execInContainer(ctx context.Context, cli docker.API, containerID, cmd string, timeout time.Duration) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	created, err := cli.ContainerExecCreate(ctx, containerID, containertypes.ExecOptions{
+		Cmd:          []string{"/bin/sh", "-c", cmd},
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("creating exec: %w", err)
+	}
+
+	attach, err := cli.ContainerExecAttach(ctx, created.ID, containertypes.ExecAttachOptions{})
+	if err != nil {
+		return 0, "", fmt.Errorf("attaching to exec: %w", err)
+	}
+	defer attach.Close()
+
+	// The hijacked connection does not observe ctx, so copy in the background
+	// and give up when the deadline passes.
+	var stdout, stderr bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return 0, "", fmt.Errorf("reading exec output: %w", err)
+		}
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, "", fmt.Errorf("timed out after %s", timeout)
+		}
+		return 0, "", ctx.Err()
+	}
+
+	inspect, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return 0, "", fmt.Errorf("inspecting exec: %w", err)
+	}
+
+	return inspect.ExitCode, combineOutput(stdout.String(), stderr.String()), nil
+}
+
+// combineOutput joins trimmed stdout and stderr for logging.
+func combineOutput(stdout, stderr string) string {
+	stdout = strings.TrimSpace(stdout)
+	stderr = strings.TrimSpace(stderr)
+	switch {
+	case stdout == "":
+		return stderr
+	case stderr == "":
+		return stdout
+	default:
+		return stdout + "\n" + stderr
+	}
+}
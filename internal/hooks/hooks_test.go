@@ -0,0 +1,93 @@
+package hooks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPhaseLabel(t *testing.T) {
+	tests := []struct {
+		phase    Phase
+		expected string
+	}{
+		{PreCheck, "isengard.hook.pre-check"},
+		{PreUpdate, "isengard.hook.pre-update"},
+		{PostUpdate, "isengard.hook.post-update"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.phase), func(t *testing.T) {
+			if got := tt.phase.Label(); got != tt.expected {
+				t.Errorf("Label(): got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCommand(t *testing.T) {
+	labels := map[string]string{
+		"isengard.hook.pre-update":  "  pg_dump -f /backup/db.sql  ",
+		"isengard.hook.post-update": "",
+	}
+
+	if got := Command(labels, PreUpdate); got != "pg_dump -f /backup/db.sql" {
+		t.Errorf("expected trimmed pre-update command, got %q", got)
+	}
+	if got := Command(labels, PostUpdate); got != "" {
+		t.Errorf("expected empty post-update command, got %q", got)
+	}
+	if got := Command(labels, PreCheck); got != "" {
+		t.Errorf("expected no pre-check command, got %q", got)
+	}
+	if got := Command(nil, PreCheck); got != "" {
+		t.Errorf("expected no command for nil labels, got %q", got)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	fallback := time.Minute
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected time.Duration
+	}{
+		{"no label", map[string]string{}, fallback},
+		{"valid label", map[string]string{"isengard.hook.timeout": "5m"}, 5 * time.Minute},
+		{"invalid label", map[string]string{"isengard.hook.timeout": "soon"}, fallback},
+		{"negative label", map[string]string{"isengard.hook.timeout": "-10s"}, fallback},
+		{"zero label", map[string]string{"isengard.hook.timeout": "0s"}, fallback},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Timeout(tt.labels, fallback); got != tt.expected {
+				t.Errorf("Timeout(): got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCombineOutput(t *testing.T) {
+	tests := []struct {
+		stdout, stderr, expected string
+	}{
+		{"", "", ""},
+		{"ok\n", "", "ok"},
+		{"", "warning\n", "warning"},
+		{"dumped\n", "notice\n", "dumped\nnotice"},
+	}
+
+	for _, tt := range tests {
+		if got := combineOutput(tt.stdout, tt.stderr); got != tt.expected {
+			t.Errorf("combineOutput(%q, %q): got %q, want %q", tt.stdout, tt.stderr, got, tt.expected)
+		}
+	}
+}
+
+func TestExitError(t *testing.T) {
+	err := &ExitError{Phase: PreUpdate, ExitCode: 3}
+	if got := err.Error(); got != "pre-update hook exited with code 3" {
+		t.Errorf("unexpected error message: %q", got)
+	}
+}
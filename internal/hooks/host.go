@@ -0,0 +1,281 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event identifies a point in the update cycle at which host-side scripts run.
+type Event string
+
+const (
+	// EventCycleStart runs before any container is checked.
+	// A failing script skips the whole cycle.
+	EventCycleStart Event = "cycle-start"
+	// EventPreUpdate runs before each container is recreated.
+	// A failing script skips that container.
+	EventPreUpdate Event = "pre-update"
+	// EventPostUpdate runs after each container update attempt, successful or not.
+	EventPostUpdate Event = "post-update"
+	// EventCycleEnd runs after all containers have been processed.
+	EventCycleEnd Event = "cycle-end"
+)
+
+// ExitSkip is the exit code (EX_TEMPFAIL) a script uses to request that the
+// cycle or container be skipped without it being treated as a failure.
+const ExitSkip = 75
+
+// FailurePolicy controls how a script exiting with a code other than 0 or
+// [ExitSkip] is handled.
+type FailurePolicy string
+
+const (
+	// FailAbort skips the cycle or container, like [ExitSkip] but logged as an error.
+	FailAbort FailurePolicy = "abort"
+	// FailContinue logs the failure and carries on.
+	FailContinue FailurePolicy = "continue"
+)
+
+// ErrSkipped is returned (wrapped) by [Scripts.Run] when a script asked for
+// the cycle or container to be skipped, or failed under [FailAbort].
+var ErrSkipped = errors.New("skipped by host hook")
+
+// Payload is the event data passed to scripts as JSON on stdin.
+type Payload struct {
+	Event     Event          `json:"event"`
+	Time      time.Time      `json:"time"`
+	Host      string         `json:"host,omitempty"` // Docker host, when several are managed
+	Container *ContainerData `json:"container,omitempty"`
+	Cycle     *CycleData     `json:"cycle,omitempty"`
+}
+
+// ContainerData describes the container a pre-update or post-update event is about.
+type ContainerData struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Image   string `json:"image"`
+	ImageID string `json:"image_id"`
+	// NewID is the replacement container ID (post-update only, empty on failure).
+	NewID string `json:"new_id,omitempty"`
+	// Error describes why the update failed (post-update only).
+	Error string `json:"error,omitempty"`
+	// Restored is set when a failed update put the original container back
+	// in place and running (post-update only).
+	Restored bool `json:"restored,omitempty"`
+}
+
+// CycleData summarises a finished cycle (cycle-end only).
+type CycleData struct {
+	Checked int `json:"checked"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+}
+
+// Scripts runs operator-provided executables from a hooks directory. For an
+// event such as "pre-update" it runs the file "<dir>/pre-update" followed by
+// every file in "<dir>/pre-update.d/" in lexical order. Non-executable files
+// are ignored. A nil *Scripts runs nothing.
+type Scripts struct {
+	dir       string
+	timeout   time.Duration
+	onFailure FailurePolicy
+}
+
+// NewScripts returns a runner for the scripts in dir, or nil if dir is empty.
+func NewScripts(dir string, timeout time.Duration, onFailure FailurePolicy) *Scripts {
+	if dir == "" {
+		return nil
+	}
+	return &Scripts{dir: dir, timeout: timeout, onFailure: onFailure}
+}
+
+// Run executes all scripts registered for p.Event, stopping at the first one
+// that does not exit 0. It returns nil if the caller should proceed and an
+// error wrapping [ErrSkipped] if the cycle or container should be skipped.
+// Failures of post-update and cycle-end scripts are logged only.
+func (s *Scripts) Run(ctx context.Context, p Payload) error {
+	if s == nil {
+		return nil
+	}
+
+	paths := s.scriptsFor(p.Event)
+	if len(paths) == 0 {
+		return nil
+	}
+
+	if p.Time.IsZero() {
+		p.Time = time.Now()
+	}
+	input, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("encoding hook payload: %w", err)
+	}
+	env := payloadEnv(p)
+
+	for _, path := range paths {
+		exitCode, output, err := s.runScript(ctx, path, input, env)
+		switch {
+		case err != nil:
+			slog.Warn("host hook failed", "hook", p.Event, "script", path, "error", err)
+		case exitCode == 0:
+			slog.Info("host hook finished", "hook", p.Event, "script", path, "output", output)
+			continue
+		case exitCode == ExitSkip:
+			slog.Info("host hook requested skip", "hook", p.Event, "script", path, "output", output)
+			if canSkip(p.Event) {
+				return fmt.Errorf("%s: %w", filepath.Base(path), ErrSkipped)
+			}
+			continue
+		default:
+			err = fmt.Errorf("exited with code %d", exitCode)
+			slog.Warn("host hook exited with non-zero code",
+				"hook", p.Event,
+				"script", path,
+				"exit_code", exitCode,
+				"output", output,
+			)
+		}
+
+		if s.onFailure == FailAbort && canSkip(p.Event) {
+			return fmt.Errorf("%s: %w: %w", filepath.Base(path), ErrSkipped, err)
+		}
+	}
+
+	return nil
+}
+
+// canSkip reports whether the outcome of an event's scripts can still
+// prevent the work that follows it.
+func canSkip(e Event) bool {
+	return e == EventCycleStart || e == EventPreUpdate
+}
+
+// scriptsFor lists the executables registered for an event.
+func (s *Scripts) scriptsFor(e Event) []string {
+	var paths []string
+
+	single := filepath.Join(s.dir, string(e))
+	if isExecutable(single) {
+		paths = append(paths, single)
+	}
+
+	dir := filepath.Join(s.dir, string(e)+".d")
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return paths
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := filepath.Join(dir, name)
+		if isExecutable(p) {
+			paths = append(paths, p)
+		} else {
+			slog.Debug("ignoring non-executable hook file", "path", p)
+		}
+	}
+	return paths
+}
+
+// isExecutable reports whether path is a regular file with any execute bit set.
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return fi.Mode().IsRegular() && fi.Mode().Perm()&0o111 != 0
+}
+
+// runScript executes a single script with the payload on stdin. A non-zero
+// exit is reported through the exit code, not the error.
+func (s *Scripts) runScript(ctx context.Context, path string, input []byte, env []string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = s.dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = scriptEnv(env)
+	cmd.WaitDelay = 5 * time.Second
+
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, output, fmt.Errorf("timed out after %s", s.timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), output, nil
+	}
+	if err != nil {
+		return 0, output, err
+	}
+	return 0, output, nil
+}
+
+// scriptEnv returns the environment scripts run with: PATH and HOME from
+// Isengard's own environment and the payload variables. The rest of its
+// environment is not passed on, since it may hold the API token, webhook
+// secret and registry credentials.
+func scriptEnv(payload []string) []string {
+	var env []string
+	for _, k := range []string{"PATH", "HOME"} {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return append(env, payload...)
+}
+
+// payloadEnv exposes the most useful payload fields as ISENGARD_* variables
+// for scripts that do not want to parse JSON.
+func payloadEnv(p Payload) []string {
+	env := []string{"ISENGARD_EVENT=" + string(p.Event)}
+	if p.Host != "" {
+		env = append(env, "ISENGARD_HOST="+p.Host)
+	}
+
+	if c := p.Container; c != nil {
+		env = append(env,
+			"ISENGARD_CONTAINER_ID="+c.ID,
+			"ISENGARD_CONTAINER_NAME="+c.Name,
+			"ISENGARD_IMAGE="+c.Image,
+			"ISENGARD_IMAGE_ID="+c.ImageID,
+		)
+		if c.NewID != "" {
+			env = append(env, "ISENGARD_NEW_CONTAINER_ID="+c.NewID)
+		}
+		if c.Error != "" {
+			env = append(env, "ISENGARD_ERROR="+c.Error)
+		}
+		if c.Restored {
+			env = append(env, "ISENGARD_RESTORED=true")
+		}
+	}
+
+	if c := p.Cycle; c != nil {
+		env = append(env,
+			"ISENGARD_CHECKED="+strconv.Itoa(c.Checked),
+			"ISENGARD_UPDATED="+strconv.Itoa(c.Updated),
+			"ISENGARD_FAILED="+strconv.Itoa(c.Failed),
+		)
+	}
+
+	return env
+}
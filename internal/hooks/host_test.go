@@ -0,0 +1,172 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeScript creates an executable shell script at dir/name.
+func writeScript(t *testing.T, dir, name, body string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScriptsNil(t *testing.T) {
+	s := NewScripts("", time.Second, FailAbort)
+	if s != nil {
+		t.Fatal("expected nil Scripts for empty dir")
+	}
+	if err := s.Run(context.Background(), Payload{Event: EventCycleStart}); err != nil {
+		t.Errorf("nil Scripts should run nothing, got %v", err)
+	}
+}
+
+func TestScriptsExitCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    Event
+		exitCode string
+		policy   FailurePolicy
+		wantSkip bool
+	}{
+		{"success", EventPreUpdate, "0", FailAbort, false},
+		{"skip code on pre-update", EventPreUpdate, "75", FailAbort, true},
+		{"skip code on cycle-start", EventCycleStart, "75", FailContinue, true},
+		{"skip code on post-update is ignored", EventPostUpdate, "75", FailAbort, false},
+		{"failure with abort policy", EventPreUpdate, "1", FailAbort, true},
+		{"failure with continue policy", EventPreUpdate, "1", FailContinue, false},
+		{"failure on cycle-end is ignored", EventCycleEnd, "2", FailAbort, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeScript(t, dir, string(tt.event), "exit "+tt.exitCode)
+
+			s := NewScripts(dir, 5*time.Second, tt.policy)
+			err := s.Run(context.Background(), Payload{Event: tt.event})
+
+			if tt.wantSkip && !errors.Is(err, ErrSkipped) {
+				t.Errorf("expected ErrSkipped, got %v", err)
+			}
+			if !tt.wantSkip && err != nil {
+				t.Errorf("expected nil, got %v", err)
+			}
+		})
+	}
+}
+
+func TestScriptsPayload(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "out")
+	writeScript(t, dir, "pre-update", `cat > "`+out+`.json"; echo "$ISENGARD_EVENT $ISENGARD_CONTAINER_NAME" > "`+out+`.env"`)
+
+	s := NewScripts(dir, 5*time.Second, FailAbort)
+	err := s.Run(context.Background(), Payload{
+		Event:     EventPreUpdate,
+		Container: &ContainerData{ID: "abc", Name: "web", Image: "nginx:latest"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(out + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Payload
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("stdin was not valid JSON: %v", err)
+	}
+	if got.Event != EventPreUpdate || got.Container == nil || got.Container.Name != "web" {
+		t.Errorf("unexpected payload: %+v", got)
+	}
+	if got.Time.IsZero() {
+		t.Error("expected payload time to be set")
+	}
+
+	env, err := os.ReadFile(out + ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(env)) != "pre-update web" {
+		t.Errorf("unexpected env output: %q", env)
+	}
+}
+
+func TestScriptsEnvironment(t *testing.T) {
+	t.Setenv("ISENGARD_API_TOKEN", "s3cret")
+	t.Setenv("ISENGARD_REGISTRY_AUTH", "ghcr.io=me:ghp_abc")
+
+	dir := t.TempDir()
+	out := filepath.Join(t.TempDir(), "env")
+	writeScript(t, dir, "cycle-start", `env > "`+out+`"`)
+
+	s := NewScripts(dir, 5*time.Second, FailAbort)
+	if err := s.Run(context.Background(), Payload{Event: EventCycleStart}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := string(data)
+	for _, secret := range []string{"ISENGARD_API_TOKEN", "ISENGARD_REGISTRY_AUTH", "s3cret", "ghp_abc"} {
+		if strings.Contains(env, secret) {
+			t.Errorf("script environment contains %s:\n%s", secret, env)
+		}
+	}
+	for _, want := range []string{"ISENGARD_EVENT=cycle-start", "PATH=" + os.Getenv("PATH")} {
+		if !strings.Contains(env, want) {
+			t.Errorf("script environment lacks %s:\n%s", want, env)
+		}
+	}
+}
+
+func TestScriptsOrderAndDiscovery(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(t.TempDir(), "order")
+	writeScript(t, dir, "cycle-end", `echo main >> "`+log+`"`)
+	writeScript(t, dir, "cycle-end.d/20-second", `echo second >> "`+log+`"`)
+	writeScript(t, dir, "cycle-end.d/10-first", `echo first >> "`+log+`"`)
+	if err := os.WriteFile(filepath.Join(dir, "cycle-end.d", "README"), []byte("docs"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewScripts(dir, 5*time.Second, FailAbort)
+	if err := s.Run(context.Background(), Payload{Event: EventCycleEnd, Cycle: &CycleData{Updated: 1}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Fields(string(data)); strings.Join(got, ",") != "main,first,second" {
+		t.Errorf("unexpected run order: %v", got)
+	}
+}
+
+func TestScriptsTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "pre-update", "exec sleep 5")
+
+	s := NewScripts(dir, 100*time.Millisecond, FailAbort)
+	err := s.Run(context.Background(), Payload{Event: EventPreUpdate})
+	if !errors.Is(err, ErrSkipped) {
+		t.Errorf("expected timeout to skip under abort policy, got %v", err)
+	}
+}
@@ -0,0 +1,686 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expressions are a small, side-effect-free language over the variables
+// described in [Input]:
+//
+//	image.registry == "ghcr.io" && image.repository matches "our-org/*"
+//	semver_major(new.version) > semver_major(old.version)
+//	!has(new.labels["org.opencontainers.image.revision"])
+//	now.weekday in ["Sat", "Sun"]
+//
+// Supported operators, loosest binding first: ||, &&, !, then the
+// comparisons ==, !=, <, <=, >, >=, in and matches (a path.Match glob).
+// Values are strings, numbers, booleans, lists, maps and null (a missing
+// field or label).
+
+// node is a compiled expression.
+type node interface {
+	eval(env map[string]any) (any, error)
+}
+
+// roots are the top-level variables an expression may reference.
+var roots = map[string]bool{"container": true, "image": true, "old": true, "new": true, "now": true}
+
+// compile parses an expression and rejects references to unknown variables
+// or functions.
+func compile(src string) (node, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+	}
+	return n, nil
+}
+
+// --- lexer ---
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+}
+
+// operators lists punctuation tokens, longest first so "==" wins over "=".
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ",", "."}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	i := 0
+	for i < len(src) {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			toks = append(toks, token{tokString, sb.String(), i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '-' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					toks = append(toks, token{tokOp, op, i})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+		}
+	}
+	return append(toks, token{tokEOF, "end of expression", len(src)}), nil
+}
+
+// --- parser ---
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the operator or keyword text.
+func (p *parser) accept(text string) bool {
+	t := p.peek()
+	if (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return fmt.Errorf("expected %q, got %q at offset %d", text, t.text, t.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.accept("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisons = []string{"==", "!=", "<=", ">=", "<", ">", "in", "matches"}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range comparisons {
+		if p.accept(op) {
+			right, err := p.parsePostfix()
+			if err != nil {
+				return nil, err
+			}
+			return compareNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parsePostfix() (node, error) {
+	x, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, fmt.Errorf("expected field name at offset %d", t.pos)
+			}
+			x = fieldNode{x: x, name: t.text}
+		case p.accept("["):
+			idx, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = indexNode{x: x, index: idx}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString:
+		return literal{v: t.text}, nil
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", t.text, t.pos)
+		}
+		return literal{v: f}, nil
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return literal{v: true}, nil
+		case "false":
+			return literal{v: false}, nil
+		case "null":
+			return literal{v: nil}, nil
+		}
+		if p.accept("(") {
+			return p.parseCall(t)
+		}
+		if !roots[t.text] {
+			return nil, fmt.Errorf("unknown variable %q at offset %d", t.text, t.pos)
+		}
+		return varNode{name: t.text}, nil
+	case tokOp:
+		switch t.text {
+		case "(":
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			var items []node
+			for !p.accept("]") {
+				if len(items) > 0 {
+					if err := p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				item, err := p.parseOr()
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, item)
+			}
+			return listNode{items: items}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.pos)
+	}
+	var args []node
+	for !p.accept(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("%s() takes %d argument(s), got %d", name.text, fn.arity, len(args))
+	}
+	return callNode{name: name.text, fn: fn.call, args: args}, nil
+}
+
+// --- evaluation ---
+
+type literal struct{ v any }
+
+func (n literal) eval(map[string]any) (any, error) { return n.v, nil }
+
+type varNode struct{ name string }
+
+func (n varNode) eval(env map[string]any) (any, error) { return env[n.name], nil }
+
+type fieldNode struct {
+	x    node
+	name string
+}
+
+func (n fieldNode) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("cannot read field %q of %s", n.name, typeName(v))
+	}
+	return m[n.name], nil
+}
+
+type indexNode struct{ x, index node }
+
+func (n indexNode) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := n.index.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch c := v.(type) {
+	case map[string]any:
+		key, ok := idx.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index must be a string, got %s", typeName(idx))
+		}
+		return c[key], nil
+	case []any:
+		f, ok := idx.(float64)
+		if !ok || f < 0 || int(f) >= len(c) {
+			return nil, nil
+		}
+		return c[int(f)], nil
+	case nil:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(v))
+}
+
+type listNode struct{ items []node }
+
+func (n listNode) eval(env map[string]any) (any, error) {
+	list := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+type notNode struct{ x node }
+
+func (n notNode) eval(env map[string]any) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! needs a boolean, got %s", typeName(v))
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n logicalNode) eval(env map[string]any) (any, error) {
+	l, err := evalBool(n.left, env, n.op)
+	if err != nil {
+		return nil, err
+	}
+	// Short-circuit so guards like has(x) && x > 1 work.
+	if (n.op == "&&" && !l) || (n.op == "||" && l) {
+		return l, nil
+	}
+	return evalBool(n.right, env, n.op)
+}
+
+func evalBool(n node, env map[string]any, op string) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s needs booleans, got %s", op, typeName(v))
+	}
+	return b, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(env map[string]any) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch c := r.(type) {
+		case []any:
+			for _, item := range c {
+				if equal(l, item) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]any:
+			key, ok := l.(string)
+			_, found := c[key]
+			return ok && found, nil
+		case string:
+			s, ok := l.(string)
+			return ok && strings.Contains(c, s), nil
+		case nil:
+			return false, nil
+		}
+		return nil, fmt.Errorf("in needs a list, map or string, got %s", typeName(r))
+	case "matches":
+		s, ok1 := l.(string)
+		pattern, ok2 := r.(string)
+		if !ok1 || !ok2 {
+			if l == nil {
+				return false, nil
+			}
+			return nil, fmt.Errorf("matches needs strings, got %s and %s", typeName(l), typeName(r))
+		}
+		matched, err := path.Match(pattern, s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		return matched, nil
+	}
+
+	// Ordering comparisons: both numbers or both strings. A null operand
+	// (e.g. an unparseable version) never compares as ordered.
+	if l == nil || r == nil {
+		return false, nil
+	}
+	if lf, ok := l.(float64); ok {
+		if rf, ok := r.(float64); ok {
+			return order(n.op, compareFloat(lf, rf)), nil
+		}
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return order(n.op, strings.Compare(ls, rs)), nil
+		}
+	}
+	return nil, fmt.Errorf("%s cannot compare %s and %s", n.op, typeName(l), typeName(r))
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func order(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default: // ">="
+		return cmp >= 0
+	}
+}
+
+func equal(a, b any) bool {
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case string, bool, float64:
+		return a == b
+	case []any:
+		bv, ok := b.([]any)
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// --- functions ---
+
+type callNode struct {
+	name string
+	fn   func(args []any) (any, error)
+	args []node
+}
+
+func (n callNode) eval(env map[string]any) (any, error) {
+	args := make([]any, len(n.args))
+	for i, a := range n.args {
+		v, err := a.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return v, nil
+}
+
+type function struct {
+	arity int
+	call  func(args []any) (any, error)
+}
+
+var functions = map[string]function{
+	// has reports whether a value is present and non-empty.
+	"has": {1, func(args []any) (any, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return false, nil
+		case string:
+			return v != "", nil
+		}
+		return true, nil
+	}},
+	"lower": {1, stringFunc(strings.ToLower)},
+	"upper": {1, stringFunc(strings.ToUpper)},
+	"starts_with": {2, func(args []any) (any, error) {
+		s, prefix, err := twoStrings(args)
+		return err == nil && strings.HasPrefix(s, prefix), err
+	}},
+	"ends_with": {2, func(args []any) (any, error) {
+		s, suffix, err := twoStrings(args)
+		return err == nil && strings.HasSuffix(s, suffix), err
+	}},
+	"semver_major": {1, semverPart(0)},
+	"semver_minor": {1, semverPart(1)},
+	"semver_patch": {1, semverPart(2)},
+}
+
+func stringFunc(f func(string) string) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("needs a string, got %s", typeName(args[0]))
+		}
+		return f(s), nil
+	}
+}
+
+func twoStrings(args []any) (string, string, error) {
+	if args[0] == nil {
+		return "", "", nil
+	}
+	a, ok1 := args[0].(string)
+	b, ok2 := args[1].(string)
+	if !ok1 || !ok2 {
+		return "", "", errors.New("needs strings")
+	}
+	return a, b, nil
+}
+
+// semverPart extracts one numeric component of a version string such as
+// "v16.4.1" or "1.25-alpine". It yields null if the version does not start
+// with a number, so comparisons against it are false.
+func semverPart(i int) func([]any) (any, error) {
+	return func(args []any) (any, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, nil
+		}
+		s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+		if end := strings.IndexAny(s, "-+"); end >= 0 {
+			s = s[:end]
+		}
+		parts := strings.Split(s, ".")
+		if i >= len(parts) {
+			if len(parts) > 0 && parts[0] != "" {
+				if _, err := strconv.Atoi(parts[0]); err == nil {
+					return float64(0), nil
+				}
+			}
+			return nil, nil
+		}
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return nil, nil
+		}
+		return float64(n), nil
+	}
+}
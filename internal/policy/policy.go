@@ -0,0 +1,271 @@
+// Package policy decides whether a pending update may be applied, based on
+// declarative rules loaded from a YAML file:
+//
+//	default: allow
+//	rules:
+//	  - name: postgres-major
+//	    when: image.repository == "library/postgres" && semver_major(new.version) != semver_major(old.version)
+//	    action: deny
+//	    reason: major PostgreSQL upgrades need a dump and restore
+//
+// Rules are evaluated in order and the first rule whose expression is true
+// decides. If no rule matches, the default action applies.
+package policy
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+// labelVersion is the OCI annotation read as an image's version.
+const labelVersion = "org.opencontainers.image.version"
+
+// Action is the outcome of a rule.
+type Action string
+
+const (
+	Allow Action = "allow"
+	Deny  Action = "deny"
+)
+
+// Rule is a named condition and the action taken when it holds.
+type Rule struct {
+	Name   string `yaml:"name"`
+	When   string `yaml:"when"`
+	Action Action `yaml:"action"`
+	Reason string `yaml:"reason"`
+
+	expr node
+}
+
+// Policy is an ordered list of rules. A nil *Policy allows every update.
+type Policy struct {
+	Default Action `yaml:"default"`
+	Rules   []Rule `yaml:"rules"`
+}
+
+// Load reads and compiles a policy file. It returns nil if path is empty.
+func Load(path string) (*Policy, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy: %w", err)
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Parse decodes and compiles a policy document, reporting every invalid rule.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(strings.NewReader(string(data)))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("decoding: %w", err)
+	}
+
+	var problems []string
+	switch p.Default {
+	case "":
+		p.Default = Allow
+	case Allow, Deny:
+	default:
+		problems = append(problems, fmt.Sprintf("default: unknown action %q", p.Default))
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if r.Action != Allow && r.Action != Deny {
+			problems = append(problems, fmt.Sprintf("%s: action must be allow or deny, got %q", r.Name, r.Action))
+		}
+		if strings.TrimSpace(r.When) == "" {
+			problems = append(problems, fmt.Sprintf("%s: when is required", r.Name))
+			continue
+		}
+		expr, err := compile(r.When)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", r.Name, err))
+			continue
+		}
+		r.expr = expr
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid rules: %s", strings.Join(problems, "; "))
+	}
+	return &p, nil
+}
+
+// Input is what rule expressions can see, exposed as the variables
+// container, image, old, new and now.
+type Input struct {
+	Container Container
+	Image     registry.ImageRef
+	Old       Image
+	New       Image
+	Now       time.Time
+}
+
+// Container describes the container being updated.
+type Container struct {
+	Name   string
+	Image  string
+	Labels map[string]string
+}
+
+// Image describes the current (old) or candidate (new) image.
+type Image struct {
+	ID      string
+	Digest  string
+	Created time.Time
+	Labels  map[string]string
+}
+
+// Step is the result of evaluating one rule, kept for explanations.
+type Step struct {
+	Rule    string
+	Matched bool
+	Err     error
+}
+
+// Decision is the outcome of evaluating a policy.
+type Decision struct {
+	Allowed bool
+	// Rule is the name of the deciding rule, empty if the default applied.
+	Rule   string
+	Reason string
+	// When is the deciding rule's expression.
+	When  string
+	Steps []Step
+}
+
+// Explain returns a one-line human-readable reason for the decision.
+func (d Decision) Explain() string {
+	verdict := "allowed"
+	if !d.Allowed {
+		verdict = "denied"
+	}
+	if d.Rule == "" {
+		return verdict + " by default (no rule matched)"
+	}
+	s := fmt.Sprintf("%s by rule %q (%s)", verdict, d.Rule, d.When)
+	if d.Reason != "" {
+		s += ": " + d.Reason
+	}
+	return s
+}
+
+// Evaluate applies the policy to in. A rule that fails to evaluate denies
+// the update, so a broken rule never lets an update through by accident.
+func (p *Policy) Evaluate(in Input) Decision {
+	if p == nil {
+		return Decision{Allowed: true}
+	}
+
+	env := in.env()
+	var steps []Step
+	for _, r := range p.Rules {
+		v, err := r.expr.eval(env)
+		if err == nil {
+			if _, ok := v.(bool); !ok {
+				err = fmt.Errorf("expression yields %s, not a boolean", typeName(v))
+			}
+		}
+		if err != nil {
+			steps = append(steps, Step{Rule: r.Name, Err: err})
+			return Decision{
+				Rule:   r.Name,
+				Reason: fmt.Sprintf("evaluation failed: %v", err),
+				When:   r.When,
+				Steps:  steps,
+			}
+		}
+
+		matched := v.(bool)
+		steps = append(steps, Step{Rule: r.Name, Matched: matched})
+		if matched {
+			return Decision{
+				Allowed: r.Action == Allow,
+				Rule:    r.Name,
+				Reason:  r.Reason,
+				When:    r.When,
+				Steps:   steps,
+			}
+		}
+	}
+	return Decision{Allowed: p.Default != Deny, Steps: steps}
+}
+
+// env builds the variables visible to expressions.
+func (in Input) env() map[string]any {
+	now := in.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	return map[string]any{
+		"container": map[string]any{
+			"name":   in.Container.Name,
+			"image":  in.Container.Image,
+			"labels": stringMap(in.Container.Labels),
+		},
+		"image": map[string]any{
+			"registry":   in.Image.Registry,
+			"repository": in.Image.Repository,
+			"tag":        in.Image.Tag,
+		},
+		"old": in.Old.env(now),
+		"new": in.New.env(now),
+		"now": map[string]any{
+			"weekday": now.Weekday().String()[:3],
+			"hour":    float64(now.Hour()),
+			"minute":  float64(now.Minute()),
+			"date":    now.Format(time.DateOnly),
+			"time":    now.Format("15:04"),
+		},
+	}
+}
+
+func (img Image) env(now time.Time) map[string]any {
+	m := map[string]any{
+		"id":       nullable(img.ID),
+		"digest":   nullable(img.Digest),
+		"labels":   stringMap(img.Labels),
+		"version":  nullable(img.Labels[labelVersion]),
+		"created":  nil,
+		"age_days": nil,
+	}
+	if !img.Created.IsZero() {
+		m["created"] = img.Created.UTC().Format(time.RFC3339)
+		m["age_days"] = now.Sub(img.Created).Hours() / 24
+	}
+	return m
+}
+
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func stringMap(m map[string]string) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,217 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+const examplePolicy = `
+rules:
+  - name: postgres-major
+    when: image.repository == "library/postgres" && semver_major(new.version) != semver_major(old.version)
+    action: deny
+    reason: major PostgreSQL upgrades need a dump and restore
+  - name: our-org-weekends
+    when: image.registry == "ghcr.io" && image.repository matches "our-org/*" && now.weekday in ["Sat", "Sun"]
+    action: deny
+    reason: our images only roll out on weekdays
+  - name: require-revision
+    when: '!has(new.labels["org.opencontainers.image.revision"])'
+    action: deny
+`
+
+func input(image string, oldVersion, newVersion string, now time.Time) Input {
+	labels := func(version string) map[string]string {
+		return map[string]string{
+			"org.opencontainers.image.version":  version,
+			"org.opencontainers.image.revision": "abc123",
+		}
+	}
+	return Input{
+		Container: Container{Name: "app", Image: image},
+		Image:     registry.ParseImageRef(image),
+		Old:       Image{Labels: labels(oldVersion)},
+		New:       Image{Labels: labels(newVersion)},
+		Now:       now,
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(examplePolicy))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	monday := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	saturday := time.Date(2025, 3, 8, 10, 0, 0, 0, time.UTC)
+
+	noRevision := input("nginx", "1.25", "1.26", monday)
+	delete(noRevision.New.Labels, "org.opencontainers.image.revision")
+
+	tests := []struct {
+		name    string
+		in      Input
+		allowed bool
+		rule    string
+	}{
+		{"postgres minor", input("postgres:16", "16.3", "16.4", monday), true, ""},
+		{"postgres major", input("postgres:latest", "16.4", "17.0", monday), false, "postgres-major"},
+		{"our org weekday", input("ghcr.io/our-org/api", "1", "2", monday), true, ""},
+		{"our org weekend", input("ghcr.io/our-org/api", "1", "2", saturday), false, "our-org-weekends"},
+		{"other org weekend", input("ghcr.io/other/api", "1", "2", saturday), true, ""},
+		{"missing revision", noRevision, false, "require-revision"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.in)
+			if d.Allowed != tt.allowed || d.Rule != tt.rule {
+				t.Errorf("Evaluate() = allowed %v by %q (%s), want allowed %v by %q",
+					d.Allowed, d.Rule, d.Explain(), tt.allowed, tt.rule)
+			}
+		})
+	}
+}
+
+func TestEvaluateNilPolicy(t *testing.T) {
+	var p *Policy
+	if d := p.Evaluate(Input{}); !d.Allowed {
+		t.Errorf("nil policy denied update: %s", d.Explain())
+	}
+}
+
+func TestEvaluateDefaultDeny(t *testing.T) {
+	p, err := Parse([]byte(`
+default: deny
+rules:
+  - name: trusted
+    when: container.labels["tier"] == "dev"
+    action: allow
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	in := Input{Container: Container{Labels: map[string]string{"tier": "dev"}}}
+	if d := p.Evaluate(in); !d.Allowed || d.Rule != "trusted" {
+		t.Errorf("dev container: %s", d.Explain())
+	}
+	in.Container.Labels["tier"] = "prod"
+	if d := p.Evaluate(in); d.Allowed || d.Rule != "" {
+		t.Errorf("prod container: %s", d.Explain())
+	}
+}
+
+func TestEvaluateErrorDenies(t *testing.T) {
+	p, err := Parse([]byte(`
+rules:
+  - name: broken
+    when: container.name > 3
+    action: allow
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	d := p.Evaluate(Input{Container: Container{Name: "app"}})
+	if d.Allowed || d.Rule != "broken" || !strings.Contains(d.Reason, "evaluation failed") {
+		t.Errorf("Evaluate() = %s, want denial by broken rule", d.Explain())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{"unknown field", "rulez: []", []string{"rulez"}},
+		{"bad default", "default: maybe", []string{"default"}},
+		{
+			"every bad rule reported",
+			`
+rules:
+  - name: a
+    when: container.name ==
+    action: deny
+  - name: b
+    when: nope.name == "x"
+    action: deny
+  - name: c
+    when: container.name == "x"
+    action: block
+  - name: d
+    when: frobnicate(container.name)
+    action: deny
+`,
+			[]string{"a:", `unknown variable "nope"`, "allow or deny", `unknown function "frobnicate"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc))
+			if err == nil {
+				t.Fatal("Parse() succeeded, want error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExpressions(t *testing.T) {
+	now := time.Date(2025, 3, 3, 14, 30, 0, 0, time.UTC)
+	in := Input{
+		Container: Container{Name: "web", Labels: map[string]string{"team": "Infra"}},
+		Image:     registry.ParseImageRef("ghcr.io/acme/web:v2.3.1-alpine"),
+		Old:       Image{Created: now.Add(-72 * time.Hour)},
+		Now:       now,
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`container.name == "web"`, true},
+		{`container.name != "web"`, false},
+		{`lower(container.labels["team"]) == "infra"`, true},
+		{`container.labels["missing"] == null`, true},
+		{`!has(container.labels["missing"])`, true},
+		{`has(container.labels["missing"]) && container.labels["missing"] > "a"`, false},
+		{`semver_major(image.tag) == 2 && semver_minor(image.tag) == 3`, true},
+		{`semver_major("latest") == null`, true},
+		{`semver_major("latest") < 5`, false},
+		{`image.tag matches "v2.*"`, true},
+		{`starts_with(image.repository, "acme/") || false`, true},
+		{`"acme" in image.repository`, true},
+		{`"team" in container.labels`, true},
+		{`now.hour >= 9 && now.hour < 17 && now.weekday == "Mon"`, true},
+		{`now.date == "2025-03-03" && now.time == "14:30"`, true},
+		{`old.age_days >= 3 && new.age_days == null`, true},
+		{`(1 < 2) == true`, true},
+		{`'single' == "single"`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			n, err := compile(tt.expr)
+			if err != nil {
+				t.Fatalf("compile: %v", err)
+			}
+			got, err := n.eval(in.env())
+			if err != nil {
+				t.Fatalf("eval: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
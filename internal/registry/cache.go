@@ -0,0 +1,86 @@
+package registry
+
+import (
+	"sync"
+	"time"
+)
+
+// DigestCache remembers remote digests for a short time, so that containers
+// sharing an image, on one Docker host or several, cause a single registry
+// request per cycle. Concurrent lookups of the same image wait for the first
+// one. Failed lookups are not cached. A nil *DigestCache caches nothing.
+type DigestCache struct {
+	ttl   time.Duration
+	check func(imageRef string) (string, error)
+
+	mu      sync.Mutex
+	entries map[ImageRef]*digestEntry
+}
+
+type digestEntry struct {
+	done    chan struct{}
+	digest  string
+	err     error
+	fetched time.Time
+}
+
+// NewDigestCache returns a cache whose entries expire after ttl.
+func NewDigestCache(ttl time.Duration) *DigestCache {
+	return NewDigestCacheFunc(ttl, CheckDigest)
+}
+
+// NewDigestCacheFunc returns a cache that looks digests up with check
+// instead of asking the registry, e.g. to serve fixed digests in tests.
+func NewDigestCacheFunc(ttl time.Duration, check func(imageRef string) (string, error)) *DigestCache {
+	return &DigestCache{ttl: ttl, check: check, entries: map[ImageRef]*digestEntry{}}
+}
+
+// CheckDigest returns the remote digest of imageRef like [CheckDigest],
+// from the cache when a recent result exists.
+func (c *DigestCache) CheckDigest(imageRef string) (string, error) {
+	if c == nil {
+		return CheckDigest(imageRef)
+	}
+	ref := ParseImageRef(imageRef)
+
+	c.mu.Lock()
+	if e, ok := c.entries[ref]; ok {
+		select {
+		case <-e.done:
+			if time.Since(e.fetched) < c.ttl {
+				c.mu.Unlock()
+				return e.digest, nil
+			}
+		default:
+			c.mu.Unlock()
+			<-e.done
+			return e.digest, e.err
+		}
+	}
+	e := &digestEntry{done: make(chan struct{})}
+	c.entries[ref] = e
+	c.mu.Unlock()
+
+	e.digest, e.err = c.check(imageRef)
+	e.fetched = time.Now()
+
+	c.mu.Lock()
+	if e.err != nil && c.entries[ref] == e {
+		delete(c.entries, ref)
+	}
+	c.mu.Unlock()
+	close(e.done)
+
+	return e.digest, e.err
+}
+
+// Forget drops the cached digest of imageRef, e.g. after a push
+// notification announced a new one.
+func (c *DigestCache) Forget(imageRef string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.entries, ParseImageRef(imageRef))
+	c.mu.Unlock()
+}
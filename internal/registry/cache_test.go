@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDigestCache(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := NewDigestCache(time.Minute)
+	c.check = func(string) (string, error) {
+		calls.Add(1)
+		<-release
+		return "sha256:abc", nil
+	}
+
+	// Concurrent lookups of one image, spelled differently, share a request.
+	var wg sync.WaitGroup
+	for _, ref := range []string{"nginx", "nginx:latest", "docker.io/library/nginx:latest"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := c.CheckDigest(ref); err != nil || got != "sha256:abc" {
+				t.Errorf("CheckDigest(%q) = %q, %v", ref, got, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if _, err := c.CheckDigest("nginx"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 registry request, got %d", n)
+	}
+
+	c.Forget("nginx:latest")
+	if _, err := c.CheckDigest("nginx"); err != nil {
+		t.Fatal(err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected a new request after Forget, got %d requests", n)
+	}
+}
+
+func TestDigestCacheExpiryAndErrors(t *testing.T) {
+	var calls int
+	fail := true
+	c := NewDigestCache(time.Millisecond)
+	c.check = func(string) (string, error) {
+		calls++
+		if fail {
+			return "", errors.New("unavailable")
+		}
+		return "sha256:abc", nil
+	}
+
+	if _, err := c.CheckDigest("nginx"); err == nil {
+		t.Fatal("expected error")
+	}
+	fail = false
+	if _, err := c.CheckDigest("nginx"); err != nil {
+		t.Fatalf("failed lookup was cached: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if _, err := c.CheckDigest("nginx"); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 registry requests, got %d", calls)
+	}
+}
@@ -0,0 +1,221 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the registry reports that a manifest, blob
+// or API endpoint does not exist.
+var ErrNotFound = errors.New("not found in registry")
+
+// ErrRateLimited is returned when the registry refuses a request because the
+// pull rate limit is exhausted.
+var ErrRateLimited = errors.New("registry rate limit exceeded")
+
+// maxDocumentSize bounds manifests and image configs read from a registry.
+const maxDocumentSize = 4 << 20
+
+// Descriptor references a manifest or blob by digest.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant,omitempty"`
+	} `json:"platform,omitempty"`
+}
+
+// Manifest is the union of the fields Isengard reads from image manifests
+// and multi-arch indexes.
+type Manifest struct {
+	MediaType string       `json:"mediaType"`
+	Config    Descriptor   `json:"config"`
+	Layers    []Descriptor `json:"layers"`
+	Manifests []Descriptor `json:"manifests"`
+}
+
+// isIndex reports whether the manifest is a multi-arch index.
+func (m Manifest) isIndex(contentType string) bool {
+	switch {
+	case strings.HasPrefix(contentType, mediaTypeDockerManifestList),
+		strings.HasPrefix(contentType, mediaTypeOCIIndex),
+		m.MediaType == mediaTypeDockerManifestList,
+		m.MediaType == mediaTypeOCIIndex:
+		return true
+	}
+	return len(m.Manifests) > 0 && len(m.Layers) == 0
+}
+
+// imageConfig holds the fields read from an image's config blob.
+type imageConfig struct {
+	Created time.Time `json:"created"`
+	Config  struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+// ManifestURLFor returns the URL of a manifest by tag or digest.
+func (r ImageRef) ManifestURLFor(reference string) string {
+	return fmt.Sprintf("%s/%s/manifests/%s", r.RegistryURL(), r.Repository, reference)
+}
+
+// BlobURL returns the URL of a blob by digest.
+func (r ImageRef) BlobURL(digest string) string {
+	return fmt.Sprintf("%s/%s/blobs/%s", r.RegistryURL(), r.Repository, digest)
+}
+
+// ImageCreated returns the creation time recorded in the config of the image
+// that digest points to. For a multi-arch index it uses the entry matching
+// the platform Isengard runs on.
+func ImageCreated(imageRef, digest string) (time.Time, error) {
+	ref := ParseImageRef(imageRef)
+	cfg, err := fetchImageConfig(NewSession(ref), ref, digest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if cfg.Created.IsZero() {
+		return time.Time{}, fmt.Errorf("image config has no created timestamp")
+	}
+	return cfg.Created, nil
+}
+
+// ImageMetadata returns the creation time and labels recorded in the config
+// of the image that digest points to, picking the platform like
+// [ImageCreated]. The creation time is zero if the config has none.
+func ImageMetadata(imageRef, digest string) (time.Time, map[string]string, error) {
+	ref := ParseImageRef(imageRef)
+	cfg, err := fetchImageConfig(NewSession(ref), ref, digest)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	return cfg.Created, cfg.Config.Labels, nil
+}
+
+// fetchImageConfig resolves digest to a single-platform manifest and decodes
+// its config blob.
+func fetchImageConfig(s *Session, ref ImageRef, digest string) (imageConfig, error) {
+	m, isIndex, err := s.manifest(digest)
+	if err != nil {
+		return imageConfig{}, err
+	}
+
+	if isIndex {
+		platformDigest, err := selectPlatform(m.Manifests, runtime.GOOS, runtime.GOARCH)
+		if err != nil {
+			return imageConfig{}, err
+		}
+		if m, _, err = s.manifest(platformDigest); err != nil {
+			return imageConfig{}, err
+		}
+	}
+
+	if m.Config.Digest == "" {
+		return imageConfig{}, fmt.Errorf("manifest has no config descriptor")
+	}
+
+	var cfg imageConfig
+	if _, err := s.getJSON(ref.BlobURL(m.Config.Digest), nil, &cfg); err != nil {
+		return imageConfig{}, err
+	}
+	return cfg, nil
+}
+
+// Manifest fetches a manifest of the session's repository by tag or digest.
+// It returns [ErrNotFound] if the registry has no such manifest.
+func (s *Session) Manifest(reference string) (Manifest, error) {
+	m, _, err := s.manifest(reference)
+	return m, err
+}
+
+// manifest fetches a manifest and reports whether it is a multi-arch index.
+func (s *Session) manifest(reference string) (Manifest, bool, error) {
+	var m Manifest
+	contentType, err := s.getJSON(s.ref.ManifestURLFor(reference), manifestAccept, &m)
+	if err != nil {
+		return Manifest{}, false, err
+	}
+	return m, m.isIndex(contentType), nil
+}
+
+// Blob fetches a blob of the session's repository and verifies that its
+// content matches digest.
+func (s *Session) Blob(digest string) ([]byte, error) {
+	resp, err := s.do(http.MethodGet, s.ref.BlobURL(digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET blob %s returned %d", digest, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize))
+	if err != nil {
+		return nil, fmt.Errorf("reading blob %s: %w", digest, err)
+	}
+
+	algo, want, ok := strings.Cut(digest, ":")
+	if !ok || algo != "sha256" {
+		return nil, fmt.Errorf("unsupported digest %q", digest)
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != want {
+		return nil, fmt.Errorf("blob content does not match digest %s", digest)
+	}
+	return data, nil
+}
+
+// Referrers lists the manifests that refer to digest through the OCI
+// referrers API, filtered by artifactType if non-empty. It returns
+// [ErrNotFound] if the registry does not support the API.
+func (s *Session) Referrers(digest, artifactType string) ([]Descriptor, error) {
+	u := fmt.Sprintf("%s/%s/referrers/%s", s.ref.RegistryURL(), s.ref.Repository, digest)
+	if artifactType != "" {
+		u += "?artifactType=" + url.QueryEscape(artifactType)
+	}
+
+	var index struct {
+		Manifests []struct {
+			Descriptor
+			ArtifactType string `json:"artifactType"`
+		} `json:"manifests"`
+	}
+	if _, err := s.getJSON(u, []string{mediaTypeOCIIndex}, &index); err != nil {
+		return nil, err
+	}
+
+	var result []Descriptor
+	for _, m := range index.Manifests {
+		// Registries may ignore the filter, so apply it again.
+		if artifactType == "" || m.ArtifactType == artifactType {
+			result = append(result, m.Descriptor)
+		}
+	}
+	return result, nil
+}
+
+// selectPlatform picks the manifest for os/arch from an index.
+func selectPlatform(manifests []Descriptor, goos, goarch string) (string, error) {
+	for _, d := range manifests {
+		if d.Platform != nil && d.Platform.OS == goos && d.Platform.Architecture == goarch {
+			return d.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("no manifest for platform %s/%s", goos, goarch)
+}
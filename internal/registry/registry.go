@@ -7,10 +7,12 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
 )
 
 // ImageRef is a parsed Docker image reference.
@@ -30,6 +32,9 @@ type ImageRef struct {
 //	"user/repo:tag"                 -> registry-1.docker.io / user/repo    : tag
 //	"ghcr.io/user/repo:v1"         -> ghcr.io             / user/repo    : v1
 //	"registry.example.com:5000/img" -> registry.example.com:5000 / img    : latest
+//
+// Names without a registry that have a Podman short-name alias (see
+// [SetShortNames]) resolve to the aliased repository instead of Docker Hub.
 func ParseImageRef(imageRef string) ImageRef {
 	ref := imageRef
 
@@ -54,23 +59,23 @@ func ParseImageRef(imageRef string) ImageRef {
 		}
 	}
 
+	// Podman resolves unqualified names through its short-name aliases
+	if !hasRegistryHost(ref) {
+		if alias, ok := shortNameAlias(ref); ok {
+			ref = alias
+		}
+	}
+
 	// Determine registry and repository
 	registry := "registry-1.docker.io"
 	repository := ref
 
-	if strings.Contains(ref, "/") {
-		parts := strings.SplitN(ref, "/", 2)
-		first := parts[0]
-
-		// Looks like a registry hostname if it has dots, colons, or is "localhost"
-		if strings.Contains(first, ".") || strings.Contains(first, ":") || first == "localhost" {
-			registry = first
-			repository = parts[1]
+	if hasRegistryHost(ref) {
+		registry, repository, _ = strings.Cut(ref, "/")
 
-			// Docker Hub aliases
-			if registry == "docker.io" || registry == "index.docker.io" {
-				registry = "registry-1.docker.io"
-			}
+		// Docker Hub aliases
+		if registry == "docker.io" || registry == "index.docker.io" {
+			registry = "registry-1.docker.io"
 		}
 	}
 
@@ -86,14 +91,39 @@ func ParseImageRef(imageRef string) ImageRef {
 	}
 }
 
+// hasRegistryHost reports whether an image name starts with a registry
+// hostname: a first path component with dots, colons, or "localhost".
+func hasRegistryHost(name string) bool {
+	first, _, ok := strings.Cut(name, "/")
+	return ok && (strings.Contains(first, ".") || strings.Contains(first, ":") || first == "localhost")
+}
+
 // RegistryURL returns the v2 API base URL for this registry.
 func (r ImageRef) RegistryURL() string {
-	return "https://" + r.Registry + "/v2"
+	return baseURL(r.Registry) + "/v2"
 }
 
 // ManifestURL returns the full URL for the tag's manifest.
 func (r ImageRef) ManifestURL() string {
-	return fmt.Sprintf("%s/%s/manifests/%s", r.RegistryURL(), r.Repository, r.Tag)
+	return r.ManifestURLFor(r.Tag)
+}
+
+// Media types accepted when fetching manifests, covering single-platform
+// manifests and multi-arch indexes in both Docker and OCI formats.
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAccept lists the Accept headers needed to get the correct digest
+// for multi-arch manifests.
+var manifestAccept = []string{
+	mediaTypeDockerManifest,
+	mediaTypeDockerManifestList,
+	mediaTypeOCIManifest,
+	mediaTypeOCIIndex,
 }
 
 // CheckDigest queries the registry v2 API to get the remote manifest digest
@@ -116,84 +146,115 @@ func CheckDigest(imageRef string) (string, error) {
 		"url", manifestURL,
 	)
 
-	// Accept headers needed to get the correct digest for multi-arch manifests
-	acceptHeaders := []string{
-		"application/vnd.docker.distribution.manifest.v2+json",
-		"application/vnd.docker.distribution.manifest.list.v2+json",
-		"application/vnd.oci.image.manifest.v1+json",
-		"application/vnd.oci.image.index.v1+json",
+	resp, err := NewSession(ref).do(http.MethodHead, manifestURL, manifestAccept)
+	if err != nil {
+		return "", fmt.Errorf("HEAD manifest: %w", err)
 	}
+	resp.Body.Close()
 
-	// First attempt: unauthenticated HEAD
-	req, err := http.NewRequest("HEAD", manifestURL, http.NoBody)
-	if err != nil {
-		return "", fmt.Errorf("creating request: %w", err)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if retry := resp.Header.Get("Retry-After"); retry != "" {
+			return "", fmt.Errorf("%w, retry after %ss", ErrRateLimited, retry)
+		}
+		return "", ErrRateLimited
 	}
-	for _, accept := range acceptHeaders {
-		req.Header.Add("Accept", accept)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d from manifest HEAD", resp.StatusCode)
 	}
 
-	// If we have Basic credentials for this registry, add them upfront
-	if username, password, ok := credentialsForRegistry(ref.Registry); ok {
-		req.SetBasicAuth(username, password)
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("200 OK but no Docker-Content-Digest header")
 	}
+	return digest, nil
+}
+
+// Session performs registry requests for a single repository, exchanging a
+// Www-Authenticate challenge for a Bearer token on the first 401 and reusing
+// the token for subsequent requests. A Session is not safe for concurrent use.
+type Session struct {
+	ref    ImageRef
+	client *http.Client
+	token  string
+}
+
+// NewSession starts a session for the repository in ref.
+func NewSession(ref ImageRef) *Session {
+	return &Session{ref: ref, client: httpClient()}
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// do sends a request with the given Accept headers, authenticating as needed.
+// The caller must close the response body.
+func (s *Session) do(method, url string, accept []string) (*http.Response, error) {
+	resp, err := s.send(method, url, accept)
 	if err != nil {
-		return "", fmt.Errorf("HEAD manifest: %w", err)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusUnauthorized || s.token != "" {
+		return resp, nil
 	}
 	resp.Body.Close()
 
-	// If we get a digest immediately, great
-	if resp.StatusCode == http.StatusOK {
-		digest := resp.Header.Get("Docker-Content-Digest")
-		if digest != "" {
-			return digest, nil
-		}
-		return "", fmt.Errorf("200 OK but no Docker-Content-Digest header")
+	// If 401, we need to do token exchange
+	challenge := resp.Header.Get("Www-Authenticate")
+	if challenge == "" {
+		return nil, fmt.Errorf("401 with no Www-Authenticate header")
 	}
 
-	// If 401, we need to do token exchange
-	if resp.StatusCode == http.StatusUnauthorized {
-		challenge := resp.Header.Get("Www-Authenticate")
-		if challenge == "" {
-			return "", fmt.Errorf("401 with no Www-Authenticate header")
-		}
+	token, err := exchangeToken(challenge, s.ref)
+	if err != nil {
+		return nil, fmt.Errorf("token exchange: %w", err)
+	}
+	s.token = token
 
-		token, err := exchangeToken(challenge, ref)
-		if err != nil {
-			return "", fmt.Errorf("token exchange: %w", err)
-		}
+	// Retry with Bearer token
+	resp, err = s.send(method, url, accept)
+	if err != nil {
+		return nil, fmt.Errorf("authenticated request: %w", err)
+	}
+	return resp, nil
+}
 
-		// Retry with Bearer token
-		req2, err := http.NewRequest("HEAD", manifestURL, http.NoBody)
-		if err != nil {
-			return "", fmt.Errorf("creating authenticated request: %w", err)
-		}
-		for _, accept := range acceptHeaders {
-			req2.Header.Add("Accept", accept)
-		}
-		req2.Header.Set("Authorization", "Bearer "+token)
+// send issues a single request, using the session's Bearer token if one has
+// been obtained and Basic credentials for the registry otherwise.
+func (s *Session) send(method, url string, accept []string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	for _, a := range accept {
+		req.Header.Add("Accept", a)
+	}
 
-		resp2, err := client.Do(req2)
-		if err != nil {
-			return "", fmt.Errorf("authenticated HEAD manifest: %w", err)
-		}
-		resp2.Body.Close()
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	} else if username, password, ok := credentialsForRegistry(s.ref.Registry); ok {
+		// If we have Basic credentials for this registry, add them upfront
+		req.SetBasicAuth(username, password)
+	}
 
-		if resp2.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("authenticated HEAD returned %d", resp2.StatusCode)
-		}
+	return s.client.Do(req)
+}
 
-		digest := resp2.Header.Get("Docker-Content-Digest")
-		if digest != "" {
-			return digest, nil
-		}
-		return "", fmt.Errorf("authenticated 200 OK but no Docker-Content-Digest header")
+// getJSON fetches url and decodes a JSON body into v, returning the response
+// Content-Type.
+func (s *Session) getJSON(url string, accept []string, v any) (string, error) {
+	resp, err := s.do(http.MethodGet, url, accept)
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	return "", fmt.Errorf("unexpected status %d from manifest HEAD", resp.StatusCode)
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GET %s returned %d", url, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(v); err != nil {
+		return "", fmt.Errorf("decoding %s: %w", url, err)
+	}
+	return resp.Header.Get("Content-Type"), nil
 }
 
 // tokenResponse is the JSON structure returned by token endpoints.
@@ -236,8 +297,7 @@ func exchangeToken(challenge string, ref ImageRef) (string, error) {
 		req.SetBasicAuth(username, password)
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := httpClient().Do(req)
 	if err != nil {
 		return "", fmt.Errorf("token request: %w", err)
 	}
@@ -331,9 +391,39 @@ type dockerAuthEntry struct {
 	Auth string `json:"auth"`
 }
 
-// credentialsForRegistry reads ~/.docker/config.json and returns username/password
-// for the given registry, or ok=false if not found.
+// Credential is a username and password (or token) for a registry.
+type Credential struct {
+	Username string
+	Password string
+}
+
+var (
+	staticMu          sync.RWMutex
+	staticCredentials map[string]Credential
+)
+
+// SetCredentials installs credentials keyed by registry host (as in
+// config.json, e.g. "ghcr.io" or "docker.io"). They take precedence over
+// ~/.docker/config.json and replace any previously set credentials.
+func SetCredentials(creds map[string]Credential) {
+	staticMu.Lock()
+	defer staticMu.Unlock()
+	staticCredentials = creds
+}
+
+// credentialsForRegistry returns username/password for the given registry from
+// the credentials set with [SetCredentials] or ~/.docker/config.json, or
+// ok=false if not found.
 func credentialsForRegistry(registry string) (username, password string, ok bool) {
+	staticMu.RLock()
+	for _, key := range registryConfigKeys(registry) {
+		if c, found := staticCredentials[key]; found {
+			staticMu.RUnlock()
+			return c.Username, c.Password, true
+		}
+	}
+	staticMu.RUnlock()
+
 	configPath := "/root/.docker/config.json"
 	if v := os.Getenv("DOCKER_CONFIG"); v != "" {
 		configPath = v + "/config.json"
@@ -0,0 +1,194 @@
+package registry
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/fakeregistry"
+)
+
+// useFake starts a fake registry and points lookups for its host at it.
+func useFake(t *testing.T) *fakeregistry.Registry {
+	t.Helper()
+	r := fakeregistry.New()
+	SetEndpoints(map[string]string{r.Host(): r.URL()})
+	t.Cleanup(func() {
+		SetEndpoints(nil)
+		SetCredentials(nil)
+		r.Close()
+	})
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	return r
+}
+
+func TestCheckDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(r *fakeregistry.Registry)
+		creds   *Credential
+		wantErr string
+	}{
+		{name: "anonymous"},
+		{
+			name:  "anonymous token",
+			setup: func(r *fakeregistry.Registry) { r.RequireToken("", "") },
+		},
+		{
+			name:  "token with credentials",
+			setup: func(r *fakeregistry.Registry) { r.RequireToken("bob", "secret") },
+			creds: &Credential{Username: "bob", Password: "secret"},
+		},
+		{
+			name:    "token with wrong credentials",
+			setup:   func(r *fakeregistry.Registry) { r.RequireToken("bob", "secret") },
+			creds:   &Credential{Username: "bob", Password: "guess"},
+			wantErr: "token endpoint returned 401",
+		},
+		{
+			name:  "basic",
+			setup: func(r *fakeregistry.Registry) { r.RequireBasic("bob", "secret") },
+			creds: &Credential{Username: "bob", Password: "secret"},
+		},
+		{
+			name:    "basic without credentials",
+			setup:   func(r *fakeregistry.Registry) { r.RequireBasic("bob", "secret") },
+			wantErr: "no realm in challenge",
+		},
+		{
+			name:    "server error",
+			setup:   func(r *fakeregistry.Registry) { r.Fail("/manifests/", http.StatusServiceUnavailable) },
+			wantErr: "unexpected status 503",
+		},
+		{
+			name: "token endpoint down",
+			setup: func(r *fakeregistry.Registry) {
+				r.RequireToken("", "")
+				r.Fail("/token", http.StatusInternalServerError)
+			},
+			wantErr: "token endpoint returned 500",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := useFake(t)
+			want := r.PushImage("team/app", "v1", fakeregistry.Image{})
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			if tt.creds != nil {
+				SetCredentials(map[string]Credential{r.Host(): *tt.creds})
+			}
+
+			got, err := CheckDigest(r.Host() + "/team/app:v1")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CheckDigest error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CheckDigest: %v", err)
+			}
+			if got != want {
+				t.Errorf("CheckDigest = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckDigest_UnknownTag(t *testing.T) {
+	r := useFake(t)
+	r.PushImage("team/app", "v1", fakeregistry.Image{})
+
+	if _, err := CheckDigest(r.Host() + "/team/app:v2"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("CheckDigest of a missing tag: err = %v, want 404", err)
+	}
+}
+
+func TestCheckDigest_RateLimited(t *testing.T) {
+	r := useFake(t)
+	r.PushImage("team/app", "v1", fakeregistry.Image{})
+	r.SetRateLimit(100, 1)
+
+	// HEAD requests do not count against the limit.
+	for range 3 {
+		if _, err := CheckDigest(r.Host() + "/team/app:v1"); err != nil {
+			t.Fatalf("CheckDigest: %v", err)
+		}
+	}
+
+	if _, err := NewSession(ParseImageRef(r.Host() + "/team/app:v1")).Manifest("v1"); err != nil {
+		t.Fatalf("Manifest: %v", err)
+	}
+	_, err := CheckDigest(r.Host() + "/team/app:v1")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("CheckDigest after exhausting the limit: err = %v, want ErrRateLimited", err)
+	}
+	if !strings.Contains(err.Error(), "retry after 60s") {
+		t.Errorf("error %q does not say when to retry", err)
+	}
+}
+
+func TestImageCreated_Index(t *testing.T) {
+	r := useFake(t)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	digest := r.PushIndex("team/app", "v1", map[string]fakeregistry.Image{
+		runtime.GOOS + "/" + runtime.GOARCH: {Created: created},
+		"plan9/mips":                        {Created: created.Add(-time.Hour)},
+	})
+
+	got, err := ImageCreated(r.Host()+"/team/app:v1", digest)
+	if err != nil {
+		t.Fatalf("ImageCreated: %v", err)
+	}
+	if !got.Equal(created) {
+		t.Errorf("ImageCreated = %v, want %v", got, created)
+	}
+}
+
+func TestImageMetadata(t *testing.T) {
+	r := useFake(t)
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	labels := map[string]string{"org.opencontainers.image.version": "2.1.0"}
+	digest := r.PushImage("team/app", "v1", fakeregistry.Image{Created: created, Labels: labels})
+
+	gotCreated, gotLabels, err := ImageMetadata(r.Host()+"/team/app:v1", digest)
+	if err != nil {
+		t.Fatalf("ImageMetadata: %v", err)
+	}
+	if !gotCreated.Equal(created) || !reflect.DeepEqual(gotLabels, labels) {
+		t.Errorf("ImageMetadata = %v, %v, want %v, %v", gotCreated, gotLabels, created, labels)
+	}
+}
+
+func TestSessionReusesToken(t *testing.T) {
+	r := useFake(t)
+	r.RequireToken("", "")
+	digest := r.PushImage("team/app", "v1", fakeregistry.Image{})
+
+	s := NewSession(ParseImageRef(r.Host() + "/team/app:v1"))
+	for _, ref := range []string{"v1", digest} {
+		if _, err := s.Manifest(ref); err != nil {
+			t.Fatalf("Manifest(%s): %v", ref, err)
+		}
+	}
+	if _, err := s.Manifest("v2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Manifest of a missing tag: err = %v, want ErrNotFound", err)
+	}
+
+	tokens := 0
+	for _, req := range r.Requests() {
+		if strings.HasSuffix(req, "/token") {
+			tokens++
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("%d token requests, want 1", tokens)
+	}
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	shortNamesMu sync.RWMutex
+	shortNames   map[string]string
+)
+
+// SetShortNames installs Podman short-name aliases, mapping unqualified
+// names such as "fedora" to the repositories Podman resolves them to, e.g.
+// "registry.fedoraproject.org/fedora". [ParseImageRef] applies them to image
+// references without a registry. They replace any previously set aliases.
+func SetShortNames(aliases map[string]string) {
+	shortNamesMu.Lock()
+	defer shortNamesMu.Unlock()
+	shortNames = aliases
+}
+
+// shortNameAlias returns the repository an unqualified name is an alias for.
+func shortNameAlias(name string) (string, bool) {
+	shortNamesMu.RLock()
+	defer shortNamesMu.RUnlock()
+	alias, ok := shortNames[name]
+	return alias, ok
+}
+
+// LoadShortNames reads the [aliases] tables of a Podman registries.conf and
+// of the *.conf files in the registries.conf.d directory next to it, in the
+// order Podman reads them; later files override earlier ones. A missing
+// drop-in directory is not an error.
+func LoadShortNames(path string) (map[string]string, error) {
+	paths := []string{path}
+	dropIns, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "registries.conf.d", "*.conf"))
+	sort.Strings(dropIns)
+	paths = append(paths, dropIns...)
+
+	aliases := map[string]string{}
+	for _, p := range paths {
+		if err := readAliases(p, aliases); err != nil {
+			return nil, err
+		}
+	}
+	return aliases, nil
+}
+
+// readAliases adds the name = "repository" entries of the [aliases] table
+// of a registries.conf file to aliases. Only this part of the TOML syntax is
+// understood; other tables are ignored.
+func readAliases(path string, aliases map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	inAliases := false
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "["):
+			inAliases = line == "[aliases]"
+			continue
+		case !inAliases:
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected name = \"repository\"", path, n)
+		}
+		name, err := tomlString(key)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		repo, err := tomlString(value)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		aliases[name] = repo
+	}
+	return scanner.Err()
+}
+
+// tomlString parses a bare or quoted TOML key or string value, ignoring a
+// trailing comment.
+func tomlString(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, `"`) {
+		if i := strings.Index(s, "#"); i >= 0 {
+			s = strings.TrimSpace(s[:i])
+		}
+		if s == "" {
+			return "", fmt.Errorf("empty value")
+		}
+		return s, nil
+	}
+	end := strings.Index(s[1:], `"`)
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return strconv.Unquote(s[:end+2])
+}
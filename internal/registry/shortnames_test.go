@@ -0,0 +1,76 @@
+package registry
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadShortNames(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "registries.conf"), `
+unqualified-search-registries = ["registry.fedoraproject.org", "docker.io"]
+
+[aliases]
+"fedora" = "registry.fedoraproject.org/fedora"
+ubi9 = "registry.access.redhat.com/ubi9" # comment
+
+[[registry]]
+location = "quay.io"
+`)
+	writeFile(t, filepath.Join(dir, "registries.conf.d", "000-shortnames.conf"), `
+[aliases]
+  "fedora" = "quay.io/fedora/fedora"
+  "nginx" = "docker.io/library/nginx"
+`)
+
+	got, err := LoadShortNames(filepath.Join(dir, "registries.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"fedora": "quay.io/fedora/fedora",
+		"ubi9":   "registry.access.redhat.com/ubi9",
+		"nginx":  "docker.io/library/nginx",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadShortNames() = %v, want %v", got, want)
+	}
+
+	if _, err := LoadShortNames(filepath.Join(dir, "missing.conf")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestParseImageRefShortNames(t *testing.T) {
+	SetShortNames(map[string]string{"fedora": "registry.fedoraproject.org/fedora"})
+	t.Cleanup(func() { SetShortNames(nil) })
+
+	tests := []struct {
+		image string
+		want  ImageRef
+	}{
+		{"fedora:40", ImageRef{"registry.fedoraproject.org", "fedora", "40"}},
+		{"fedora@sha256:abc", ImageRef{"registry.fedoraproject.org", "fedora", "latest"}},
+		{"quay.io/fedora:40", ImageRef{"quay.io", "fedora", "40"}},
+		{"nginx", ImageRef{"registry-1.docker.io", "library/nginx", "latest"}},
+		{"localhost/myapp:dev", ImageRef{"localhost", "myapp", "dev"}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseImageRef(tt.image); got != tt.want {
+			t.Errorf("ParseImageRef(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
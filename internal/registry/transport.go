@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	transportMu sync.RWMutex
+	transport   http.RoundTripper
+	endpoints   map[string]string
+)
+
+// SetTransport replaces the transport used for registry and token requests.
+// nil restores [http.DefaultTransport].
+func SetTransport(rt http.RoundTripper) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	transport = rt
+}
+
+// SetEndpoints maps registry hosts to the base URL their API is served at,
+// e.g. "registry-1.docker.io" to "http://127.0.0.1:5000", for mirrors and
+// test registries. Hosts without an entry are reached over HTTPS. The map
+// replaces any previously set endpoints.
+func SetEndpoints(m map[string]string) {
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	endpoints = m
+}
+
+// httpClient returns a client using the configured transport.
+func httpClient() *http.Client {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	return &http.Client{Transport: transport}
+}
+
+// baseURL returns the scheme and host the API of registry is served at.
+func baseURL(registry string) string {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	if u, ok := endpoints[registry]; ok {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "https://" + registry
+}
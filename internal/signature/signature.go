@@ -0,0 +1,230 @@
+// Package signature verifies cosign-style image signatures stored in the
+// registry before an update is applied.
+//
+// Signatures are looked up under the "sha256-<hex>.sig" tag convention and,
+// if that tag does not exist, through the OCI referrers API. Each signature
+// layer holds a simple-signing JSON payload naming the signed manifest
+// digest, with the signature itself in the
+// "dev.cosignproject.cosign/signature" annotation.
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+const (
+	// annotationSignature holds the base64 signature of a layer's payload.
+	annotationSignature = "dev.cosignproject.cosign/signature"
+	// artifactTypeSignature identifies cosign signatures in the referrers API.
+	artifactTypeSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+	// payloadType is the expected simple-signing payload type.
+	payloadType = "cosign container image signature"
+)
+
+var (
+	// ErrNoSignature means no signature was found for the digest.
+	ErrNoSignature = errors.New("no signature found")
+	// ErrInvalidSignature means signatures were found but none verified
+	// against the configured keys.
+	ErrInvalidSignature = errors.New("no valid signature from a trusted key")
+)
+
+// Verifier checks image signatures against a set of trusted public keys.
+// A nil *Verifier accepts every image.
+type Verifier struct {
+	keys []crypto.PublicKey
+}
+
+// NewVerifier loads PEM-encoded public keys (ECDSA, RSA or Ed25519) from
+// paths. It returns nil if paths is empty.
+func NewVerifier(paths []string) (*Verifier, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	v := &Verifier{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading public key: %w", err)
+		}
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing public key %s: %w", path, err)
+		}
+		v.keys = append(v.keys, key)
+	}
+	return v, nil
+}
+
+// ParsePublicKey decodes a PEM "PUBLIC KEY" block.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// Verify checks that digest, the manifest digest a tag of imageRef resolves
+// to, carries at least one signature made by a trusted key.
+func (v *Verifier) Verify(imageRef, digest string) error {
+	if v == nil {
+		return nil
+	}
+
+	ref := registry.ParseImageRef(imageRef)
+	s := registry.NewSession(ref)
+
+	manifests, err := signatureManifests(s, digest)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, m := range manifests {
+		for _, layer := range m.Layers {
+			sig := layer.Annotations[annotationSignature]
+			if sig == "" {
+				continue
+			}
+			payload, err := s.Blob(layer.Digest)
+			if err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			if err := v.verifyPayload(payload, sig, digest); err != nil {
+				problems = append(problems, err.Error())
+				continue
+			}
+			return nil
+		}
+	}
+
+	if len(problems) == 0 {
+		return ErrNoSignature
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidSignature, strings.Join(problems, "; "))
+}
+
+// signatureManifests returns the signature manifests attached to digest,
+// first via the "sha256-<hex>.sig" tag and then via the referrers API.
+func signatureManifests(s *registry.Session, digest string) ([]registry.Manifest, error) {
+	m, err := s.Manifest(SignatureTag(digest))
+	if err == nil {
+		return []registry.Manifest{m}, nil
+	}
+	if !errors.Is(err, registry.ErrNotFound) {
+		return nil, fmt.Errorf("fetching signature tag: %w", err)
+	}
+
+	refs, err := s.Referrers(digest, artifactTypeSignature)
+	if errors.Is(err, registry.ErrNotFound) {
+		return nil, ErrNoSignature
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing referrers: %w", err)
+	}
+
+	var manifests []registry.Manifest
+	for _, d := range refs {
+		m, err := s.Manifest(d.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("fetching signature manifest: %w", err)
+		}
+		manifests = append(manifests, m)
+	}
+	if len(manifests) == 0 {
+		return nil, ErrNoSignature
+	}
+	return manifests, nil
+}
+
+// SignatureTag returns the tag under which cosign stores the signature of a
+// digest, e.g. "sha256:abc..." -> "sha256-abc....sig".
+func SignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// simpleSigning is the subset of the simple-signing payload that is checked.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// verifyPayload checks that sigB64 is a valid signature of payload by one of
+// the trusted keys and that payload names digest.
+func (v *Verifier) verifyPayload(payload []byte, sigB64, digest string) error {
+	sig, err := base64.StdEncoding.DecodeString(sigB64)
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	trusted := false
+	for _, key := range v.keys {
+		if verifyWithKey(key, payload, sig) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return errors.New("signature does not match any trusted key")
+	}
+
+	// Only trust the payload's contents after the signature checks out.
+	var ss simpleSigning
+	if err := json.Unmarshal(payload, &ss); err != nil {
+		return fmt.Errorf("decoding signed payload: %w", err)
+	}
+	if ss.Critical.Type != payloadType {
+		return fmt.Errorf("unexpected payload type %q", ss.Critical.Type)
+	}
+	if ss.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s, not %s", ss.Critical.Image.DockerManifestDigest, digest)
+	}
+	return nil
+}
+
+// verifyWithKey reports whether sig is a valid signature of payload by key.
+func verifyWithKey(key crypto.PublicKey, payload, sig []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, hash[:], sig)
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, hash[:], sig) == nil {
+			return true
+		}
+		return rsa.VerifyPSS(k, crypto.SHA256, hash[:], sig, nil) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, payload, sig)
+	}
+	return false
+}
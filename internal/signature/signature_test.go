@@ -0,0 +1,164 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testDigest = "sha256:0bb7fda0c98c1f9200b374f681f1bb7a08b60dc56dc3c22fc25cfbcf42b7720b"
+
+func testPayload(digest string) []byte {
+	return []byte(`{"critical":{"identity":{"docker-reference":"ghcr.io/org/app"},` +
+		`"image":{"docker-manifest-digest":"` + digest + `"},` +
+		`"type":"cosign container image signature"},"optional":null}`)
+}
+
+func pemEncode(t *testing.T, pub crypto.PublicKey) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func TestSignatureTag(t *testing.T) {
+	got := SignatureTag("sha256:abc123")
+	if got != "sha256-abc123.sig" {
+		t.Errorf("SignatureTag(): got %q", got)
+	}
+}
+
+func TestNilVerifier(t *testing.T) {
+	v, err := NewVerifier(nil)
+	if err != nil || v != nil {
+		t.Fatalf("expected nil verifier, got %v, %v", v, err)
+	}
+	if err := v.Verify("nginx:latest", testDigest); err != nil {
+		t.Errorf("nil verifier should accept everything, got %v", err)
+	}
+}
+
+func TestNewVerifierFromFiles(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	good := filepath.Join(dir, "cosign.pub")
+	if err := os.WriteFile(good, pemEncode(t, &key.PublicKey), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "garbage.pub")
+	if err := os.WriteFile(bad, []byte("not a key"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier([]string{good})
+	if err != nil || v == nil || len(v.keys) != 1 {
+		t.Fatalf("expected verifier with one key, got %v, %v", v, err)
+	}
+	if _, err := NewVerifier([]string{bad}); err == nil {
+		t.Error("expected error for invalid key file")
+	}
+	if _, err := NewVerifier([]string{filepath.Join(dir, "missing.pub")}); err == nil {
+		t.Error("expected error for missing key file")
+	}
+}
+
+func TestVerifyPayloadECDSA(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(k *ecdsa.PrivateKey, payload []byte) string {
+		hash := sha256.Sum256(payload)
+		sig, err := ecdsa.SignASN1(rand.Reader, k, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	v := &Verifier{keys: []crypto.PublicKey{&key.PublicKey}}
+	payload := testPayload(testDigest)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		sig     string
+		digest  string
+		wantErr bool
+	}{
+		{"valid", payload, sign(key, payload), testDigest, false},
+		{"untrusted key", payload, sign(other, payload), testDigest, true},
+		{"tampered payload", testPayload("sha256:ffff"), sign(key, payload), testDigest, true},
+		{"signature for other digest", testPayload("sha256:ffff"), sign(key, testPayload("sha256:ffff")), testDigest, true},
+		{"not base64", payload, "%%%", testDigest, true},
+		{"wrong payload type", []byte(`{"critical":{"image":{"docker-manifest-digest":"` + testDigest + `"},"type":"other"}}`),
+			sign(key, []byte(`{"critical":{"image":{"docker-manifest-digest":"`+testDigest+`"},"type":"other"}}`)), testDigest, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.verifyPayload(tt.payload, tt.sig, tt.digest)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifyPayload(): err=%v, wantErr=%v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyPayloadOtherKeyTypes(t *testing.T) {
+	payload := testPayload(testDigest)
+	hash := sha256.Sum256(payload)
+
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edPriv, payload)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys round-trip through PEM like they would from disk.
+	edKey, err := ParsePublicKey(pemEncode(t, edPub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ParsePublicKey(pemEncode(t, &rsaKey.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &Verifier{keys: []crypto.PublicKey{edKey, rsaPub}}
+	if err := v.verifyPayload(payload, base64.StdEncoding.EncodeToString(edSig), testDigest); err != nil {
+		t.Errorf("ed25519: %v", err)
+	}
+	if err := v.verifyPayload(payload, base64.StdEncoding.EncodeToString(rsaSig), testDigest); err != nil {
+		t.Errorf("rsa: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// pauseFile lists containers excluded from automatic updates.
+const pauseFile = "paused.json"
+
+// Pause describes why a container's automatic updates are suspended.
+type Pause struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason"`
+}
+
+// Pause suspends automatic updates for a container until [Store.Resume] is called.
+func (s *Store) Pause(containerName, reason string) error {
+	if s == nil {
+		return errors.New("state store is disabled")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paused, err := s.readPaused()
+	if err != nil {
+		return err
+	}
+	paused[containerName] = Pause{Since: time.Now(), Reason: reason}
+	return s.writePaused(paused)
+}
+
+// Resume re-enables automatic updates for a container. It reports whether
+// the container was paused.
+func (s *Store) Resume(containerName string) (bool, error) {
+	if s == nil {
+		return false, errors.New("state store is disabled")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paused, err := s.readPaused()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := paused[containerName]; !ok {
+		return false, nil
+	}
+	delete(paused, containerName)
+	return true, s.writePaused(paused)
+}
+
+// Paused returns the pause entry for a container, if any. Read errors are
+// treated as "not paused" so a damaged file cannot stall every update.
+func (s *Store) Paused(containerName string) (Pause, bool) {
+	if s == nil {
+		return Pause{}, false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paused, err := s.readPaused()
+	if err != nil {
+		return Pause{}, false
+	}
+	p, ok := paused[containerName]
+	return p, ok
+}
+
+// readPaused loads the pause list. Callers hold s.mu.
+func (s *Store) readPaused() (map[string]Pause, error) {
+	data, err := os.ReadFile(s.pausePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Pause{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pause list: %w", err)
+	}
+
+	paused := map[string]Pause{}
+	if err := json.Unmarshal(data, &paused); err != nil {
+		return nil, fmt.Errorf("decoding pause list: %w", err)
+	}
+	return paused, nil
+}
+
+// writePaused atomically replaces the pause list. Callers hold s.mu.
+func (s *Store) writePaused(paused map[string]Pause) error {
+	data, err := json.MarshalIndent(paused, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding pause list: %w", err)
+	}
+
+	tmp := s.pausePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing pause list: %w", err)
+	}
+	return os.Rename(tmp, s.pausePath())
+}
+
+func (s *Store) pausePath() string {
+	return filepath.Join(filepath.Dir(s.path), pauseFile)
+}
@@ -0,0 +1,82 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// seenFile tracks when each image reference's current remote digest was
+// first observed.
+const seenFile = "seen.json"
+
+// Sighting is the remote digest a tag currently points to and when Isengard
+// first saw it there.
+type Sighting struct {
+	Digest    string    `json:"digest"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// FirstSeen returns when digest was first observed as the target of image.
+// If image pointed to a different digest before (or was never seen), the
+// sighting is reset to now.
+func (s *Store) FirstSeen(image, digest string, now time.Time) (time.Time, error) {
+	if s == nil {
+		return time.Time{}, errors.New("state store is disabled")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen, err := s.readSeen()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if prev, ok := seen[image]; ok && prev.Digest == digest {
+		return prev.FirstSeen, nil
+	}
+
+	seen[image] = Sighting{Digest: digest, FirstSeen: now}
+	if err := s.writeSeen(seen); err != nil {
+		return time.Time{}, err
+	}
+	return now, nil
+}
+
+// readSeen loads the sightings. Callers hold s.mu.
+func (s *Store) readSeen() (map[string]Sighting, error) {
+	data, err := os.ReadFile(s.seenPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Sighting{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading sightings: %w", err)
+	}
+
+	seen := map[string]Sighting{}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, fmt.Errorf("decoding sightings: %w", err)
+	}
+	return seen, nil
+}
+
+// writeSeen atomically replaces the sightings. Callers hold s.mu.
+func (s *Store) writeSeen(seen map[string]Sighting) error {
+	data, err := json.MarshalIndent(seen, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding sightings: %w", err)
+	}
+
+	tmp := s.seenPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("writing sightings: %w", err)
+	}
+	return os.Rename(tmp, s.seenPath())
+}
+
+func (s *Store) seenPath() string {
+	return filepath.Join(filepath.Dir(s.path), seenFile)
+}
@@ -0,0 +1,261 @@
+// Package state persists Isengard's update history (an append-only JSON-lines
+// file) and per-container update pauses so they survive restarts and
+// self-updates.
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// fileName is the history log inside the state directory.
+const fileName = "history.jsonl"
+
+// maxRecords bounds the history file. When a store is opened with more
+// records than this, or appends take it past compactSlack more, the oldest
+// are dropped.
+const maxRecords = 10000
+
+// compactSlack is how far appends may take the log past maxRecords before it
+// is compacted, so that not every append rewrites the file.
+const compactSlack = maxRecords / 10
+
+// Kind distinguishes the events recorded in the history.
+type Kind string
+
+const (
+	// KindCheck records the result of an update check.
+	KindCheck Kind = "check"
+	// KindUpdate records a container recreation, successful or not.
+	KindUpdate Kind = "update"
+	// KindRollback records a manual rollback to a retained image.
+	KindRollback Kind = "rollback"
+)
+
+// Record is a single history entry.
+type Record struct {
+	Time        time.Time `json:"time"`
+	Kind        Kind      `json:"kind"`
+	Host        string    `json:"host,omitempty"` // Docker host, when several are managed
+	Container   string    `json:"container"`
+	ContainerID string    `json:"container_id"`
+	Image       string    `json:"image"`
+	// OldImageID is the image the container was running before the event.
+	OldImageID string `json:"old_image_id,omitempty"`
+	// NewImageID is the image pulled by a check or used by an update.
+	NewImageID   string `json:"new_image_id,omitempty"`
+	LocalDigest  string `json:"local_digest,omitempty"`
+	RemoteDigest string `json:"remote_digest,omitempty"`
+	// UpdateAvailable is set on check records that found a newer image.
+	UpdateAvailable bool `json:"update_available,omitempty"`
+	// Deferred is set when the newer image is still within its cooldown.
+	Deferred bool `json:"deferred,omitempty"`
+	// NewContainerID is the replacement container (successful updates only).
+	NewContainerID string `json:"new_container_id,omitempty"`
+	DurationMS     int64  `json:"duration_ms"`
+	Error          string `json:"error,omitempty"`
+	// Restored is set on failed updates that left the original container
+	// running again.
+	Restored bool `json:"restored,omitempty"`
+}
+
+// Filter narrows the records returned by [Store.Query].
+type Filter struct {
+	Host      string    // Match the Docker host (empty = all).
+	Container string    // Match the container name (empty = all).
+	Kind      Kind      // Match the record kind (empty = all).
+	Since     time.Time // Only records at or after this time (zero = all).
+	Limit     int       // Return at most this many of the newest matches (0 = all).
+}
+
+// Store is a file-backed history log. A nil *Store records nothing and
+// returns no history, so callers need not check whether persistence is enabled.
+type Store struct {
+	mu    sync.Mutex
+	path  string
+	lines int // records in the log, counted since the last compaction
+}
+
+// Open prepares the history log in dir, creating the directory if needed and
+// compacting the log if it has grown beyond its size limit.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating state directory: %w", err)
+	}
+
+	s := &Store{path: filepath.Join(dir, fileName)}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append writes a record to the log, compacting it once it has grown well
+// beyond its size limit. Zero times are set to now.
+func (s *Store) Append(r Record) error {
+	if s == nil {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encoding record: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing history: %w", err)
+	}
+	s.lines++
+	if s.lines > maxRecords+compactSlack {
+		return s.compact()
+	}
+	return nil
+}
+
+// Record appends r and logs, rather than returns, any failure. History is
+// best-effort and must never interrupt an update.
+func (s *Store) Record(r Record) {
+	if err := s.Append(r); err != nil {
+		slog.Warn("failed to record history", "container", r.Container, "error", err)
+	}
+}
+
+// Query returns matching records in chronological order.
+func (s *Store) Query(f Filter) ([]Record, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	records, err := s.readAll()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := records[:0]
+	for _, r := range records {
+		if f.Host != "" && r.Host != f.Host {
+			continue
+		}
+		if f.Container != "" && r.Container != f.Container {
+			continue
+		}
+		if f.Kind != "" && r.Kind != f.Kind {
+			continue
+		}
+		if !f.Since.IsZero() && r.Time.Before(f.Since) {
+			continue
+		}
+		matched = append(matched, r)
+	}
+
+	if f.Limit > 0 && len(matched) > f.Limit {
+		matched = matched[len(matched)-f.Limit:]
+	}
+	return matched, nil
+}
+
+// LastUpdate returns the most recent successful update of a container on
+// host. Like [Filter.Host], an empty host matches every host.
+func (s *Store) LastUpdate(host, containerName string) (Record, bool, error) {
+	records, err := s.Query(Filter{Host: host, Container: containerName, Kind: KindUpdate})
+	if err != nil {
+		return Record{}, false, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Error == "" {
+			return records[i], true, nil
+		}
+	}
+	return Record{}, false, nil
+}
+
+// readAll loads every record from the log. Lines that fail to decode (for
+// example a partial write after a crash) are skipped. Callers hold s.mu.
+func (s *Store) readAll() ([]Record, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening history: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading history: %w", err)
+	}
+	return records, nil
+}
+
+// compact rewrites the log with only the newest maxRecords entries and
+// resets the line count. Callers hold s.mu.
+func (s *Store) compact() error {
+	records, err := s.readAll()
+	if err != nil {
+		return err
+	}
+	if len(records) <= maxRecords {
+		s.lines = len(records)
+		return nil
+	}
+	records = records[len(records)-maxRecords:]
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("compacting history: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return fmt.Errorf("compacting history: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("compacting history: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("compacting history: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("compacting history: %w", err)
+	}
+	s.lines = len(records)
+	return nil
+}
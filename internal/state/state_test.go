@@ -0,0 +1,291 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNilStore(t *testing.T) {
+	var s *Store
+	if err := s.Append(Record{Container: "web"}); err != nil {
+		t.Errorf("nil store Append should be a no-op, got %v", err)
+	}
+	records, err := s.Query(Filter{})
+	if err != nil || records != nil {
+		t.Errorf("nil store Query: got %v, %v", records, err)
+	}
+}
+
+func TestAppendAndQuery(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Time: base, Kind: KindCheck, Container: "web", UpdateAvailable: true},
+		{Time: base.Add(time.Minute), Kind: KindUpdate, Container: "web", NewImageID: "sha256:new"},
+		{Time: base.Add(2 * time.Minute), Kind: KindCheck, Container: "db"},
+		{Time: base.Add(3 * time.Minute), Kind: KindCheck, Container: "web"},
+	}
+	for _, r := range records {
+		if err := s.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected int
+	}{
+		{"all", Filter{}, 4},
+		{"by container", Filter{Container: "web"}, 3},
+		{"by kind", Filter{Kind: KindCheck}, 3},
+		{"by container and kind", Filter{Container: "web", Kind: KindUpdate}, 1},
+		{"since", Filter{Since: base.Add(90 * time.Second)}, 2},
+		{"limit", Filter{Limit: 2}, 2},
+		{"unknown container", Filter{Container: "cache"}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Query(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.expected {
+				t.Errorf("expected %d records, got %d", tt.expected, len(got))
+			}
+		})
+	}
+
+	// Limit keeps the newest records.
+	got, err := s.Query(Filter{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !got[0].Time.Equal(base.Add(3*time.Minute)) {
+		t.Errorf("expected newest record, got %+v", got)
+	}
+}
+
+func TestAppendSetsTime(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(Record{Kind: KindCheck, Container: "web"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Time.IsZero() {
+		t.Errorf("expected record with time set, got %+v", got)
+	}
+}
+
+func TestLastUpdate(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := s.LastUpdate("", "web"); err != nil || ok {
+		t.Fatalf("expected no update yet, got ok=%v err=%v", ok, err)
+	}
+
+	s.Record(Record{Kind: KindUpdate, Host: "nas", Container: "web", NewImageID: "sha256:first"})
+	s.Record(Record{Kind: KindUpdate, Host: "nas", Container: "web", NewImageID: "sha256:second"})
+	s.Record(Record{Kind: KindUpdate, Host: "nas", Container: "web", NewImageID: "sha256:third", Error: "boom"})
+	s.Record(Record{Kind: KindUpdate, Host: "pi", Container: "web", NewImageID: "sha256:other"})
+
+	tests := []struct {
+		host   string
+		want   string
+		wantOK bool
+	}{
+		{host: "nas", want: "sha256:second", wantOK: true},
+		{host: "pi", want: "sha256:other", wantOK: true},
+		{host: "vps"},
+		{host: "", want: "sha256:other", wantOK: true},
+	}
+	for _, tt := range tests {
+		r, ok, err := s.LastUpdate(tt.host, "web")
+		if err != nil || ok != tt.wantOK {
+			t.Fatalf("LastUpdate(%q): ok=%v err=%v, want ok=%v", tt.host, ok, err, tt.wantOK)
+		}
+		if r.NewImageID != tt.want {
+			t.Errorf("LastUpdate(%q) = %s, want %s", tt.host, r.NewImageID, tt.want)
+		}
+	}
+}
+
+func TestQuerySkipsCorruptLines(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"kind":"check","container":"web"}
+{"kind":"upd
+{"kind":"update","container":"web"}
+`
+	if err := os.WriteFile(filepath.Join(dir, fileName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Errorf("expected 2 valid records, got %d", len(got))
+	}
+}
+
+func TestOpenCompacts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRecords+10; i++ {
+		if err := s.Append(Record{Kind: KindCheck, Container: "web", DurationMS: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != maxRecords {
+		t.Fatalf("expected %d records after compaction, got %d", maxRecords, len(got))
+	}
+	if got[0].DurationMS != 10 {
+		t.Errorf("expected oldest records dropped, first is %d", got[0].DurationMS)
+	}
+}
+
+func TestAppendCompacts(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := maxRecords + compactSlack + 1
+	for i := 0; i < total; i++ {
+		if err := s.Append(Record{Kind: KindCheck, Container: "web", DurationMS: int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != maxRecords {
+		t.Fatalf("expected %d records after compaction, got %d", maxRecords, len(got))
+	}
+	if first := got[0].DurationMS; first != int64(total-maxRecords) {
+		t.Errorf("expected oldest records dropped, first is %d", first)
+	}
+
+	// Appends keep going after a compaction.
+	if err := s.Append(Record{Kind: KindCheck, Container: "web", DurationMS: int64(total)}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Query(Filter{}); len(got) != maxRecords+1 || got[len(got)-1].DurationMS != int64(total) {
+		t.Errorf("expected the record appended after compaction, got %d records", len(got))
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := s.Paused("web"); ok {
+		t.Fatal("expected web not paused")
+	}
+
+	if err := s.Pause("web", "rolled back"); err != nil {
+		t.Fatal(err)
+	}
+	p, ok := s.Paused("web")
+	if !ok || p.Reason != "rolled back" || p.Since.IsZero() {
+		t.Errorf("expected web paused with reason, got %+v ok=%v", p, ok)
+	}
+	if _, ok := s.Paused("db"); ok {
+		t.Error("expected db not paused")
+	}
+
+	resumed, err := s.Resume("web")
+	if err != nil || !resumed {
+		t.Fatalf("expected resume to succeed, got %v %v", resumed, err)
+	}
+	if _, ok := s.Paused("web"); ok {
+		t.Error("expected web resumed")
+	}
+
+	resumed, err = s.Resume("web")
+	if err != nil || resumed {
+		t.Errorf("expected second resume to report not paused, got %v %v", resumed, err)
+	}
+}
+
+func TestPauseNilStore(t *testing.T) {
+	var s *Store
+	if _, ok := s.Paused("web"); ok {
+		t.Error("nil store should report nothing paused")
+	}
+	if err := s.Pause("web", "x"); err == nil {
+		t.Error("expected error pausing with nil store")
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	got, err := s.FirstSeen("nginx:latest", "sha256:a", t0)
+	if err != nil || !got.Equal(t0) {
+		t.Fatalf("first sighting: got %v, %v", got, err)
+	}
+
+	// Persisted across reopen.
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.FirstSeen("nginx:latest", "sha256:a", t0.Add(time.Hour))
+	if err != nil || !got.Equal(t0) {
+		t.Errorf("repeat sighting: got %v, %v", got, err)
+	}
+
+	// A new digest resets the sighting.
+	got, err = s.FirstSeen("nginx:latest", "sha256:b", t0.Add(2*time.Hour))
+	if err != nil || !got.Equal(t0.Add(2*time.Hour)) {
+		t.Errorf("new digest: got %v, %v", got, err)
+	}
+
+	// Other images are tracked independently.
+	got, err = s.FirstSeen("redis:7", "sha256:a", t0.Add(3*time.Hour))
+	if err != nil || !got.Equal(t0.Add(3*time.Hour)) {
+		t.Errorf("other image: got %v, %v", got, err)
+	}
+}
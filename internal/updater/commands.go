@@ -0,0 +1,203 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+// Watch describes whether a running container or Swarm service is watched
+// for updates.
+type Watch struct {
+	Container container.Info
+	// Service is set when Container describes a Swarm service.
+	Service bool
+	// Reason explains why the container is skipped; empty if it is watched.
+	Reason string
+}
+
+// List reports every running container, and every service on a Swarm
+// manager, and whether it is watched.
+func (u *Updater) List(ctx context.Context) ([]Watch, error) {
+	containers, err := u.listRunning(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	services, err := u.listServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	watches := make([]Watch, 0, len(containers)+len(services))
+	for _, c := range containers {
+		watches = append(watches, Watch{Container: c, Reason: u.skipReason(c)})
+	}
+	for _, svc := range services {
+		watches = append(watches, Watch{Container: svc, Service: true, Reason: u.skipReason(svc)})
+	}
+	return watches, nil
+}
+
+// Report is the result of checking one container or Swarm service without
+// updating it.
+type Report struct {
+	Container container.Info
+	// Service is set when Container describes a Swarm service.
+	Service         bool
+	UpdateAvailable bool
+	// Deferred is the remaining cooldown if the newer image is too young.
+	Deferred     time.Duration
+	LocalDigest  string
+	RemoteDigest string
+	Err          error
+}
+
+// Check looks for newer images for every watched container and Swarm
+// service without recreating anything. Images are only pulled when the
+// registry digest of a container's image cannot be compared and the check
+// falls back to a pull.
+func (u *Updater) Check(ctx context.Context) ([]Report, error) {
+	u.cycleMu.Lock()
+	defer u.cycleMu.Unlock()
+
+	containers, err := u.listRunning(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+	services, err := u.listServices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []container.Info
+	for _, c := range containers {
+		if !u.shouldSkip(c) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	results := u.checkAll(candidates, func(log *slog.Logger, c container.Info) (checkResult, error) {
+		start := time.Now()
+		result, err := u.checkForUpdate(ctx, log, c, false)
+		u.recordCheck(c, result, time.Since(start), err)
+		return result, err
+	})
+
+	var watchedServices []container.Info
+	for _, svc := range services {
+		if !u.shouldSkip(svc) {
+			watchedServices = append(watchedServices, svc)
+		}
+	}
+	serviceResults := u.checkAll(watchedServices, func(log *slog.Logger, svc container.Info) (checkResult, error) {
+		return u.checkService(ctx, log, svc)
+	})
+
+	reports := make([]Report, 0, len(candidates)+len(watchedServices))
+	for i, r := range results {
+		reports = append(reports, newReport(candidates[i], false, r))
+	}
+	for i, r := range serviceResults {
+		reports = append(reports, newReport(watchedServices[i], true, r))
+	}
+	return reports, nil
+}
+
+func newReport(c container.Info, service bool, r checkOutcome) Report {
+	return Report{
+		Container:       c,
+		Service:         service,
+		UpdateAvailable: r.result.needsUpdate || r.result.deferred > 0,
+		Deferred:        r.result.deferred,
+		LocalDigest:     r.result.localDigest,
+		RemoteDigest:    r.result.remoteDigest,
+		Err:             r.err,
+	}
+}
+
+// Update runs an update cycle immediately, limited to the named containers
+// or Swarm services if names is non-empty. Named containers must be running
+// and watched.
+func (u *Updater) Update(ctx context.Context, names []string) (int, error) {
+	if len(names) == 0 {
+		return u.runCycle(ctx, nil)
+	}
+
+	containers, err := u.listRunning(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing containers: %w", err)
+	}
+	services, err := u.listServices(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byName := make(map[string]container.Info, len(containers)+len(services))
+	for _, c := range append(containers, services...) {
+		byName[c.Name] = c
+	}
+
+	only := make(map[string]bool, len(names))
+	var problems []string
+	for _, name := range names {
+		c, ok := byName[name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is not running", name))
+		case u.isSelf(c.ID) && u.config.SelfUpdate && swarmService(c) == "":
+			only[name] = true
+		default:
+			if reason := u.skipReason(c); reason != "" {
+				problems = append(problems, fmt.Sprintf("%s is not watched: %s", name, reason))
+				continue
+			}
+			only[name] = true
+		}
+	}
+	if len(problems) > 0 {
+		return 0, fmt.Errorf("cannot update: %s", strings.Join(problems, "; "))
+	}
+
+	u.logger().Info("updating selected containers", "containers", names)
+	return u.runCycle(ctx, only)
+}
+
+// UpdateTargets runs an update cycle for the running containers and Swarm
+// services that are named in names or use one of the image references in
+// images. Those that are not watched are skipped as in a scheduled cycle.
+// Cached digests of the images are dropped first, since a trigger usually
+// means they changed.
+func (u *Updater) UpdateTargets(ctx context.Context, names, images []string) (int, error) {
+	containers, err := u.listRunning(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing containers: %w", err)
+	}
+	services, err := u.listServices(ctx)
+	if err != nil {
+		u.logger().Warn("could not list swarm services", "error", err)
+	}
+	containers = append(containers, services...)
+
+	refs := make([]registry.ImageRef, len(images))
+	for i, img := range images {
+		u.digests.Forget(img)
+		refs[i] = registry.ParseImageRef(img)
+	}
+
+	only := map[string]bool{}
+	for _, c := range containers {
+		if slices.Contains(names, c.Name) || slices.Contains(refs, registry.ParseImageRef(c.Image)) {
+			only[c.Name] = true
+		}
+	}
+	if len(only) == 0 {
+		u.logger().Debug("no running containers affected by trigger", "containers", names, "images", images)
+		return 0, nil
+	}
+	return u.runCycle(ctx, only)
+}
@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+// labelMinAge overrides the global minimum image age for a container.
+const labelMinAge = "isengard.min-age"
+
+// minAge returns the cooldown that applies to a container: its
+// isengard.min-age label if valid, otherwise the global setting.
+func (u *Updater) minAge(log *slog.Logger, c container.Info) time.Duration {
+	v, ok := c.Labels[labelMinAge]
+	if !ok {
+		return u.config.MinAge
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil || d < 0 {
+		log.Warn("invalid min-age label, using default", "container", c.Name, "value", v, "default", u.config.MinAge)
+		return u.config.MinAge
+	}
+	return d
+}
+
+// cooldownRemaining returns how much longer a new image (identified by key,
+// its remote digest or image ID) must wait before it may be applied, or 0 if
+// it is old enough. created reports the image's build time and is only
+// consulted when ISENGARD_MIN_AGE_SOURCE=created.
+func (u *Updater) cooldownRemaining(log *slog.Logger, c container.Info, key string, created func() (time.Time, error), now time.Time) time.Duration {
+	minAge := u.minAge(log, c)
+	if minAge <= 0 {
+		return 0
+	}
+
+	since := time.Time{}
+	if u.config.MinAgeSource == "created" {
+		t, err := created()
+		if err == nil {
+			since = t
+		} else {
+			log.Debug("could not read image creation time, using first-seen time",
+				"container", c.Name,
+				"image", c.Image,
+				"error", err,
+			)
+		}
+	}
+	if since.IsZero() {
+		since = u.firstSeen(log, c.Image, key, now)
+	}
+
+	if age := now.Sub(since); age < minAge {
+		return minAge - age
+	}
+	return 0
+}
+
+// firstSeen returns when key was first observed as the newest image for an
+// image reference. Sightings are persisted in the state store when enabled
+// and kept in memory otherwise.
+func (u *Updater) firstSeen(log *slog.Logger, image, key string, now time.Time) time.Time {
+	if u.store != nil {
+		t, err := u.store.FirstSeen(image, key, now)
+		if err == nil {
+			return t
+		}
+		log.Warn("could not persist image sighting", "image", image, "error", err)
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.seen == nil {
+		u.seen = map[string]state.Sighting{}
+	}
+	if prev, ok := u.seen[image]; ok && prev.Digest == key {
+		return prev.FirstSeen
+	}
+	u.seen[image] = state.Sighting{Digest: key, FirstSeen: now}
+	return now
+}
@@ -0,0 +1,90 @@
+package updater
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/container"
+)
+
+func TestMinAge(t *testing.T) {
+	u := &Updater{config: config.Config{MinAge: time.Hour}}
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected time.Duration
+	}{
+		{"global default", map[string]string{}, time.Hour},
+		{"label override", map[string]string{"isengard.min-age": "24h"}, 24 * time.Hour},
+		{"label disables cooldown", map[string]string{"isengard.min-age": "0s"}, 0},
+		{"invalid label", map[string]string{"isengard.min-age": "a while"}, time.Hour},
+		{"negative label", map[string]string{"isengard.min-age": "-1h"}, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := u.minAge(slog.Default(), container.Info{Name: "web", Labels: tt.labels})
+			if got != tt.expected {
+				t.Errorf("minAge(): got %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCooldownRemainingSeen(t *testing.T) {
+	u := &Updater{config: config.Config{MinAge: time.Hour, MinAgeSource: "seen"}}
+	c := container.Info{Name: "web", Image: "nginx:latest"}
+	noCreated := func() (time.Time, error) { return time.Time{}, errors.New("unused") }
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:new", noCreated, t0); got != time.Hour {
+		t.Errorf("first sighting: expected full cooldown, got %v", got)
+	}
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:new", noCreated, t0.Add(40*time.Minute)); got != 20*time.Minute {
+		t.Errorf("after 40m: expected 20m remaining, got %v", got)
+	}
+
+	// A different digest on the tag restarts the clock.
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:newer", noCreated, t0.Add(50*time.Minute)); got != time.Hour {
+		t.Errorf("new digest: expected full cooldown, got %v", got)
+	}
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:newer", noCreated, t0.Add(2*time.Hour)); got != 0 {
+		t.Errorf("after cooldown: expected 0 remaining, got %v", got)
+	}
+}
+
+func TestCooldownRemainingCreated(t *testing.T) {
+	u := &Updater{config: config.Config{MinAge: time.Hour, MinAgeSource: "created"}}
+	c := container.Info{Name: "web", Image: "nginx:latest"}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	builtLongAgo := func() (time.Time, error) { return now.Add(-48 * time.Hour), nil }
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:old", builtLongAgo, now); got != 0 {
+		t.Errorf("old image: expected no cooldown, got %v", got)
+	}
+
+	builtRecently := func() (time.Time, error) { return now.Add(-15 * time.Minute), nil }
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:fresh", builtRecently, now); got != 45*time.Minute {
+		t.Errorf("fresh image: expected 45m remaining, got %v", got)
+	}
+
+	// Falls back to the first-seen time when the build time is unavailable.
+	unavailable := func() (time.Time, error) { return time.Time{}, errors.New("registry down") }
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:unknown", unavailable, now); got != time.Hour {
+		t.Errorf("fallback: expected full cooldown, got %v", got)
+	}
+}
+
+func TestCooldownDisabled(t *testing.T) {
+	u := &Updater{config: config.Config{MinAge: 0}}
+	c := container.Info{Name: "web", Image: "nginx:latest"}
+	created := func() (time.Time, error) { return time.Now(), nil }
+
+	if got := u.cooldownRemaining(slog.Default(), c, "sha256:new", created, time.Now()); got != 0 {
+		t.Errorf("expected no cooldown when disabled, got %v", got)
+	}
+}
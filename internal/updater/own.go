@@ -0,0 +1,76 @@
+package updater
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+// ownEventGrace is added to the events debounce when deciding whether an
+// event was caused by Isengard, to allow for the time the event takes to
+// arrive.
+const ownEventGrace = 30 * time.Second
+
+// noteOwnImage records that Isengard is about to pull or tag ref, so the
+// Docker event this causes is not taken for an outside change.
+func (u *Updater) noteOwnImage(ref string) {
+	u.ownMu.Lock()
+	defer u.ownMu.Unlock()
+	if u.ownImages == nil {
+		u.ownImages = map[registry.ImageRef]time.Time{}
+	}
+	u.ownImages[registry.ParseImageRef(ref)] = time.Now()
+}
+
+// noteOwnContainer records that Isengard is about to start a container
+// named name, as it does when recreating one.
+func (u *Updater) noteOwnContainer(name string) {
+	u.ownMu.Lock()
+	defer u.ownMu.Unlock()
+	if u.ownContainers == nil {
+		u.ownContainers = map[string]time.Time{}
+	}
+	u.ownContainers[name] = time.Now()
+}
+
+// IgnoreOwn removes from the containers and images named by a Docker events
+// trigger those Isengard started, pulled or tagged itself within the last
+// debounce interval and grace period. Reacting to them would check
+// containers that were just checked, and a container whose check pulls its
+// image would be pulled again after every debounce interval.
+func (u *Updater) IgnoreOwn(names, images []string) ([]string, []string) {
+	u.ownMu.Lock()
+	defer u.ownMu.Unlock()
+
+	since := time.Now().Add(-u.config.EventsDebounce - ownEventGrace)
+	for name, at := range u.ownContainers {
+		if at.Before(since) {
+			delete(u.ownContainers, name)
+		}
+	}
+	for ref, at := range u.ownImages {
+		if at.Before(since) {
+			delete(u.ownImages, ref)
+		}
+	}
+
+	names = slices.DeleteFunc(slices.Clone(names), func(name string) bool {
+		_, ok := u.ownContainers[name]
+		return ok
+	})
+	images = slices.DeleteFunc(slices.Clone(images), func(ref string) bool {
+		_, ok := u.ownImages[registry.ParseImageRef(ref)]
+		return ok
+	})
+	return names, images
+}
+
+// pull pulls ref and returns the image ID, noting the pull as Isengard's
+// own.
+func (u *Updater) pull(ctx context.Context, ref string) (string, error) {
+	u.noteOwnImage(ref)
+	return docker.PullImage(ctx, u.cli, ref)
+}
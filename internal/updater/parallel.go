@@ -0,0 +1,118 @@
+package updater
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+// logMu serializes flushing buffered check logs, also across the updaters
+// of different hosts.
+var logMu sync.Mutex
+
+// checkOutcome is the result of one check run by [Updater.checkAll].
+type checkOutcome struct {
+	result checkResult
+	err    error
+}
+
+// checkAll runs check for every container on up to ISENGARD_CHECK_CONCURRENCY
+// workers, with at most ISENGARD_REGISTRY_CONCURRENCY checks against the same
+// registry at a time, and returns the outcomes in the order of containers.
+//
+// Each check logs to its own logger. With more than one worker, its output
+// is held back until the check finishes and then written in one piece, so
+// the lines for different containers do not interleave.
+func (u *Updater) checkAll(containers []container.Info, check func(log *slog.Logger, c container.Info) (checkResult, error)) []checkOutcome {
+	outcomes := make([]checkOutcome, len(containers))
+
+	workers := min(u.config.CheckConcurrency, len(containers))
+	if workers <= 1 {
+		for i, c := range containers {
+			outcomes[i].result, outcomes[i].err = check(u.logger(), c)
+		}
+		return outcomes
+	}
+
+	limits := map[string]chan struct{}{}
+	for _, c := range containers {
+		host := registry.ParseImageRef(c.Image).Registry
+		if limits[host] == nil {
+			limits[host] = make(chan struct{}, max(u.config.RegistryConcurrency, 1))
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				c := containers[i]
+				limit := limits[registry.ParseImageRef(c.Image).Registry]
+
+				buf := newLogBuffer(u.logger().Handler())
+				limit <- struct{}{}
+				outcomes[i].result, outcomes[i].err = check(slog.New(buf), c)
+				<-limit
+
+				logMu.Lock()
+				buf.flush()
+				logMu.Unlock()
+			}
+		}()
+	}
+	for i := range containers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return outcomes
+}
+
+// logBuffer is a [slog.Handler] that keeps records in memory until flush
+// passes them on. It is used by a single goroutine at a time.
+type logBuffer struct {
+	next    slog.Handler
+	records *[]bufferedRecord
+}
+
+type bufferedRecord struct {
+	handler slog.Handler
+	record  slog.Record
+}
+
+func newLogBuffer(next slog.Handler) *logBuffer {
+	return &logBuffer{next: next, records: new([]bufferedRecord)}
+}
+
+func (b *logBuffer) Enabled(ctx context.Context, level slog.Level) bool {
+	return b.next.Enabled(ctx, level)
+}
+
+func (b *logBuffer) Handle(_ context.Context, r slog.Record) error {
+	*b.records = append(*b.records, bufferedRecord{handler: b.next, record: r.Clone()})
+	return nil
+}
+
+func (b *logBuffer) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &logBuffer{next: b.next.WithAttrs(attrs), records: b.records}
+}
+
+func (b *logBuffer) WithGroup(name string) slog.Handler {
+	return &logBuffer{next: b.next.WithGroup(name), records: b.records}
+}
+
+// flush writes the buffered records, with their original timestamps, and
+// empties the buffer.
+func (b *logBuffer) flush() {
+	for _, br := range *b.records {
+		_ = br.handler.Handle(context.Background(), br.record)
+	}
+	*b.records = nil
+}
@@ -0,0 +1,109 @@
+package updater
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+func TestCheckAllLimits(t *testing.T) {
+	u := &Updater{config: config.Config{CheckConcurrency: 4, RegistryConcurrency: 1}}
+
+	var containers []container.Info
+	for i := range 12 {
+		image := fmt.Sprintf("ghcr.io/acme/app%d:latest", i)
+		if i%2 == 0 {
+			image = fmt.Sprintf("nginx%d:latest", i)
+		}
+		containers = append(containers, container.Info{Name: fmt.Sprintf("c%d", i), Image: image})
+	}
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	peak := map[string]int{}
+	outcomes := u.checkAll(containers, func(_ *slog.Logger, c container.Info) (checkResult, error) {
+		host := registry.ParseImageRef(c.Image).Registry
+		mu.Lock()
+		running[host]++
+		peak[host] = max(peak[host], running[host])
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mu.Lock()
+		running[host]--
+		mu.Unlock()
+		return checkResult{localDigest: c.Name}, nil
+	})
+
+	for i, o := range outcomes {
+		if o.result.localDigest != containers[i].Name {
+			t.Errorf("outcome %d belongs to %s, want %s", i, o.result.localDigest, containers[i].Name)
+		}
+	}
+	for host, n := range peak {
+		if n > 1 {
+			t.Errorf("%s: %d concurrent checks, want at most 1", host, n)
+		}
+	}
+}
+
+func TestCheckAllGroupsLogs(t *testing.T) {
+	var out bytes.Buffer
+	var outMu sync.Mutex
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(lockedWriter{&out, &outMu}, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	u := &Updater{config: config.Config{CheckConcurrency: 3, RegistryConcurrency: 3}}
+	containers := []container.Info{
+		{Name: "a", Image: "nginx:latest"},
+		{Name: "b", Image: "nginx:latest"},
+		{Name: "c", Image: "nginx:latest"},
+	}
+	u.checkAll(containers, func(log *slog.Logger, c container.Info) (checkResult, error) {
+		for i := range 3 {
+			log.Info("step", "container", c.Name, "n", i)
+			time.Sleep(time.Millisecond)
+		}
+		return checkResult{}, nil
+	})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 9 {
+		t.Fatalf("expected 9 log lines, got %d:\n%s", len(lines), out.String())
+	}
+	for i := 0; i < len(lines); i += 3 {
+		name := containerAttr(lines[i])
+		for _, line := range lines[i : i+3] {
+			if got := containerAttr(line); got != name {
+				t.Fatalf("log lines of %s and %s interleave:\n%s", name, got, out.String())
+			}
+		}
+	}
+}
+
+type lockedWriter struct {
+	w  *bytes.Buffer
+	mu *sync.Mutex
+}
+
+func (l lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+func containerAttr(line string) string {
+	_, rest, _ := strings.Cut(line, "container=")
+	name, _, _ := strings.Cut(rest, " ")
+	return name
+}
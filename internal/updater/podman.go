@@ -0,0 +1,60 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dirdmaster/isengard/internal/docker"
+)
+
+// engine returns the container engine of the host, asking the daemon the
+// first time. Docker is assumed until the daemon answers.
+func (u *Updater) engine(ctx context.Context) docker.Engine {
+	u.engineMu.Lock()
+	defer u.engineMu.Unlock()
+
+	if u.detected == "" {
+		e, err := docker.DetectEngine(ctx, u.cli)
+		if err != nil {
+			u.logger().Debug("could not detect container engine, assuming docker", "error", err)
+			return docker.EngineDocker
+		}
+		if e == docker.EnginePodman {
+			u.logger().Info("podman detected, using podman compatibility mode")
+		}
+		u.detected = e
+	}
+	return u.detected
+}
+
+// podman reports whether the host has been detected to run Podman.
+func (u *Updater) podman() bool {
+	u.engineMu.Lock()
+	defer u.engineMu.Unlock()
+	return u.detected == docker.EnginePodman
+}
+
+// parseContainerenv reads the container ID from Podman's /run/.containerenv.
+// The file exists in every Podman container but only holds key="value"
+// lines such as id="<64-char-hex>" when the container is privileged.
+func parseContainerenv(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || key != "id" {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		if containerIDPattern.MatchString(value) && len(value) == 64 {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/policy"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+// checkPolicy evaluates the update policy for a pending update and returns an
+// error if it denies the update. Each decision is logged with the rule that
+// made it.
+func (u *Updater) checkPolicy(ctx context.Context, p pendingUpdate) error {
+	if u.policy == nil {
+		return nil
+	}
+	c := p.info
+
+	var oldImage, newImage policy.Image
+	var err error
+	if p.service {
+		oldImage, newImage, err = u.servicePolicyImages(p)
+	} else {
+		oldImage, newImage, err = u.containerPolicyImages(ctx, p)
+	}
+	if err != nil {
+		return err
+	}
+
+	d := u.policy.Evaluate(policy.Input{
+		Container: policy.Container{Name: c.Name, Image: c.Image, Labels: c.Labels},
+		Image:     registry.ParseImageRef(c.Image),
+		Old:       oldImage,
+		New:       newImage,
+		Now:       time.Now(),
+	})
+
+	for _, step := range d.Steps {
+		u.logger().Debug("policy rule evaluated",
+			"container", c.Name,
+			"rule", step.Rule,
+			"matched", step.Matched,
+			"error", step.Err,
+		)
+	}
+
+	if !d.Allowed {
+		u.logger().Warn("update denied by policy",
+			"container", c.Name,
+			"image", c.Image,
+			"rule", d.Rule,
+			"reason", d.Reason,
+			"when", d.When,
+		)
+		return fmt.Errorf("policy: %s", d.Explain())
+	}
+	u.logger().Info("update allowed by policy", "container", c.Name, "decision", d.Explain())
+	return nil
+}
+
+// containerPolicyImages returns the policy metadata of a container's current
+// and newly pulled image from the local images.
+func (u *Updater) containerPolicyImages(ctx context.Context, p pendingUpdate) (policy.Image, policy.Image, error) {
+	oldImage, err := u.policyImage(ctx, p.info.ImageID, p.check.localDigest)
+	if err != nil {
+		return policy.Image{}, policy.Image{}, fmt.Errorf("inspecting current image: %w", err)
+	}
+	newImage, err := u.policyImage(ctx, p.check.newImageID, p.check.remoteDigest)
+	if err != nil {
+		return policy.Image{}, policy.Image{}, fmt.Errorf("inspecting new image: %w", err)
+	}
+	if newImage.Digest == "" {
+		info := container.Info{Image: p.info.Image, RepoDigests: newImage.repoDigests}
+		newImage.Digest = extractLocalDigest(info)
+	}
+	return oldImage.Image, newImage.Image, nil
+}
+
+// servicePolicyImages returns the policy metadata of a service's current and
+// new image from the registry, since services are not pulled on the manager.
+// The current digest may no longer be in the registry; its metadata is then
+// left out.
+func (u *Updater) servicePolicyImages(p pendingUpdate) (policy.Image, policy.Image, error) {
+	oldImage := policy.Image{Digest: p.check.localDigest}
+	if created, labels, err := registry.ImageMetadata(p.info.Image, oldImage.Digest); err == nil {
+		oldImage.Created, oldImage.Labels = created, labels
+	} else {
+		u.logger().Debug("could not read current service image from the registry", "service", p.info.Name, "digest", oldImage.Digest, "error", err)
+	}
+
+	newImage := policy.Image{Digest: p.check.remoteDigest}
+	created, labels, err := registry.ImageMetadata(p.info.Image, newImage.Digest)
+	if err != nil {
+		return policy.Image{}, policy.Image{}, fmt.Errorf("reading new image from the registry: %w", err)
+	}
+	newImage.Created, newImage.Labels = created, labels
+	return oldImage, newImage, nil
+}
+
+// policyImageInfo is an image's policy metadata plus its repo digests, used
+// to recover the digest when the check fell back to a pull.
+type policyImageInfo struct {
+	policy.Image
+	repoDigests []string
+}
+
+func (u *Updater) policyImage(ctx context.Context, imageID, digest string) (policyImageInfo, error) {
+	inspect, err := u.cli.ImageInspect(ctx, imageID)
+	if err != nil {
+		return policyImageInfo{}, err
+	}
+
+	info := policyImageInfo{
+		Image:       policy.Image{ID: imageID, Digest: digest},
+		repoDigests: inspect.RepoDigests,
+	}
+	if inspect.Config != nil {
+		info.Labels = inspect.Config.Labels
+	}
+	if created, err := time.Parse(time.RFC3339Nano, inspect.Created); err == nil {
+		info.Created = created
+	}
+	return info, nil
+}
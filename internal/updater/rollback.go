@@ -0,0 +1,211 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+// rollbackRepoPrefix namespaces the local tags that keep previous images
+// alive for [Updater.Rollback], e.g. "isengard-rollback/web:3".
+const rollbackRepoPrefix = "isengard-rollback/"
+
+// invalidRepoChars matches runs of characters not allowed in a repository
+// path component.
+var invalidRepoChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// rollbackRepository returns the local repository that holds retained images
+// for a container.
+func rollbackRepository(containerName string) string {
+	name := invalidRepoChars.ReplaceAllString(strings.ToLower(containerName), "-")
+	name = strings.Trim(name, "._-")
+	if name == "" {
+		name = "unnamed"
+	}
+	return rollbackRepoPrefix + name
+}
+
+// rollbackGenerations returns the numeric tags of a rollback repository,
+// newest first. Non-numeric tags are ignored.
+func rollbackGenerations(tags map[string]string) []int {
+	var gens []int
+	for tag := range tags {
+		if n, err := strconv.Atoi(tag); err == nil && n > 0 {
+			gens = append(gens, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(gens)))
+	return gens
+}
+
+// retainForRollback tags the image a container was running before an update
+// so it can be restored later, and drops retained images beyond the
+// configured count. Dropping the last tag of an image deletes it unless a
+// container still uses it; the retained tags are Isengard's own, so they are
+// pruned whether or not cleanup is enabled.
+func (u *Updater) retainForRollback(ctx context.Context, c container.Info) {
+	repo := rollbackRepository(c.Name)
+
+	tags, err := docker.TagsInRepository(ctx, u.cli, repo)
+	if err != nil {
+		u.logger().Warn("could not list rollback images", "container", c.Name, "error", err)
+		return
+	}
+
+	gens := rollbackGenerations(tags)
+	next := 1
+	if len(gens) > 0 {
+		next = gens[0] + 1
+	}
+
+	alreadyRetained := false
+	for _, id := range tags {
+		if id == c.ImageID {
+			alreadyRetained = true
+			break
+		}
+	}
+
+	if !alreadyRetained {
+		ref := repo + ":" + strconv.Itoa(next)
+		if err := docker.TagImage(ctx, u.cli, c.ImageID, ref); err != nil {
+			u.logger().Warn("could not retain old image for rollback", "container", c.Name, "error", err)
+			return
+		}
+		u.logger().Info("retained old image for rollback", "container", c.Name, "ref", ref)
+		gens = append([]int{next}, gens...)
+	}
+
+	if len(gens) <= u.config.RollbackKeep {
+		return
+	}
+	for _, n := range gens[u.config.RollbackKeep:] {
+		ref := repo + ":" + strconv.Itoa(n)
+		if err := docker.UntagImage(ctx, u.cli, ref); err != nil {
+			u.logger().Debug("could not remove retained image", "ref", ref, "error", err)
+		} else {
+			u.logger().Info("removed retained image", "ref", ref)
+		}
+	}
+}
+
+// Rollback recreates the named container from the most recently retained
+// previous image and pauses its automatic updates in the state store. The
+// retained image is re-tagged with the container's original image reference
+// so the container keeps its configuration. Returns the new container ID.
+func (u *Updater) Rollback(ctx context.Context, name string) (string, error) {
+	u.cycleMu.Lock()
+	defer u.cycleMu.Unlock()
+
+	if u.store == nil {
+		return "", errors.New("rollback requires ISENGARD_STATE_DIR to persist the update pause")
+	}
+
+	inspect, err := u.cli.ContainerInspect(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("inspecting container: %w", err)
+	}
+
+	c := container.Info{
+		ID:      inspect.ID,
+		Name:    strings.TrimPrefix(inspect.Name, "/"),
+		Image:   inspect.Config.Image,
+		ImageID: inspect.Image,
+	}
+	c.Labels = u.config.Labels(c.Name, c.Image, inspect.Config.Labels)
+	if strings.HasPrefix(c.Image, "sha256:") || strings.Contains(c.Image, "@") {
+		return "", fmt.Errorf("container %s uses image %q, which cannot be re-tagged", c.Name, c.Image)
+	}
+
+	repo := rollbackRepository(c.Name)
+	tags, err := docker.TagsInRepository(ctx, u.cli, repo)
+	if err != nil {
+		return "", fmt.Errorf("listing rollback images: %w", err)
+	}
+	gens := rollbackGenerations(tags)
+	if len(gens) == 0 {
+		return "", fmt.Errorf("no retained image for %s (is ISENGARD_ROLLBACK_KEEP > 0?)", c.Name)
+	}
+	retainedRef := repo + ":" + strconv.Itoa(gens[0])
+	retainedID := tags[strconv.Itoa(gens[0])]
+
+	u.logger().Info("rolling back container",
+		"container", c.Name,
+		"image", c.Image,
+		"from", c.ImageID,
+		"to", retainedID,
+	)
+
+	// Pause first: the daemon checks the pause before every update, and
+	// would otherwise update the rolled back container again as soon as it
+	// sees it.
+	key := u.qualify(c.Name)
+	previous, wasPaused := u.store.Paused(key)
+	if err := u.store.Pause(key, "rolled back to "+retainedID); err != nil {
+		return "", fmt.Errorf("pausing automatic updates: %w", err)
+	}
+	resume := func() {
+		var err error
+		if wasPaused {
+			err = u.store.Pause(key, previous.Reason)
+		} else {
+			_, err = u.store.Resume(key)
+		}
+		if err != nil {
+			u.logger().Error("could not resume automatic updates after a failed rollback", "container", c.Name, "error", err)
+		}
+	}
+
+	u.noteOwnImage(c.Image)
+	if err := docker.TagImage(ctx, u.cli, retainedID, c.Image); err != nil {
+		resume()
+		return "", fmt.Errorf("re-tagging %s as %s: %w", retainedRef, c.Image, err)
+	}
+
+	start := time.Now()
+	newID, err := u.recreate(ctx, c)
+	record := state.Record{
+		Kind:           state.KindRollback,
+		Host:           u.host,
+		Container:      c.Name,
+		ContainerID:    c.ID,
+		Image:          c.Image,
+		OldImageID:     c.ImageID,
+		NewImageID:     retainedID,
+		NewContainerID: newID,
+		DurationMS:     time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+		u.store.Record(record)
+		// Point the tag back at the image the container still runs.
+		u.noteOwnImage(c.Image)
+		if terr := docker.TagImage(ctx, u.cli, c.ImageID, c.Image); terr != nil {
+			u.logger().Error("could not restore image tag after a failed rollback", "image", c.Image, "image_id", c.ImageID, "error", terr)
+		}
+		resume()
+		return "", err
+	}
+	u.store.Record(record)
+
+	// The retained image is now referenced by the original tag; drop the
+	// rollback tag so a second rollback goes one generation further back.
+	if err := docker.UntagImage(ctx, u.cli, retainedRef); err != nil {
+		u.logger().Debug("could not remove rollback tag", "ref", retainedRef, "error", err)
+	}
+	if u.config.Cleanup {
+		docker.RemoveImage(ctx, u.cli, c.ImageID)
+	}
+
+	u.logger().Info("container rolled back, automatic updates paused", "container", c.Name, "new_id", newID[:12])
+	return newID, nil
+}
@@ -0,0 +1,152 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	containertypes "github.com/docker/docker/api/types/container"
+
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+func TestRollbackRepository(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"web", "isengard-rollback/web"},
+		{"My_App", "isengard-rollback/my_app"},
+		{"stack-db-1", "isengard-rollback/stack-db-1"},
+		{"weird name!", "isengard-rollback/weird-name"},
+		{"_leading", "isengard-rollback/leading"},
+		{"!!!", "isengard-rollback/unnamed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rollbackRepository(tt.name); got != tt.expected {
+				t.Errorf("rollbackRepository(%q): got %q, want %q", tt.name, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRollbackGenerations(t *testing.T) {
+	tags := map[string]string{
+		"1":      "sha256:a",
+		"10":     "sha256:b",
+		"2":      "sha256:c",
+		"latest": "sha256:d",
+		"0":      "sha256:e",
+	}
+
+	got := rollbackGenerations(tags)
+	if want := []int{10, 2, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rollbackGenerations(): got %v, want %v", got, want)
+	}
+
+	if got := rollbackGenerations(nil); len(got) != 0 {
+		t.Errorf("expected no generations, got %v", got)
+	}
+}
+
+func TestRetainForRollback_PrunesWithoutCleanup(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "isengard-rollback/web:1", Digest: "sha256:01"})
+	d.AddImage(fakedocker.Image{Ref: "isengard-rollback/web:2", Digest: "sha256:02"})
+	currentID := d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:03"})
+
+	u := &Updater{cli: d, config: config.Config{RollbackKeep: 2, Cleanup: false}}
+	u.retainForRollback(context.Background(), container.Info{Name: "web", Image: "nginx:1.25", ImageID: currentID})
+
+	for ref, want := range map[string]bool{
+		"isengard-rollback/web:1": false,
+		"isengard-rollback/web:2": true,
+		"isengard-rollback/web:3": true,
+	} {
+		if got := d.HasImage(ref); got != want {
+			t.Errorf("%s present = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestRollback_FailureRestoresTag(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "isengard-rollback/web:1", Digest: "sha256:01"})
+	currentID := d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:02"})
+	d.Run("web", &containertypes.Config{Image: "nginx:1.25"}, nil)
+	d.Fail("ContainerCreate", errors.New("no space left on device"))
+
+	store, err := state.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Updater{cli: d, store: store, config: config.Config{StopTimeout: 1}}
+	if _, err := u.Rollback(context.Background(), "web"); err == nil {
+		t.Fatal("Rollback succeeded despite a create failure")
+	}
+
+	img, err := d.ImageInspect(context.Background(), "nginx:1.25")
+	if err != nil || img.ID != currentID {
+		t.Errorf("nginx:1.25 = %s (%v), want the image web still runs, %s", img.ID, err, currentID)
+	}
+	if _, paused := store.Paused("web"); paused {
+		t.Error("automatic updates still paused after a failed rollback")
+	}
+}
+
+func TestRollback_PausesFirst(t *testing.T) {
+	newDaemon := func() *fakedocker.Daemon {
+		d := fakedocker.New()
+		d.AddImage(fakedocker.Image{Ref: "isengard-rollback/web:1", Digest: "sha256:01"})
+		d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: "sha256:02"})
+		d.Run("web", &containertypes.Config{Image: "nginx:1.25"}, nil)
+		return d
+	}
+
+	t.Run("rolled back", func(t *testing.T) {
+		d := newDaemon()
+		store, err := state.Open(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		u := &Updater{cli: d, store: store, config: config.Config{StopTimeout: 1}}
+		if _, err := u.Rollback(context.Background(), "web"); err != nil {
+			t.Fatalf("Rollback: %v", err)
+		}
+		if _, paused := store.Paused("web"); !paused {
+			t.Error("automatic updates not paused")
+		}
+	})
+
+	t.Run("pause cannot be written", func(t *testing.T) {
+		d := newDaemon()
+		dir := t.TempDir()
+		store, err := state.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// A directory in place of the pause file makes writing it fail.
+		if err := os.Mkdir(filepath.Join(dir, "paused.json"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		u := &Updater{cli: d, store: store, config: config.Config{StopTimeout: 1}}
+		before := len(d.Calls())
+		if _, err := u.Rollback(context.Background(), "web"); err == nil {
+			t.Fatal("Rollback succeeded without pausing automatic updates")
+		}
+		for _, call := range d.Calls()[before:] {
+			if strings.HasPrefix(call, "ImageTag") || strings.HasPrefix(call, "ContainerCreate") {
+				t.Errorf("rollback went ahead without the pause: %s", call)
+			}
+		}
+	})
+}
@@ -0,0 +1,249 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+
+	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/hooks"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+// labelSwarmService is set by Docker on the containers of Swarm tasks.
+const labelSwarmService = "com.docker.swarm.service.name"
+
+// servicePollInterval is how often a running service update is inspected.
+var servicePollInterval = 2 * time.Second
+
+// swarmService returns the name of the Swarm service a container is a task
+// of, or "" for a standalone container.
+func swarmService(c container.Info) string {
+	return c.Labels[labelSwarmService]
+}
+
+// listServices lists the Swarm services with config file overrides merged
+// into their labels. Each service is described as a [container.Info] whose ID
+// and Name are the service's, Image is the image reference without digest and
+// RepoDigests holds the digest the service is pinned to, if any. It returns
+// nothing unless the daemon is a manager of an active swarm; only managers
+// can update services.
+func (u *Updater) listServices(ctx context.Context) ([]container.Info, error) {
+	info, err := u.cli.Info(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reading daemon info: %w", err)
+	}
+	if info.Swarm.LocalNodeState != swarm.LocalNodeStateActive {
+		return nil, nil
+	}
+	if !info.Swarm.ControlAvailable {
+		u.logger().Debug("swarm worker node, services are updated through a manager")
+		return nil, nil
+	}
+
+	services, err := u.cli.ServiceList(ctx, swarm.ServiceListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("listing services: %w", err)
+	}
+
+	var infos []container.Info
+	for _, svc := range services {
+		spec := svc.Spec.TaskTemplate.ContainerSpec
+		if spec == nil {
+			continue // plugin or network attachment services have no image
+		}
+		image, digest := splitDigest(spec.Image)
+		s := container.Info{
+			ID:     svc.ID,
+			Name:   svc.Spec.Name,
+			Image:  image,
+			Labels: u.config.Labels(svc.Spec.Name, image, svc.Spec.Labels),
+		}
+		if digest != "" {
+			s.RepoDigests = []string{repository(image) + "@" + digest}
+		}
+		infos = append(infos, s)
+	}
+	return infos, nil
+}
+
+// splitDigest splits a service image such as "nginx:1.25@sha256:abc" into
+// the reference and the digest it is pinned to.
+func splitDigest(image string) (string, string) {
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		return image[:i], image[i+1:]
+	}
+	return image, ""
+}
+
+// repository strips the tag from an image reference.
+func repository(image string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[:i]
+	}
+	return image
+}
+
+// checkService compares the digest a service is pinned to with the
+// registry's and records the outcome. Unlike a container check it never
+// pulls: Swarm pulls the image on the nodes that run the service's tasks.
+func (u *Updater) checkService(ctx context.Context, log *slog.Logger, s container.Info) (checkResult, error) {
+	start := time.Now()
+	result, err := u.compareServiceDigest(ctx, log, s)
+	u.recordCheck(s, result, time.Since(start), err)
+	if err != nil {
+		log.Warn("service update check failed", "service", s.Name, "image", s.Image, "error", err)
+	}
+	return result, err
+}
+
+func (u *Updater) compareServiceDigest(ctx context.Context, log *slog.Logger, s container.Info) (checkResult, error) {
+	if len(s.RepoDigests) == 0 {
+		return checkResult{}, errors.New("service image is not pinned to a digest")
+	}
+	// Fail here rather than let checkForUpdate fall back to a pull.
+	if _, err := u.digests.CheckDigest(s.Image); err != nil {
+		return checkResult{}, fmt.Errorf("checking registry digest: %w", err)
+	}
+	return u.checkForUpdate(ctx, log, s, false)
+}
+
+// updateService points a service at the new digest, the one whose signature
+// was verified, through the service update API and follows the rollout. The rest of the spec is left alone, so
+// Swarm applies the update according to the service's update_config and
+// rolls it back if its failure_action says so. It returns an error wrapping
+// [hooks.ErrSkipped] if a host-side script vetoed the update.
+func (u *Updater) updateService(ctx context.Context, p pendingUpdate) error {
+	s := p.info
+	u.logger().Info("updating service", "service", s.Name, "image", s.Image)
+
+	data := &hooks.ContainerData{ID: s.ID, Name: s.Name, Image: s.Image}
+
+	if err := u.checkPolicy(ctx, p); err != nil {
+		u.recordUpdate(p, "", 0, err)
+		u.runPostUpdateScripts(ctx, data, "", err)
+		return err
+	}
+
+	if err := u.verifySignature(ctx, p); err != nil {
+		u.logger().Error("refusing update, signature verification failed", "service", s.Name, "image", s.Image, "error", err)
+		err = fmt.Errorf("signature verification: %w", err)
+		u.recordUpdate(p, "", 0, err)
+		u.runPostUpdateScripts(ctx, data, "", err)
+		return err
+	}
+
+	if err := u.scripts.Run(ctx, hooks.Payload{Event: hooks.EventPreUpdate, Host: u.host, Container: data}); err != nil {
+		u.logger().Warn("update skipped by host hook", "service", s.Name, "error", err)
+		return err
+	}
+
+	start := time.Now()
+	err := u.rollOutService(ctx, s, s.Image+"@"+p.check.remoteDigest)
+	u.recordUpdate(p, "", time.Since(start), err)
+	u.runPostUpdateScripts(ctx, data, "", err)
+	if err != nil {
+		u.logger().Error("failed to update service", "service", s.Name, "error", err)
+		return err
+	}
+
+	u.logger().Info("service updated", "service", s.Name, "digest", p.check.remoteDigest[:19])
+	return nil
+}
+
+// rollOutService submits the new image for a service and waits for Swarm to
+// report how the update ended.
+func (u *Updater) rollOutService(ctx context.Context, s container.Info, image string) error {
+	svc, _, err := u.cli.ServiceInspectWithRaw(ctx, s.ID, swarm.ServiceInspectOptions{})
+	if err != nil {
+		return fmt.Errorf("inspecting service: %w", err)
+	}
+
+	spec := svc.Spec
+	cs := *spec.TaskTemplate.ContainerSpec
+	cs.Image = image
+	spec.TaskTemplate.ContainerSpec = &cs
+
+	resp, err := u.cli.ServiceUpdate(ctx, svc.ID, svc.Version, spec, swarm.ServiceUpdateOptions{
+		EncodedRegistryAuth: registry.AuthForImage(s.Image),
+	})
+	if err != nil {
+		return fmt.Errorf("updating service: %w", err)
+	}
+	for _, w := range resp.Warnings {
+		u.logger().Warn("service update warning", "service", s.Name, "warning", w)
+	}
+
+	return u.waitForService(ctx, s)
+}
+
+// waitForService polls a service until Swarm reports its update as completed,
+// paused or rolled back, for at most ISENGARD_SERVICE_UPDATE_TIMEOUT. An
+// update still running when the time is up is left to Swarm and not treated
+// as a failure, unless it is being rolled back.
+func (u *Updater) waitForService(ctx context.Context, s container.Info) error {
+	ctx, cancel := context.WithTimeout(ctx, u.config.ServiceUpdateTimeout)
+	defer cancel()
+
+	var status *swarm.UpdateStatus
+	for {
+		select {
+		case <-ctx.Done():
+			if status != nil && status.State == swarm.UpdateStateRollbackStarted {
+				return fmt.Errorf("swarm is rolling the update back: %s", status.Message)
+			}
+			u.logger().Warn("service update still in progress, leaving it to swarm",
+				"service", s.Name,
+				"waited", u.config.ServiceUpdateTimeout,
+			)
+			return nil
+		case <-time.After(servicePollInterval):
+		}
+
+		svc, _, err := u.cli.ServiceInspectWithRaw(ctx, s.ID, swarm.ServiceInspectOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			return fmt.Errorf("inspecting service: %w", err)
+		}
+		if svc.UpdateStatus != nil && (status == nil || status.State != svc.UpdateStatus.State) {
+			u.logger().Info("service update status", "service", s.Name, "state", svc.UpdateStatus.State, "message", svc.UpdateStatus.Message)
+		}
+		status = svc.UpdateStatus
+
+		if err := serviceUpdateResult(status); !errors.Is(err, errUpdateRunning) {
+			return err
+		}
+	}
+}
+
+// errUpdateRunning is returned by [serviceUpdateResult] while Swarm is still
+// working on an update.
+var errUpdateRunning = errors.New("service update in progress")
+
+// serviceUpdateResult interprets a service's update status. Swarm clears the
+// status when a new spec is submitted, so nil means the update has not
+// started yet.
+func serviceUpdateResult(status *swarm.UpdateStatus) error {
+	if status == nil {
+		return errUpdateRunning
+	}
+	switch status.State {
+	case swarm.UpdateStateCompleted:
+		return nil
+	case swarm.UpdateStatePaused:
+		return fmt.Errorf("swarm paused the update: %s", status.Message)
+	case swarm.UpdateStateRollbackCompleted:
+		return fmt.Errorf("swarm rolled the update back: %s", status.Message)
+	case swarm.UpdateStateRollbackPaused:
+		return fmt.Errorf("swarm paused the rollback of the update: %s", status.Message)
+	default:
+		return errUpdateRunning
+	}
+}
@@ -0,0 +1,184 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/swarm"
+
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+	"github.com/dirdmaster/isengard/internal/fakeregistry"
+	"github.com/dirdmaster/isengard/internal/policy"
+	"github.com/dirdmaster/isengard/internal/registry"
+)
+
+func TestSplitDigest(t *testing.T) {
+	tests := []struct {
+		image  string
+		ref    string
+		digest string
+		repo   string
+	}{
+		{"nginx:1.25@sha256:abc", "nginx:1.25", "sha256:abc", "nginx"},
+		{"nginx@sha256:abc", "nginx", "sha256:abc", "nginx"},
+		{"nginx:1.25", "nginx:1.25", "", "nginx"},
+		{"registry.example.com:5000/app:v2@sha256:abc", "registry.example.com:5000/app:v2", "sha256:abc", "registry.example.com:5000/app"},
+		{"registry.example.com:5000/app", "registry.example.com:5000/app", "", "registry.example.com:5000/app"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			ref, digest := splitDigest(tt.image)
+			if ref != tt.ref || digest != tt.digest {
+				t.Errorf("splitDigest() = %q, %q, want %q, %q", ref, digest, tt.ref, tt.digest)
+			}
+			if got := repository(ref); got != tt.repo {
+				t.Errorf("repository(%q) = %q, want %q", ref, got, tt.repo)
+			}
+		})
+	}
+}
+
+func TestServiceUpdateResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  *swarm.UpdateStatus
+		running bool
+		failed  bool
+	}{
+		{"not started", nil, true, false},
+		{"updating", &swarm.UpdateStatus{State: swarm.UpdateStateUpdating}, true, false},
+		{"completed", &swarm.UpdateStatus{State: swarm.UpdateStateCompleted}, false, false},
+		{"paused", &swarm.UpdateStatus{State: swarm.UpdateStatePaused, Message: "task failed"}, false, true},
+		{"rolling back", &swarm.UpdateStatus{State: swarm.UpdateStateRollbackStarted}, true, false},
+		{"rolled back", &swarm.UpdateStatus{State: swarm.UpdateStateRollbackCompleted, Message: "task failed"}, false, true},
+		{"rollback paused", &swarm.UpdateStatus{State: swarm.UpdateStateRollbackPaused}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := serviceUpdateResult(tt.status)
+			if running := errors.Is(err, errUpdateRunning); running != tt.running {
+				t.Errorf("running = %v, want %v (err %v)", running, tt.running, err)
+			}
+			if failed := err != nil && !errors.Is(err, errUpdateRunning); failed != tt.failed {
+				t.Errorf("failed = %v, want %v (err %v)", failed, tt.failed, err)
+			}
+		})
+	}
+}
+
+// swarmManager returns a fake daemon that is the manager of a swarm running
+// a service named app on image.
+func swarmManager(image string) (*fakedocker.Daemon, string) {
+	d := fakedocker.New()
+	d.SetSwarm(swarm.Info{LocalNodeState: swarm.LocalNodeStateActive, ControlAvailable: true})
+	id := d.AddService(swarm.ServiceSpec{
+		Annotations:  swarm.Annotations{Name: "app"},
+		TaskTemplate: swarm.TaskSpec{ContainerSpec: &swarm.ContainerSpec{Image: image}},
+	})
+	return d, id
+}
+
+func TestUpdateService_Signature(t *testing.T) {
+	servicePollInterval = time.Millisecond
+	defer func() { servicePollInterval = 2 * time.Second }()
+
+	for _, signed := range []bool{true, false} {
+		t.Run(map[bool]string{true: "signed", false: "unsigned"}[signed], func(t *testing.T) {
+			reg, verifier, sign := signedRegistry(t, "team/app")
+			ref := reg.Host() + "/team/app:v1"
+			oldDigest := reg.PushImage("team/app", "v1", fakeregistry.Image{Created: time.Unix(1, 0)})
+			newDigest := reg.PushImage("team/app", "v1", fakeregistry.Image{Created: time.Unix(2, 0)})
+			if signed {
+				sign(newDigest)
+			}
+
+			d, id := swarmManager(ref + "@" + oldDigest)
+			u := &Updater{
+				cli:      d,
+				verifier: verifier,
+				config:   config.Config{WatchAll: true, CheckConcurrency: 1, ServiceUpdateTimeout: 10 * time.Second},
+				digests:  registry.NewDigestCache(time.Minute),
+			}
+			updated, err := u.RunCycle(context.Background())
+			if err != nil {
+				t.Fatalf("RunCycle: %v", err)
+			}
+
+			want, wantUpdated := ref+"@"+oldDigest, 0
+			if signed {
+				want, wantUpdated = ref+"@"+newDigest, 1
+			}
+			svc, _ := d.Service(id)
+			if got := svc.Spec.TaskTemplate.ContainerSpec.Image; updated != wantUpdated || got != want {
+				t.Errorf("updated = %d, service image = %s, want %d, %s", updated, got, wantUpdated, want)
+			}
+			if i := slices.IndexFunc(d.Calls(), func(c string) bool { return strings.HasPrefix(c, "Image") }); i >= 0 {
+				t.Errorf("service update used the local image store: %s", d.Calls()[i])
+			}
+		})
+	}
+}
+
+func TestUpdateService_Policy(t *testing.T) {
+	servicePollInterval = time.Millisecond
+	defer func() { servicePollInterval = 2 * time.Second }()
+
+	pol, err := policy.Parse([]byte(`
+rules:
+  - name: no-major
+    when: semver_major(new.version) != semver_major(old.version)
+    action: deny
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		allowed bool
+	}{
+		{"minor", "1.1.0", true},
+		{"major", "2.0.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := fakeregistry.New()
+			defer reg.Close()
+			registry.SetEndpoints(map[string]string{reg.Host(): reg.URL()})
+			defer registry.SetEndpoints(nil)
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+			ref := reg.Host() + "/team/app:1"
+			version := func(v string) map[string]string { return map[string]string{"org.opencontainers.image.version": v} }
+			oldDigest := reg.PushImage("team/app", "1", fakeregistry.Image{Created: time.Unix(1, 0), Labels: version("1.0.0")})
+			newDigest := reg.PushImage("team/app", "1", fakeregistry.Image{Created: time.Unix(2, 0), Labels: version(tt.version)})
+
+			d, id := swarmManager(ref + "@" + oldDigest)
+			u := &Updater{
+				cli:     d,
+				policy:  pol,
+				config:  config.Config{WatchAll: true, CheckConcurrency: 1, ServiceUpdateTimeout: 10 * time.Second},
+				digests: registry.NewDigestCache(time.Minute),
+			}
+			if _, err := u.RunCycle(context.Background()); err != nil {
+				t.Fatalf("RunCycle: %v", err)
+			}
+
+			want := ref + "@" + oldDigest
+			if tt.allowed {
+				want = ref + "@" + newDigest
+			}
+			if svc, _ := d.Service(id); svc.Spec.TaskTemplate.ContainerSpec.Image != want {
+				t.Errorf("service image = %s, want %s", svc.Spec.TaskTemplate.ContainerSpec.Image, want)
+			}
+		})
+	}
+}
@@ -4,54 +4,166 @@ package updater
 import (
 	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	containertypes "github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 
 	"github.com/dirdmaster/isengard/internal/config"
 	"github.com/dirdmaster/isengard/internal/container"
 	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/hooks"
+	"github.com/dirdmaster/isengard/internal/policy"
 	"github.com/dirdmaster/isengard/internal/registry"
+	"github.com/dirdmaster/isengard/internal/signature"
+	"github.com/dirdmaster/isengard/internal/state"
 )
 
 const (
 	labelEnable   = "isengard.enable"
+	labelVerify   = "isengard.verify"
 	oldSelfSuffix = "-old"
 )
 
 // Updater watches running containers for newer images and recreates them
 // in-place, preserving ports, volumes, networks, labels, and restart policies.
 type Updater struct {
-	cli    *client.Client
-	config config.Config
-	selfID string
+	cli      docker.API
+	host     string
+	config   config.Config
+	selfID   string
+	scripts  *hooks.Scripts
+	store    *state.Store
+	verifier *signature.Verifier
+	policy   *policy.Policy
+	digests  *registry.DigestCache
+
+	// cycleMu is held for the duration of a cycle or rollback so that
+	// [Updater.Reload] only swaps the configuration between them.
+	cycleMu sync.Mutex
+
+	// mu guards seen, the in-memory image sightings used for cooldowns
+	// when no state store is configured.
+	mu   sync.Mutex
+	seen map[string]state.Sighting
+
+	// engineMu guards detected, the container engine of the host once
+	// known.
+	engineMu sync.Mutex
+	detected docker.Engine
+
+	// ownMu guards ownImages and ownContainers, the images Isengard pulled
+	// or tagged and the containers it started, see [Updater.IgnoreOwn].
+	ownMu         sync.Mutex
+	ownImages     map[registry.ImageRef]time.Time
+	ownContainers map[string]time.Time
 }
 
-// New configures an [Updater] and detects whether it is running inside
-// a container so it can exclude itself from update checks.
-func New(cli *client.Client, cfg config.Config) *Updater {
-	return &Updater{
-		cli:    cli,
-		config: cfg,
-		selfID: detectSelfID(),
+// New configures an [Updater] for the Docker host cli talks to and detects
+// whether it is running inside a container so it can exclude itself from
+// update checks. host names the Docker host in logs, history and hook
+// payloads; it is empty when only the local daemon is managed. Check results
+// and updates are recorded in store, which may be nil to disable history.
+// Remote digests are looked up through digests, which may be shared between
+// updaters for different hosts or be nil.
+func New(cli docker.API, host string, cfg config.Config, store *state.Store, digests *registry.DigestCache) (*Updater, error) {
+	u := &Updater{
+		cli:     cli,
+		host:    host,
+		selfID:  detectSelfID(),
+		store:   store,
+		digests: digests,
+	}
+	if err := u.apply(cfg); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Host returns the name of the Docker host the updater manages.
+func (u *Updater) Host() string {
+	return u.host
+}
+
+// logger returns the default logger, labeled with the Docker host when
+// several are managed. It is looked up on every use because a configuration
+// reload may replace the default logger.
+func (u *Updater) logger() *slog.Logger {
+	if u.host == "" {
+		return slog.Default()
+	}
+	return slog.Default().With("host", u.host)
+}
+
+// qualify returns the name a container is known by across hosts, used for
+// its pause entry: "host/name", or just the name for the local daemon.
+func (u *Updater) qualify(name string) string {
+	if u.host == "" {
+		return name
 	}
+	return u.host + "/" + name
+}
+
+// Reload replaces the configuration, waiting for a running cycle to finish
+// first. If the new signature keys or policy cannot be loaded, the current
+// configuration is kept. The state store is not reopened.
+func (u *Updater) Reload(cfg config.Config) error {
+	u.cycleMu.Lock()
+	defer u.cycleMu.Unlock()
+	return u.apply(cfg)
 }
 
-// CleanupOldSelf removes any leftover container from a previous self-update.
+// apply loads everything derived from cfg and only then installs it, so a
+// failure leaves the updater unchanged.
+func (u *Updater) apply(cfg config.Config) error {
+	verifier, err := signature.NewVerifier(cfg.VerifyKeys)
+	if err != nil {
+		return fmt.Errorf("loading signature keys: %w", err)
+	}
+	pol, err := policy.Load(cfg.PolicyFile)
+	if err != nil {
+		return fmt.Errorf("loading update policy: %w", err)
+	}
+
+	var aliases map[string]string
+	if cfg.RegistriesConf != "" {
+		if aliases, err = registry.LoadShortNames(cfg.RegistriesConf); err != nil {
+			return fmt.Errorf("loading short-name aliases: %w", err)
+		}
+	}
+
+	creds := make(map[string]registry.Credential, len(cfg.RegistryAuth))
+	for host, c := range cfg.RegistryAuth {
+		creds[host] = registry.Credential{Username: c.Username, Password: c.Password}
+	}
+	registry.SetCredentials(creds)
+	registry.SetShortNames(aliases)
+
+	u.config = cfg
+	u.scripts = hooks.NewScripts(cfg.HooksDir, cfg.HookTimeout, hooks.FailurePolicy(cfg.HookFailure))
+	u.verifier = verifier
+	u.policy = pol
+	return nil
+}
+
+// CleanupOldSelf stops and removes the container of a previous self-update.
 // During self-update, the old container is renamed to "{name}-old" before the
-// replacement is created. The force-remove at the end may not complete because
-// our process is killed. This method cleans up any such leftover on startup.
+// replacement is created, and keeps running until the replacement calls this
+// method on startup. It is stopped like any replaced container, honoring its
+// stop signal and timeout, so the old process can shut down cleanly.
 func (u *Updater) CleanupOldSelf(ctx context.Context) {
 	if u.selfID == "" {
 		return
 	}
 
-	containers, err := container.ListRunning(ctx, u.cli)
+	containers, err := u.listRunning(ctx)
 	if err != nil {
 		return
 	}
@@ -71,9 +183,15 @@ func (u *Updater) CleanupOldSelf(ctx context.Context) {
 	oldName := selfName + oldSelfSuffix
 	for _, c := range containers {
 		if c.Name == oldName {
-			slog.Info("removing leftover container from previous self-update", "container", c.Name)
+			u.logger().Info("removing container from previous self-update", "container", c.Name)
+			if inspect, err := u.cli.ContainerInspect(ctx, c.ID); err == nil {
+				labels := u.config.Labels(selfName, c.Image, inspect.Config.Labels)
+				if err := container.Stop(ctx, u.cli, inspect, labels, u.config.StopTimeout); err != nil {
+					u.logger().Warn("failed to stop old self container, forcing remove", "container", c.Name, "error", err)
+				}
+			}
 			if err := u.cli.ContainerRemove(ctx, c.ID, containertypes.RemoveOptions{Force: true}); err != nil {
-				slog.Warn("failed to remove old self container", "container", c.Name, "error", err)
+				u.logger().Warn("failed to remove old self container", "container", c.Name, "error", err)
 			} else if u.config.Cleanup {
 				docker.RemoveImage(ctx, u.cli, c.ImageID)
 			}
@@ -90,113 +208,359 @@ func (u *Updater) CleanupOldSelf(ctx context.Context) {
 //
 // Returns the number of containers updated and any error.
 func (u *Updater) RunCycle(ctx context.Context) (int, error) {
-	containers, err := container.ListRunning(ctx, u.cli)
+	return u.runCycle(ctx, nil)
+}
+
+// runCycle runs an update cycle over the running containers, or only those
+// named in only if it is non-nil.
+func (u *Updater) runCycle(ctx context.Context, only map[string]bool) (int, error) {
+	u.cycleMu.Lock()
+	defer u.cycleMu.Unlock()
+
+	if err := u.scripts.Run(ctx, hooks.Payload{Event: hooks.EventCycleStart, Host: u.host}); err != nil {
+		u.logger().Warn("cycle skipped by host hook", "error", err)
+		return 0, nil
+	}
+
+	containers, err := u.listRunning(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("listing containers: %w", err)
 	}
 
-	slog.Info("starting update cycle", "containers_found", len(containers))
+	services, err := u.listServices(ctx)
+	if err != nil {
+		u.logger().Warn("could not list swarm services", "error", err)
+	}
+
+	u.logger().Info("starting update cycle", "containers_found", len(containers))
 
 	// Filter — separate self from other candidates
 	var candidates []container.Info
 	var selfContainer *container.Info
 	for _, c := range containers {
-		if u.isSelf(c.ID) {
+		if only != nil && !only[c.Name] {
+			continue
+		}
+		if u.isSelf(c.ID) && swarmService(c) == "" {
 			if u.config.SelfUpdate {
 				cc := c // copy for pointer stability
 				selfContainer = &cc
-				slog.Debug("found self, deferring update check", "container", c.Name)
+				u.logger().Debug("found self, deferring update check", "container", c.Name)
 			} else {
-				slog.Debug("skipping self", "container", c.Name)
+				u.logger().Debug("skipping self", "container", c.Name)
 			}
 			continue
 		}
-		// Skip leftover containers from a previous self-update. These are
-		// renamed to "{name}-old" during RecreateSelf and may still be
-		// running if the force-remove didn't complete before our process died.
-		if strings.HasSuffix(c.Name, oldSelfSuffix) && u.selfID != "" {
-			slog.Debug("skipping old self-update leftover", "container", c.Name)
-			continue
-		}
 		if u.shouldSkip(c) {
 			continue
 		}
 		candidates = append(candidates, c)
 	}
 
-	slog.Info("checking for updates", "candidates", len(candidates))
+	var watchedServices []container.Info
+	for _, svc := range services {
+		if only != nil && !only[svc.Name] {
+			continue
+		}
+		if u.shouldSkip(svc) {
+			continue
+		}
+		watchedServices = append(watchedServices, svc)
+	}
+
+	u.logger().Info("checking for updates", "candidates", len(candidates), "services", len(watchedServices))
 
-	// Check each candidate using hybrid digest approach
-	var toUpdate []container.Info
-	oldImageIDs := map[string]string{}
+	// Check candidates concurrently using the hybrid digest approach
+	results := u.checkAll(candidates, func(log *slog.Logger, c container.Info) (checkResult, error) {
+		if err := hooks.Run(ctx, u.cli, c.ID, c.Name, c.Labels, hooks.PreCheck, u.config.HookTimeout); err != nil {
+			log.Warn("pre-check hook failed, skipping container", "container", c.Name, "error", err)
+			return checkResult{}, nil
+		}
 
-	for _, c := range candidates {
-		needsUpdate, err := u.checkForUpdate(ctx, c)
+		start := time.Now()
+		result, err := u.checkForUpdate(ctx, log, c, true)
+		u.recordCheck(c, result, time.Since(start), err)
 		if err != nil {
-			slog.Warn("update check failed", "container", c.Name, "image", c.Image, "error", err)
-			continue
+			log.Warn("update check failed", "container", c.Name, "image", c.Image, "error", err)
+		}
+		return result, err
+	})
+
+	// Update containers that have newer images, one at a time
+	var toUpdate []pendingUpdate
+	for i, r := range results {
+		if r.err == nil && r.result.needsUpdate {
+			toUpdate = append(toUpdate, pendingUpdate{info: candidates[i], check: r.result})
 		}
+	}
 
-		if needsUpdate {
-			oldImageIDs[c.ID] = c.ImageID
-			toUpdate = append(toUpdate, c)
+	serviceResults := u.checkAll(watchedServices, func(log *slog.Logger, svc container.Info) (checkResult, error) {
+		return u.checkService(ctx, log, svc)
+	})
+	for i, r := range serviceResults {
+		if r.err == nil && r.result.needsUpdate {
+			toUpdate = append(toUpdate, pendingUpdate{info: watchedServices[i], check: r.result, service: true})
 		}
 	}
+	checked := len(candidates) + len(watchedServices)
 
-	// Update containers that have newer images
-	updated := 0
+	updated, skipped := 0, 0
 	if len(toUpdate) > 0 {
-		slog.Info("updating containers", "count", len(toUpdate))
-
-		for _, c := range toUpdate {
-			slog.Info("updating container", "container", c.Name, "image", c.Image)
+		u.logger().Info("updating containers", "count", len(toUpdate))
 
-			newID, err := container.Recreate(ctx, u.cli, c.ID, c.Image, u.config.StopTimeout)
-			if err != nil {
-				slog.Error("failed to update container", "container", c.Name, "error", err)
-				continue
+		for _, p := range toUpdate {
+			var err error
+			if p.service {
+				err = u.updateService(ctx, p)
+			} else {
+				err = u.update(ctx, p)
 			}
-
-			slog.Info("container updated",
-				"container", c.Name,
-				"old_id", c.ID[:12],
-				"new_id", newID[:12],
-			)
-			updated++
-
-			if u.config.Cleanup {
-				if oldID, ok := oldImageIDs[c.ID]; ok {
-					docker.RemoveImage(ctx, u.cli, oldID)
-				}
+			switch {
+			case errors.Is(err, hooks.ErrSkipped):
+				skipped++
+			case err == nil:
+				updated++
 			}
 		}
 
-		slog.Info("update cycle complete",
-			"checked", len(candidates),
+		u.logger().Info("update cycle complete",
+			"checked", checked,
 			"updated", updated,
-			"failed", len(toUpdate)-updated,
+			"skipped", skipped,
+			"failed", len(toUpdate)-updated-skipped,
 		)
 	} else {
-		slog.Info("all containers up to date")
+		u.logger().Info("all containers up to date")
 	}
 
+	_ = u.scripts.Run(ctx, hooks.Payload{
+		Event: hooks.EventCycleEnd,
+		Host:  u.host,
+		Cycle: &hooks.CycleData{
+			Checked: checked,
+			Updated: updated,
+			Failed:  len(toUpdate) - updated - skipped,
+		},
+	})
+
 	// Self-update runs last, after all other containers are handled.
-	// This calls Recreate on our own container, which will kill this process.
-	// The new container starts from the updated image and takes over.
+	// The new container starts from the updated image and takes over;
+	// trySelfUpdate returns once it has stopped this one.
 	if selfContainer != nil {
 		if err := u.trySelfUpdate(ctx, *selfContainer); err != nil {
-			slog.Error("self-update failed", "error", err)
+			u.logger().Error("self-update failed", "error", err)
 		}
-		// If trySelfUpdate succeeded, we won't reach here — the process is dead.
 	}
 
 	return updated, nil
 }
 
+// pendingUpdate is a container or Swarm service whose check found a newer
+// image.
+type pendingUpdate struct {
+	info    container.Info
+	check   checkResult
+	service bool
+}
+
+// update recreates a single container from its newly pulled image, running
+// lifecycle hooks around the recreation and recording the outcome. It returns
+// an error wrapping [hooks.ErrSkipped] if a host-side script vetoed the update.
+func (u *Updater) update(ctx context.Context, p pendingUpdate) error {
+	c := p.info
+	u.logger().Info("updating container", "container", c.Name, "image", c.Image)
+
+	data := &hooks.ContainerData{ID: c.ID, Name: c.Name, Image: c.Image, ImageID: c.ImageID}
+
+	if err := u.checkPolicy(ctx, p); err != nil {
+		u.recordUpdate(p, "", 0, err)
+		u.runPostUpdateScripts(ctx, data, "", err)
+		return err
+	}
+
+	if err := u.verifySignature(ctx, p); err != nil {
+		u.logger().Error("refusing update, signature verification failed", "container", c.Name, "image", c.Image, "error", err)
+		err = fmt.Errorf("signature verification: %w", err)
+		u.recordUpdate(p, "", 0, err)
+		u.runPostUpdateScripts(ctx, data, "", err)
+		return err
+	}
+
+	if err := u.scripts.Run(ctx, hooks.Payload{Event: hooks.EventPreUpdate, Host: u.host, Container: data}); err != nil {
+		u.logger().Warn("update skipped by host hook", "container", c.Name, "error", err)
+		return err
+	}
+
+	start := time.Now()
+	newID, err := u.recreate(ctx, c)
+	u.recordUpdate(p, newID, time.Since(start), err)
+	u.runPostUpdateScripts(ctx, data, newID, err)
+	if err != nil {
+		return err
+	}
+
+	u.logger().Info("container updated",
+		"container", c.Name,
+		"old_id", c.ID[:12],
+		"new_id", newID[:12],
+	)
+
+	switch {
+	case u.config.RollbackKeep > 0:
+		u.retainForRollback(ctx, c)
+	case u.config.Cleanup:
+		docker.RemoveImage(ctx, u.cli, c.ImageID)
+	}
+	return nil
+}
+
+// verifySignature checks the signature of the image a pending update would
+// apply, unless verification is disabled globally or for the container. A
+// service's image is never pulled here, so only the registry digest it will
+// be pointed at is verified.
+func (u *Updater) verifySignature(ctx context.Context, p pendingUpdate) error {
+	if u.verifier == nil {
+		return nil
+	}
+	c := p.info
+	if strings.EqualFold(c.Labels[labelVerify], "false") {
+		u.logger().Debug("signature verification disabled by label", "container", c.Name)
+		return nil
+	}
+
+	if p.service {
+		if p.check.remoteDigest == "" {
+			return fmt.Errorf("no registry digest to verify")
+		}
+		if err := u.verifier.Verify(c.Image, p.check.remoteDigest); err != nil {
+			return err
+		}
+		u.logger().Info("signature verified", "service", c.Name, "image", c.Image, "digest", p.check.remoteDigest[:19])
+		return nil
+	}
+
+	inspect, err := u.cli.ImageInspect(ctx, p.check.newImageID)
+	if err != nil {
+		return fmt.Errorf("inspecting new image: %w", err)
+	}
+
+	// The pull fallback does not know the remote digest; recover it from the
+	// pulled image's RepoDigests. Otherwise the image was pulled by tag after
+	// the digest was looked up, and must be the image with that digest.
+	digest := p.check.remoteDigest
+	if digest == "" {
+		digest = extractLocalDigest(container.Info{Image: c.Image, RepoDigests: inspect.RepoDigests})
+		if digest == "" {
+			return fmt.Errorf("new image has no repo digest to verify")
+		}
+	} else if !hasRepoDigest(inspect.RepoDigests, digest) {
+		return fmt.Errorf("pulled image is not %s, the tag moved during the update", digest)
+	}
+
+	if err := u.verifier.Verify(c.Image, digest); err != nil {
+		return err
+	}
+
+	// The container is recreated from the tag, which must still name the
+	// verified image.
+	tagged, err := u.cli.ImageInspect(ctx, c.Image)
+	if err != nil {
+		return fmt.Errorf("inspecting %s: %w", c.Image, err)
+	}
+	if tagged.ID != p.check.newImageID {
+		return fmt.Errorf("%s no longer names the verified image %s", c.Image, digest)
+	}
+	u.logger().Info("signature verified", "container", c.Name, "image", c.Image, "digest", digest[:19])
+	return nil
+}
+
+// recreate runs the in-container pre-update hook, recreates the container and
+// runs the post-update hook in the replacement.
+func (u *Updater) recreate(ctx context.Context, c container.Info) (string, error) {
+	if err := hooks.Run(ctx, u.cli, c.ID, c.Name, c.Labels, hooks.PreUpdate, u.config.HookTimeout); err != nil {
+		u.logger().Error("pre-update hook failed, aborting update", "container", c.Name, "error", err)
+		return "", err
+	}
+
+	u.noteOwnContainer(c.Name)
+	newID, err := container.Recreate(ctx, u.cli, c.ID, c.Image, c.Labels, u.config.StopTimeout, u.engine(ctx))
+	if errors.Is(err, container.ErrRestored) {
+		u.logger().Error("failed to update container, original restored", "container", c.Name, "error", err)
+		return "", err
+	}
+	if err != nil {
+		u.logger().Error("failed to update container", "container", c.Name, "error", err)
+		return "", err
+	}
+
+	if err := hooks.Run(ctx, u.cli, newID, c.Name, c.Labels, hooks.PostUpdate, u.config.HookTimeout); err != nil {
+		u.logger().Error("post-update hook failed", "container", c.Name, "error", err)
+	}
+	return newID, nil
+}
+
+// recordCheck stores the outcome of an update check in the history.
+func (u *Updater) recordCheck(c container.Info, result checkResult, took time.Duration, checkErr error) {
+	r := state.Record{
+		Kind:            state.KindCheck,
+		Host:            u.host,
+		Container:       c.Name,
+		ContainerID:     c.ID,
+		Image:           c.Image,
+		OldImageID:      c.ImageID,
+		NewImageID:      result.newImageID,
+		LocalDigest:     result.localDigest,
+		RemoteDigest:    result.remoteDigest,
+		UpdateAvailable: result.needsUpdate || result.deferred > 0,
+		Deferred:        result.deferred > 0,
+		DurationMS:      took.Milliseconds(),
+	}
+	if checkErr != nil {
+		r.Error = checkErr.Error()
+	}
+	u.store.Record(r)
+}
+
+// recordUpdate stores the outcome of a container recreation in the history.
+func (u *Updater) recordUpdate(p pendingUpdate, newID string, took time.Duration, updateErr error) {
+	r := state.Record{
+		Kind:           state.KindUpdate,
+		Host:           u.host,
+		Container:      p.info.Name,
+		ContainerID:    p.info.ID,
+		Image:          p.info.Image,
+		OldImageID:     p.info.ImageID,
+		NewImageID:     p.check.newImageID,
+		LocalDigest:    p.check.localDigest,
+		RemoteDigest:   p.check.remoteDigest,
+		NewContainerID: newID,
+		DurationMS:     took.Milliseconds(),
+	}
+	if updateErr != nil {
+		r.Error = updateErr.Error()
+		r.Restored = errors.Is(updateErr, container.ErrRestored)
+	}
+	u.store.Record(r)
+}
+
+// runPostUpdateScripts notifies host-side scripts of the outcome of a single
+// container update.
+func (u *Updater) runPostUpdateScripts(ctx context.Context, data *hooks.ContainerData, newID string, updateErr error) {
+	result := *data
+	result.NewID = newID
+	if updateErr != nil {
+		result.Error = updateErr.Error()
+		result.Restored = errors.Is(updateErr, container.ErrRestored)
+	}
+	_ = u.scripts.Run(ctx, hooks.Payload{Event: hooks.EventPostUpdate, Host: u.host, Container: &result})
+}
+
 // trySelfUpdate checks if Isengard's own container has a newer image and
-// recreates it if so. This is the last operation in a cycle because Recreate
-// will stop and remove our own container, killing this process. The new
-// container starts from the updated image.
+// recreates it if so. This is the last operation in a cycle because the
+// replacement stops our own container as soon as it starts, ending this
+// process. The new container starts from the updated image, which must be
+// allowed by the update policy and pass signature verification like any
+// other update.
 func (u *Updater) trySelfUpdate(ctx context.Context, self container.Info) error {
 	// Docker's container list API may resolve Image to a sha256 ref when the
 	// local tag has been updated (e.g. a newer image was pulled or built with
@@ -205,7 +569,7 @@ func (u *Updater) trySelfUpdate(ctx context.Context, self container.Info) error
 	if strings.HasPrefix(self.Image, "sha256:") {
 		inspect, err := u.cli.ContainerInspect(ctx, self.ID)
 		if err == nil && !strings.HasPrefix(inspect.Config.Image, "sha256:") {
-			slog.Debug("self-update: recovered image ref from config",
+			u.logger().Debug("self-update: recovered image ref from config",
 				"was", self.Image[:19],
 				"now", inspect.Config.Image,
 			)
@@ -213,112 +577,205 @@ func (u *Updater) trySelfUpdate(ctx context.Context, self container.Info) error
 		}
 	}
 
-	needsUpdate, err := u.checkForUpdate(ctx, self)
+	result, err := u.checkForUpdate(ctx, u.logger(), self, true)
 	if err != nil {
 		return fmt.Errorf("checking self for update: %w", err)
 	}
 
-	if !needsUpdate {
-		slog.Debug("self is up to date", "image", self.Image)
+	if !result.needsUpdate {
+		u.logger().Debug("self is up to date", "image", self.Image)
 		return nil
 	}
 
-	slog.Info("self-update available, recreating isengard",
+	p := pendingUpdate{info: self, check: result}
+	data := &hooks.ContainerData{ID: self.ID, Name: self.Name, Image: self.Image, ImageID: self.ImageID}
+
+	if err := u.checkPolicy(ctx, p); err != nil {
+		u.recordUpdate(p, "", 0, err)
+		u.runPostUpdateScripts(ctx, data, "", err)
+		return err
+	}
+
+	if err := u.verifySignature(ctx, p); err != nil {
+		u.logger().Error("refusing self-update, signature verification failed", "container", self.Name, "image", self.Image, "error", err)
+		err = fmt.Errorf("signature verification: %w", err)
+		u.recordUpdate(p, "", 0, err)
+		u.runPostUpdateScripts(ctx, data, "", err)
+		return err
+	}
+
+	u.logger().Info("self-update available, recreating isengard",
 		"container", self.Name,
 		"image", self.Image,
 	)
 
-	// Use an independent context. RecreateSelf will rename us, create and
-	// start the replacement, then force-remove our container (killing this
-	// process). The replacement must already be running before we die.
+	// Use an independent context, so that a shutdown does not leave us
+	// renamed without a running replacement.
 	recreateCtx := context.Background()
 
-	_, err = container.RecreateSelf(recreateCtx, u.cli, self.ID, self.Image, u.config.StopTimeout)
+	_, err = container.RecreateSelf(recreateCtx, u.cli, self.ID, self.Image, u.engine(ctx))
 	if err != nil {
 		return fmt.Errorf("self-update: %w", err)
 	}
 
-	// If we reach here, something unexpected happened — RecreateSelf should
-	// have killed this process by force-removing our container.
-	slog.Warn("self-update: process still running after recreate")
+	u.awaitHandover(ctx, self)
 	return nil
 }
 
+// selfHandoverTimeout bounds how long the old instance waits for its
+// replacement to stop it after a self-update.
+var selfHandoverTimeout = 2 * time.Minute
+
+// awaitHandover blocks until the replacement started by a self-update stops
+// this container, see CleanupOldSelf, which cancels ctx through the stop
+// signal. Meanwhile no other cycle runs, so two instances never update the
+// host at once. If the replacement has not stopped us in time, for instance
+// because it cannot tell which container is its own, the old container is
+// removed, ending this process.
+func (u *Updater) awaitHandover(ctx context.Context, self container.Info) {
+	u.logger().Info("self-update: waiting for the replacement to take over", "timeout", selfHandoverTimeout)
+	timer := time.NewTimer(selfHandoverTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	u.logger().Warn("self-update: replacement did not take over, removing this container", "container", self.Name)
+	if err := u.cli.ContainerRemove(context.Background(), self.ID, containertypes.RemoveOptions{Force: true}); err != nil {
+		u.logger().Error("self-update: failed to remove this container", "container", self.Name, "error", err)
+	}
+}
+
+// checkResult describes the outcome of an update check for one container.
+type checkResult struct {
+	// needsUpdate is true when a newer image is available; it has been
+	// pulled unless the check was made with pull=false.
+	needsUpdate bool
+	// localDigest and remoteDigest are empty when the check fell back to a pull.
+	localDigest  string
+	remoteDigest string
+	// newImageID is the image ID of the pulled image, if a pull happened.
+	newImageID string
+	// deferred is the remaining cooldown when a newer image exists but is
+	// younger than the configured minimum age.
+	deferred time.Duration
+}
+
 // checkForUpdate determines whether a container has a newer image available.
 // It first tries the fast registry digest check, and falls back to pull-and-compare
-// if the digest check fails.
-func (u *Updater) checkForUpdate(ctx context.Context, c container.Info) (bool, error) {
+// if the digest check fails. When pull is false a newer image found by the
+// digest check is reported but not pulled. Progress is logged to log.
+func (u *Updater) checkForUpdate(ctx context.Context, log *slog.Logger, c container.Info, pull bool) (checkResult, error) {
 	// Try fast digest check first
-	slog.Debug("checking digest", "container", c.Name, "image", c.Image)
+	log.Debug("checking digest", "container", c.Name, "image", c.Image)
 
-	remoteDigest, err := registry.CheckDigest(c.Image)
+	remoteDigest, err := u.digests.CheckDigest(c.Image)
 	if err != nil {
 		// Digest check failed — fall back to pull-and-compare
-		slog.Debug("digest check failed, falling back to pull",
+		log.Debug("digest check failed, falling back to pull",
 			"container", c.Name,
 			"image", c.Image,
 			"error", err,
 		)
-		return u.pullAndCompare(ctx, c)
+		return u.pullAndCompare(ctx, log, c)
 	}
 
 	// Compare remote digest against local RepoDigests
 	localDigest := extractLocalDigest(c)
 	if localDigest == "" {
 		// No local digest available — must pull to check
-		slog.Debug("no local digest available, falling back to pull",
+		log.Debug("no local digest available, falling back to pull",
 			"container", c.Name,
 			"image", c.Image,
 		)
-		return u.pullAndCompare(ctx, c)
+		return u.pullAndCompare(ctx, log, c)
 	}
 
+	result := checkResult{localDigest: localDigest, remoteDigest: remoteDigest}
+
 	if remoteDigest == localDigest {
-		slog.Debug("image up to date (digest match)",
+		log.Debug("image up to date (digest match)",
 			"container", c.Name,
 			"image", c.Image,
 			"digest", remoteDigest[:19],
 		)
-		return false, nil
+		return result, nil
 	}
 
-	// Digest differs — pull the new image so it's available for recreate
-	slog.Info("update available (digest mismatch)",
+	log.Info("update available (digest mismatch)",
 		"container", c.Name,
 		"image", c.Image,
 		"local", localDigest[:19],
 		"remote", remoteDigest[:19],
 	)
 
-	_, err = docker.PullImage(ctx, u.cli, c.Image)
+	created := func() (time.Time, error) { return registry.ImageCreated(c.Image, remoteDigest) }
+	if wait := u.cooldownRemaining(log, c, remoteDigest, created, time.Now()); wait > 0 {
+		log.Info("update deferred, image is younger than minimum age",
+			"container", c.Name,
+			"image", c.Image,
+			"remote", remoteDigest[:19],
+			"remaining", wait.Round(time.Second),
+		)
+		result.deferred = wait
+		return result, nil
+	}
+
+	if !pull {
+		result.needsUpdate = true
+		return result, nil
+	}
+
+	// Digest differs — pull the new image so it's available for recreate
+
+	result.newImageID, err = u.pull(ctx, c.Image)
 	if err != nil {
-		return false, fmt.Errorf("pulling updated image: %w", err)
+		return result, fmt.Errorf("pulling updated image: %w", err)
 	}
 
-	return true, nil
+	result.needsUpdate = true
+	return result, nil
 }
 
 // pullAndCompare is the fallback method: pull the image and compare image IDs.
-func (u *Updater) pullAndCompare(ctx context.Context, c container.Info) (bool, error) {
-	slog.Debug("pulling image", "container", c.Name, "image", c.Image)
+func (u *Updater) pullAndCompare(ctx context.Context, log *slog.Logger, c container.Info) (checkResult, error) {
+	log.Debug("pulling image", "container", c.Name, "image", c.Image)
 
-	newImageID, err := docker.PullImage(ctx, u.cli, c.Image)
+	newImageID, err := u.pull(ctx, c.Image)
 	if err != nil {
-		return false, fmt.Errorf("pulling image: %w", err)
+		return checkResult{}, fmt.Errorf("pulling image: %w", err)
 	}
 
+	result := checkResult{newImageID: newImageID}
+
 	if newImageID != c.ImageID {
-		slog.Info("update available (pull comparison)",
+		log.Info("update available (pull comparison)",
 			"container", c.Name,
 			"image", c.Image,
 			"old_id", c.ImageID[:12],
 			"new_id", newImageID[:12],
 		)
-		return true, nil
+
+		created := func() (time.Time, error) { return docker.ImageCreated(ctx, u.cli, newImageID) }
+		if wait := u.cooldownRemaining(log, c, newImageID, created, time.Now()); wait > 0 {
+			log.Info("update deferred, image is younger than minimum age",
+				"container", c.Name,
+				"image", c.Image,
+				"new_id", newImageID[:12],
+				"remaining", wait.Round(time.Second),
+			)
+			result.deferred = wait
+			return result, nil
+		}
+
+		result.needsUpdate = true
+		return result, nil
 	}
 
-	slog.Debug("image up to date (pull comparison)", "container", c.Name, "image", c.Image)
-	return false, nil
+	log.Debug("image up to date (pull comparison)", "container", c.Name, "image", c.Image)
+	return result, nil
 }
 
 // extractLocalDigest extracts the digest from a container's RepoDigests.
@@ -374,23 +831,80 @@ func extractLocalDigest(c container.Info) string {
 	return ""
 }
 
+// hasRepoDigest reports whether an entry of repoDigests, "repo@digest", has
+// the given manifest digest.
+func hasRepoDigest(repoDigests []string, digest string) bool {
+	return slices.ContainsFunc(repoDigests, func(rd string) bool {
+		return strings.HasSuffix(rd, "@"+digest)
+	})
+}
+
+// listRunning lists the running containers with config file overrides
+// merged into their labels.
+func (u *Updater) listRunning(ctx context.Context) ([]container.Info, error) {
+	u.engine(ctx)
+	containers, err := container.ListRunning(ctx, u.cli)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range containers {
+		containers[i].Labels = u.config.Labels(c.Name, c.Image, c.Labels)
+	}
+	return containers, nil
+}
+
 // shouldSkip returns true if a container should be excluded from updates.
+func (u *Updater) shouldSkip(c container.Info) bool {
+	if reason := u.skipReason(c); reason != "" {
+		u.logger().Debug("skipping container", "container", c.Name, "reason", reason)
+		return true
+	}
+	return false
+}
+
+// skipReason explains why a container is excluded from updates, or returns
+// "" if it is watched.
 //
 // When WatchAll is true (default): all containers are watched unless labeled
 // isengard.enable=false. When WatchAll is false (opt-in mode): only containers
 // labeled isengard.enable=true are watched.
-func (u *Updater) shouldSkip(c container.Info) bool {
+func (u *Updater) skipReason(c container.Info) string {
 	// Skip self — detectSelfID may return a 12-char hostname (short ID)
 	// while c.ID is the full 64-char container ID, so check prefix too.
 	if u.isSelf(c.ID) {
-		slog.Debug("skipping self", "container", c.Name)
-		return true
+		if svc := swarmService(c); svc != "" {
+			return "isengard itself (task of swarm service " + svc + ", updated through the service)"
+		}
+		if u.config.SelfUpdate {
+			return "isengard itself (self-updated at the end of each cycle)"
+		}
+		return "isengard itself"
+	}
+
+	// Skip Swarm task containers; recreating them would fight the
+	// orchestrator. Their service is checked instead.
+	if svc := swarmService(c); svc != "" {
+		return "task of swarm service " + svc + " (updated through the service)"
+	}
+
+	// Skip the container of a previous self-update. It is renamed to
+	// "{name}-old" during RecreateSelf and runs until the replacement stops
+	// it.
+	if strings.HasSuffix(c.Name, oldSelfSuffix) && u.selfID != "" {
+		return "leftover from a previous self-update"
+	}
+
+	// Skip containers whose automatic updates were paused by a rollback
+	if p, ok := u.store.Paused(u.qualify(c.Name)); ok {
+		return fmt.Sprintf("paused since %s: %s", p.Since.Local().Format(time.DateTime), p.Reason)
 	}
 
 	// Skip containers with no pullable image ref
 	if c.Image == "" || strings.HasPrefix(c.Image, "sha256:") {
-		slog.Debug("skipping container with no pullable image", "container", c.Name, "image", c.Image)
-		return true
+		return "no pullable image reference"
+	}
+	if u.podman() && strings.HasPrefix(c.Image, "localhost/") {
+		return "locally built podman image (localhost/)"
 	}
 
 	val, hasLabel := c.Labels[labelEnable]
@@ -398,18 +912,16 @@ func (u *Updater) shouldSkip(c container.Info) bool {
 	if u.config.WatchAll {
 		// Watch-all mode (default): skip only if explicitly disabled
 		if hasLabel && strings.EqualFold(val, "false") {
-			slog.Debug("skipping disabled container", "container", c.Name)
-			return true
+			return "disabled by " + labelEnable + "=false"
 		}
-		return false
+		return ""
 	}
 
 	// Opt-in mode: skip unless explicitly enabled
 	if hasLabel && strings.EqualFold(val, "true") {
-		return false
+		return ""
 	}
-	slog.Debug("skipping container (opt-in mode, not enabled)", "container", c.Name)
-	return true
+	return "opt-in mode and not labeled " + labelEnable + "=true"
 }
 
 // isSelf returns true if the given container ID matches Isengard's own container.
@@ -427,6 +939,12 @@ var containerIDPattern = regexp.MustCompile(`[a-f0-9]{64}`)
 // detectSelfID tries to detect our own container ID.
 // It tries three methods in order, returning the first successful result.
 func detectSelfID() string {
+	// Method 0: Podman writes the container ID to /run/.containerenv for
+	// privileged containers.
+	if id := parseContainerenv("/run/.containerenv"); id != "" {
+		return id
+	}
+
 	// Method 1: hostname is often the short container ID.
 	// Docker sets it to the first 12 hex chars of the container ID by default,
 	// but docker compose overrides it to the service name, so we validate
@@ -1,12 +1,34 @@
 package updater
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
+
+	containertypes "github.com/docker/docker/api/types/container"
 
 	"github.com/dirdmaster/isengard/internal/config"
 	"github.com/dirdmaster/isengard/internal/container"
+	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/events"
+	"github.com/dirdmaster/isengard/internal/fakedocker"
+	"github.com/dirdmaster/isengard/internal/fakeregistry"
+	"github.com/dirdmaster/isengard/internal/policy"
+	"github.com/dirdmaster/isengard/internal/registry"
+	"github.com/dirdmaster/isengard/internal/signature"
+	"github.com/dirdmaster/isengard/internal/state"
 )
 
 func TestIsSelf(t *testing.T) {
@@ -147,6 +169,84 @@ func TestShouldSkipLabelCaseInsensitive(t *testing.T) {
 	}
 }
 
+func TestShouldSkipPaused(t *testing.T) {
+	store, err := state.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Pause("nginx", "rolled back"); err != nil {
+		t.Fatal(err)
+	}
+
+	u := &Updater{config: config.Config{WatchAll: true}, store: store}
+
+	paused := container.Info{ID: "ff00", Name: "nginx", Image: "nginx:latest", Labels: map[string]string{}}
+	if !u.shouldSkip(paused) {
+		t.Error("expected shouldSkip=true for paused container")
+	}
+
+	other := container.Info{ID: "ff01", Name: "redis", Image: "redis:latest", Labels: map[string]string{}}
+	if u.shouldSkip(other) {
+		t.Error("expected shouldSkip=false for container that is not paused")
+	}
+}
+
+func TestSkipReason(t *testing.T) {
+	selfFullID := "aabbccddee11aabbccddee11aabbccddee11aabbccddee11aabbccddee11aabb"
+	otherID := "ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00ff00"
+
+	tests := []struct {
+		name     string
+		watchAll bool
+		c        container.Info
+		want     string
+	}{
+		{"watched", true, container.Info{ID: otherID, Name: "nginx", Image: "nginx"}, ""},
+		{"self", true, container.Info{ID: selfFullID, Name: "isengard", Image: "isengard"}, "isengard itself"},
+		{"old self", true, container.Info{ID: otherID, Name: "isengard-old", Image: "isengard"}, "leftover from a previous self-update"},
+		{"no image", true, container.Info{ID: otherID, Name: "x", Image: "sha256:abc"}, "no pullable image reference"},
+		{
+			"disabled", true,
+			container.Info{ID: otherID, Name: "nginx", Image: "nginx", Labels: map[string]string{"isengard.enable": "false"}},
+			"disabled by isengard.enable=false",
+		},
+		{"opt-in", false, container.Info{ID: otherID, Name: "nginx", Image: "nginx"}, "opt-in mode and not labeled isengard.enable=true"},
+		{
+			"swarm task", true,
+			container.Info{ID: otherID, Name: "web.1.x7f", Image: "nginx", Labels: map[string]string{"com.docker.swarm.service.name": "web"}},
+			"task of swarm service web (updated through the service)",
+		},
+		{
+			"self as swarm task", true,
+			container.Info{ID: selfFullID, Name: "isengard.1.x7f", Image: "isengard", Labels: map[string]string{"com.docker.swarm.service.name": "isengard"}},
+			"isengard itself (task of swarm service isengard, updated through the service)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &Updater{selfID: "aabbccddee11", config: config.Config{WatchAll: tt.watchAll}}
+			if got := u.skipReason(tt.c); got != tt.want {
+				t.Errorf("skipReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkipReasonPodmanLocalImage(t *testing.T) {
+	c := container.Info{ID: "ff00ff00ff00", Name: "app", Image: "localhost/app:dev"}
+
+	u := &Updater{config: config.Config{WatchAll: true}, detected: docker.EngineDocker}
+	if got := u.skipReason(c); got != "" {
+		t.Errorf("docker: skipReason() = %q, want watched", got)
+	}
+
+	u.detected = docker.EnginePodman
+	if got, want := u.skipReason(c), "locally built podman image (localhost/)"; got != want {
+		t.Errorf("podman: skipReason() = %q, want %q", got, want)
+	}
+}
+
 func TestIsHex(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -232,9 +332,475 @@ func TestParseMountinfo(t *testing.T) {
 	}
 }
 
+func TestParseContainerenv(t *testing.T) {
+	id := "0bb7fda0c98c1f9200b374f681f1bb7a08b60dc56dc3c22fc25cfbcf42b7720b"
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "privileged container",
+			content:  "engine=\"podman-5.2.2\"\nname=\"isengard\"\nid=\"" + id + "\"\nimage=\"ghcr.io/dirdmaster/isengard:latest\"\nrootless=0\n",
+			expected: id,
+		},
+		{name: "unprivileged container", content: "", expected: ""},
+		{name: "short id", content: "id=\"0bb7fda0c98c\"\n", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), ".containerenv")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if got := parseContainerenv(path); got != tt.expected {
+				t.Errorf("parseContainerenv(): got %q, want %q", got, tt.expected)
+			}
+		})
+	}
+
+	if got := parseContainerenv("/nonexistent/.containerenv"); got != "" {
+		t.Errorf("expected empty string for nonexistent file, got %q", got)
+	}
+}
+
 func TestParseMountinfoNonexistentFile(t *testing.T) {
 	got := parseMountinfo("/nonexistent/path/mountinfo")
 	if got != "" {
 		t.Errorf("expected empty string for nonexistent file, got %q", got)
 	}
 }
+
+func TestRunCycle(t *testing.T) {
+	const (
+		webOld = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		webNew = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		dbCur  = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	)
+
+	d := fakedocker.New()
+	oldWebImage := d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: webOld})
+	d.AddImage(fakedocker.Image{Ref: "postgres:16", Digest: dbCur})
+	d.Publish(fakedocker.Image{Ref: "nginx:1.25", Digest: webNew})
+	d.AddNetwork("backend")
+
+	webID := d.Run("web", &containertypes.Config{Image: "nginx:1.25"}, nil, "backend")
+	dbID := d.Run("db", &containertypes.Config{Image: "postgres:16"}, nil, "backend")
+	pinnedID := d.Run("pinned", &containertypes.Config{
+		Image:  "nginx:1.25",
+		Labels: map[string]string{"isengard.enable": "false"},
+	}, nil)
+
+	remote := map[string]string{"nginx:1.25": webNew, "postgres:16": dbCur}
+	u := &Updater{
+		cli:    d,
+		config: config.Config{WatchAll: true, Cleanup: true, StopTimeout: 1, CheckConcurrency: 1},
+		digests: registry.NewDigestCacheFunc(time.Minute, func(ref string) (string, error) {
+			if digest, ok := remote[ref]; ok {
+				return digest, nil
+			}
+			return "", fmt.Errorf("unknown image %s", ref)
+		}),
+	}
+
+	updated, err := u.RunCycle(context.Background())
+	if err != nil {
+		t.Fatalf("RunCycle: %v", err)
+	}
+	if updated != 1 {
+		t.Errorf("updated = %d, want 1", updated)
+	}
+
+	web, ok := d.Container("web")
+	if !ok {
+		t.Fatal("web is gone")
+	}
+	if web.ID == webID || !web.State.Running {
+		t.Errorf("web was not recreated and started")
+	}
+	if web.Image == oldWebImage {
+		t.Errorf("web still runs the old image")
+	}
+	if _, ok := web.NetworkSettings.Networks["backend"]; !ok {
+		t.Errorf("web lost its network")
+	}
+
+	if db, _ := d.Container("db"); db.ID != dbID {
+		t.Errorf("up-to-date db was recreated")
+	}
+	if pinned, _ := d.Container("pinned"); pinned.ID != pinnedID {
+		t.Errorf("excluded container was recreated")
+	}
+
+	// The pinned container still uses the old image, so cleanup keeps it.
+	if !d.HasImage(oldWebImage) {
+		t.Errorf("old image removed while still in use")
+	}
+}
+
+func TestRunCycle_Registry(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(r *fakeregistry.Registry)
+	}{
+		{name: "digest check", setup: func(r *fakeregistry.Registry) { r.RequireToken("", "") }},
+		{name: "pull fallback", setup: func(r *fakeregistry.Registry) { r.Fail("/manifests/", http.StatusBadGateway) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := fakeregistry.New()
+			defer reg.Close()
+			registry.SetEndpoints(map[string]string{reg.Host(): reg.URL()})
+			defer registry.SetEndpoints(nil)
+			t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+			ref := reg.Host() + "/team/app:v1"
+			oldDigest := reg.PushImage("team/app", "v1", fakeregistry.Image{Created: time.Unix(1, 0)})
+			newDigest := reg.PushImage("team/app", "v1", fakeregistry.Image{Created: time.Unix(2, 0)})
+			tt.setup(reg)
+
+			d := fakedocker.New()
+			d.AddImage(fakedocker.Image{Ref: ref, Digest: oldDigest})
+			d.Publish(fakedocker.Image{Ref: ref, Digest: newDigest})
+			oldID := d.Run("app", &containertypes.Config{Image: ref}, nil)
+
+			u := &Updater{
+				cli:     d,
+				config:  config.Config{WatchAll: true, StopTimeout: 1, CheckConcurrency: 1},
+				digests: registry.NewDigestCache(time.Minute),
+			}
+			updated, err := u.RunCycle(context.Background())
+			if err != nil {
+				t.Fatalf("RunCycle: %v", err)
+			}
+			if updated != 1 {
+				t.Errorf("updated = %d, want 1", updated)
+			}
+			if c, _ := d.Container("app"); c.ID == oldID {
+				t.Errorf("app was not recreated")
+			}
+		})
+	}
+}
+
+func TestRunCycle_StopOverride(t *testing.T) {
+	const (
+		oldDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		newDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "nginx:1.25", Digest: oldDigest})
+	d.Publish(fakedocker.Image{Ref: "nginx:1.25", Digest: newDigest})
+	webID := d.Run("web", &containertypes.Config{Image: "nginx:1.25"}, nil)
+
+	u := &Updater{
+		cli: d,
+		config: config.Config{
+			WatchAll: true, StopTimeout: 1, CheckConcurrency: 1,
+			Overrides: []config.Override{{Name: "web", StopTimeout: "42", StopSignal: "SIGQUIT"}},
+		},
+		digests: registry.NewDigestCacheFunc(time.Minute, func(string) (string, error) { return newDigest, nil }),
+	}
+	if _, err := u.RunCycle(context.Background()); err != nil {
+		t.Fatalf("RunCycle: %v", err)
+	}
+
+	if !slices.Contains(d.Calls(), "ContainerKill "+webID+" SIGQUIT") {
+		t.Error("stop_signal override not sent")
+	}
+	if opts, ok := d.StopOptions(webID); !ok || opts.Timeout == nil || *opts.Timeout != 42 {
+		t.Errorf("stopped with %+v, want the stop_timeout override of 42s", opts)
+	}
+}
+
+func TestCleanupOldSelf(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "isengard"})
+	selfID := d.Run("isengard", &containertypes.Config{Image: "isengard"}, nil)
+	oldID := d.Run("isengard-old", &containertypes.Config{Image: "isengard", StopSignal: "SIGINT"}, nil)
+
+	u := &Updater{cli: d, selfID: selfID[:12], config: config.Config{
+		StopTimeout: 7,
+		Overrides:   []config.Override{{Name: "isengard", StopTimeout: "9"}},
+	}}
+	u.CleanupOldSelf(context.Background())
+
+	if names := d.ContainerNames(); !slices.Equal(names, []string{"isengard"}) {
+		t.Errorf("containers = %v, want [isengard]", names)
+	}
+	opts, ok := d.StopOptions(oldID)
+	if !ok || opts.Signal != "SIGINT" || opts.Timeout == nil || *opts.Timeout != 9 {
+		t.Errorf("old container not stopped gracefully: %+v", opts)
+	}
+}
+
+func TestAwaitHandover(t *testing.T) {
+	defer func(d time.Duration) { selfHandoverTimeout = d }(selfHandoverTimeout)
+	selfHandoverTimeout = 10 * time.Millisecond
+
+	t.Run("replacement takes over", func(t *testing.T) {
+		d := fakedocker.New()
+		d.AddImage(fakedocker.Image{Ref: "isengard"})
+		selfID := d.Run("isengard-old", &containertypes.Config{Image: "isengard"}, nil)
+		u := &Updater{cli: d}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel() // The stop signal from the replacement.
+		u.awaitHandover(ctx, container.Info{ID: selfID, Name: "isengard-old"})
+		if _, ok := d.Container(selfID); !ok {
+			t.Error("old container removed although the replacement took over")
+		}
+	})
+
+	t.Run("replacement never takes over", func(t *testing.T) {
+		d := fakedocker.New()
+		d.AddImage(fakedocker.Image{Ref: "isengard"})
+		selfID := d.Run("isengard-old", &containertypes.Config{Image: "isengard"}, nil)
+		u := &Updater{cli: d}
+
+		u.awaitHandover(context.Background(), container.Info{ID: selfID, Name: "isengard-old"})
+		if _, ok := d.Container(selfID); ok {
+			t.Error("old container left running")
+		}
+	})
+}
+
+func TestIgnoreOwn_FallbackPull(t *testing.T) {
+	d := fakedocker.New()
+	d.AddImage(fakedocker.Image{Ref: "app:1"})
+	d.Publish(fakedocker.Image{Ref: "app:1"})
+	d.Run("app", &containertypes.Config{Image: "app:1"}, nil)
+
+	u := &Updater{
+		cli:    d,
+		config: config.Config{WatchAll: true, StopTimeout: 1, CheckConcurrency: 1, EventsDebounce: 20 * time.Millisecond},
+		digests: registry.NewDigestCacheFunc(time.Minute, func(string) (string, error) {
+			return "", errors.New("registry unavailable")
+		}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	triggers := events.Watch(ctx, d, u.config.EventsDebounce)
+	for !slices.Contains(d.Calls(), "Events") {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The image has no repo digest, so the check pulls it.
+	if _, err := u.RunCycle(ctx); err != nil {
+		t.Fatalf("RunCycle: %v", err)
+	}
+	var trigger events.Trigger
+	select {
+	case trigger = <-triggers:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the pull caused no events trigger")
+	}
+	if !slices.Contains(trigger.Images, "app:1") {
+		t.Fatalf("trigger = %+v, want the pull of app:1", trigger)
+	}
+
+	names, images := u.IgnoreOwn(trigger.Containers, trigger.Images)
+	if len(names) > 0 || len(images) > 0 {
+		t.Errorf("own pull not ignored: containers %v, images %v", names, images)
+	}
+
+	// Changes made by others still trigger a check.
+	if _, images := u.IgnoreOwn(nil, []string{"app:1", "redis:7"}); !slices.Equal(images, []string{"redis:7"}) {
+		t.Errorf("images = %v, want [redis:7]", images)
+	}
+}
+
+// signedRegistry starts a fake registry and returns it with a verifier
+// trusting a new signing key and a function that stores a cosign signature
+// of a digest in repo.
+func signedRegistry(t *testing.T, repo string) (*fakeregistry.Registry, *signature.Verifier, func(digest string)) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := signature.NewVerifier([]string{keyPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg := fakeregistry.New()
+	registry.SetEndpoints(map[string]string{reg.Host(): reg.URL()})
+	t.Cleanup(func() {
+		registry.SetEndpoints(nil)
+		reg.Close()
+	})
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+
+	sign := func(digest string) {
+		payload := []byte(`{"critical":{"identity":{"docker-reference":"` + reg.Host() + "/" + repo + `"},` +
+			`"image":{"docker-manifest-digest":"` + digest + `"},` +
+			`"type":"cosign container image signature"},"optional":null}`)
+		manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+			`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":2},`+
+			`"layers":[{"mediaType":"application/vnd.dev.cosign.simplesigning.v1+json","digest":%q,"size":%d,`+
+			`"annotations":{"dev.cosignproject.cosign/signature":%q}}]}`,
+			reg.PutBlob(repo, []byte("{}")), reg.PutBlob(repo, payload), len(payload),
+			base64.StdEncoding.EncodeToString(ed25519.Sign(priv, payload)))
+		reg.PutManifest(repo, signature.SignatureTag(digest), "application/vnd.oci.image.manifest.v1+json", []byte(manifest))
+	}
+	return reg, verifier, sign
+}
+
+func TestVerifySignature_TagMoved(t *testing.T) {
+	const (
+		verified = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+		other    = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	)
+	tests := []struct {
+		name    string
+		setup   func(d *fakedocker.Daemon, ref string) string // Returns the pulled image ID.
+		wantErr string
+	}{
+		{
+			name: "verified",
+			setup: func(d *fakedocker.Daemon, ref string) string {
+				return d.AddImage(fakedocker.Image{Ref: ref, Digest: verified})
+			},
+		},
+		{
+			name: "pulled image is not the checked digest",
+			setup: func(d *fakedocker.Daemon, ref string) string {
+				return d.AddImage(fakedocker.Image{Ref: ref, Digest: other})
+			},
+			wantErr: "the tag moved during the update",
+		},
+		{
+			name: "tag moved after the pull",
+			setup: func(d *fakedocker.Daemon, ref string) string {
+				id := d.AddImage(fakedocker.Image{Ref: ref, Digest: verified})
+				d.AddImage(fakedocker.Image{Ref: ref, Digest: other})
+				return id
+			},
+			wantErr: "no longer names the verified image",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg, verifier, sign := signedRegistry(t, "team/app")
+			sign(verified)
+			ref := reg.Host() + "/team/app:1"
+			d := fakedocker.New()
+			u := &Updater{cli: d, verifier: verifier}
+			p := pendingUpdate{
+				info:  container.Info{Name: "app", Image: ref},
+				check: checkResult{remoteDigest: verified, newImageID: tt.setup(d, ref)},
+			}
+
+			err := u.verifySignature(context.Background(), p)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verifySignature: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verifySignature error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelfUpdate_Signature(t *testing.T) {
+	defer func(d time.Duration) { selfHandoverTimeout = d }(selfHandoverTimeout)
+	selfHandoverTimeout = time.Millisecond
+
+	for _, signed := range []bool{true, false} {
+		t.Run(map[bool]string{true: "signed", false: "unsigned"}[signed], func(t *testing.T) {
+			reg, verifier, sign := signedRegistry(t, "team/isengard")
+			ref := reg.Host() + "/team/isengard:1"
+			oldDigest := reg.PushImage("team/isengard", "1", fakeregistry.Image{Created: time.Unix(1, 0)})
+			newDigest := reg.PushImage("team/isengard", "1", fakeregistry.Image{Created: time.Unix(2, 0)})
+			if signed {
+				sign(newDigest)
+			}
+
+			d := fakedocker.New()
+			d.AddImage(fakedocker.Image{Ref: ref, Digest: oldDigest})
+			d.Publish(fakedocker.Image{Ref: ref, Digest: newDigest})
+			selfID := d.Run("isengard", &containertypes.Config{Image: ref}, nil)
+
+			u := &Updater{
+				cli:      d,
+				selfID:   selfID[:12],
+				verifier: verifier,
+				config:   config.Config{SelfUpdate: true, StopTimeout: 1, CheckConcurrency: 1},
+				digests:  registry.NewDigestCache(time.Minute),
+			}
+			if _, err := u.RunCycle(context.Background()); err != nil {
+				t.Fatalf("RunCycle: %v", err)
+			}
+
+			c, _ := d.Container("isengard")
+			if replaced := c.ID != selfID; replaced != signed {
+				t.Errorf("self replaced = %v, want %v (containers %v)", replaced, signed, d.ContainerNames())
+			}
+		})
+	}
+}
+
+func TestSelfUpdate_Policy(t *testing.T) {
+	defer func(d time.Duration) { selfHandoverTimeout = d }(selfHandoverTimeout)
+	selfHandoverTimeout = time.Millisecond
+
+	tests := []struct {
+		name    string
+		action  string
+		replace bool
+	}{
+		{"allowed", "allow", true},
+		{"denied", "deny", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pol, err := policy.Parse([]byte("rules:\n  - name: self\n    when: container.name == \"isengard\"\n    action: " + tt.action + "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			const (
+				oldDigest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+				newDigest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+			)
+			d := fakedocker.New()
+			d.AddImage(fakedocker.Image{Ref: "isengard:1", Digest: oldDigest})
+			d.Publish(fakedocker.Image{Ref: "isengard:1", Digest: newDigest})
+			selfID := d.Run("isengard", &containertypes.Config{Image: "isengard:1"}, nil)
+
+			u := &Updater{
+				cli:     d,
+				selfID:  selfID[:12],
+				policy:  pol,
+				config:  config.Config{SelfUpdate: true, StopTimeout: 1, CheckConcurrency: 1},
+				digests: registry.NewDigestCacheFunc(time.Minute, func(string) (string, error) { return newDigest, nil }),
+			}
+			if _, err := u.RunCycle(context.Background()); err != nil {
+				t.Fatalf("RunCycle: %v", err)
+			}
+
+			c, _ := d.Container("isengard")
+			if replaced := c.ID != selfID; replaced != tt.replace {
+				t.Errorf("self replaced = %v, want %v", replaced, tt.replace)
+			}
+		})
+	}
+}
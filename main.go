@@ -1,136 +1,253 @@
 package main
 
 import (
-	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
-	"os/signal"
-	"syscall"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/docker/docker/client"
 	"github.com/muesli/termenv"
 
 	"github.com/dirdmaster/isengard/internal/config"
 	"github.com/dirdmaster/isengard/internal/docker"
+	"github.com/dirdmaster/isengard/internal/registry"
+	"github.com/dirdmaster/isengard/internal/state"
 	"github.com/dirdmaster/isengard/internal/updater"
 )
 
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+// errUpdatesPending makes `isengard check` exit with status 2.
+var errUpdatesPending = errors.New("updates pending")
+
+// command is a CLI subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"daemon", "watch containers and update them on a schedule (default)", runDaemon},
+	{"check", "report pending updates; exits 2 if there are any", runCheck},
+	{"update", "update all or the named containers now: update [name...]", runUpdate},
+	{"list", "list running containers and whether each is watched", runList},
+	{"history", "show recorded checks and updates", runHistory},
+	{"rollback", "restore a container's previous image: rollback <name>", runRollback},
+	{"resume", "re-enable updates paused by a rollback: resume <name>", runResume},
+	{"version", "print the version", runVersion},
+}
+
 func main() {
-	if err := run(); err != nil {
+	err := dispatch(os.Args[1:])
+	var invalid *config.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		// Logging is not set up yet; print one problem per line.
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	case errors.Is(err, errUpdatesPending):
+		os.Exit(2)
+	case errors.Is(err, flag.ErrHelp):
+		os.Exit(0)
+	case err != nil:
 		slog.Error("fatal", "error", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	cfg := config.Load()
-
-	// Setup pretty logging via charmbracelet/log
-	logger := log.NewWithOptions(os.Stdout, log.Options{
-		Level:           log.Level(cfg.LogLevel),
-		ReportTimestamp: true,
-	})
-	// Force color output — Docker containers have no TTY so charm
-	// disables colors by default. This sets it on the logger's own renderer.
-	logger.SetColorProfile(termenv.TrueColor)
-	slog.SetDefault(slog.New(logger))
-
-	slog.Info("starting isengard",
-		"interval", cfg.Interval,
-		"run_once", cfg.RunOnce,
-		"cleanup", cfg.Cleanup,
-		"stop_timeout", cfg.StopTimeout,
-		"self_update", cfg.SelfUpdate,
-	)
-
-	cli, err := docker.NewClient()
-	if err != nil {
-		return fmt.Errorf("creating Docker client: %w", err)
+// dispatch runs the subcommand named by the first argument. Without
+// arguments Isengard runs as a daemon, so existing deployments keep working.
+func dispatch(args []string) error {
+	if len(args) == 0 {
+		return runDaemon(nil)
 	}
-	defer cli.Close()
-
-	info, err := cli.Info(context.Background())
-	if err != nil {
-		return fmt.Errorf("connecting to Docker: %w", err)
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return nil
 	}
-	slog.Info("connected to Docker",
-		"version", info.ServerVersion,
-		"containers", info.Containers,
-	)
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command %q", args[0])
+}
 
-	checkDockerConfig()
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: isengard [command] [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Configuration is read from flags, ISENGARD_* environment variables and the")
+	fmt.Fprintln(w, "config file named by -config or ISENGARD_CONFIG, in that order of precedence.")
+	fmt.Fprintln(w, "Run `isengard <command> -h` for the flags of a command.")
+}
 
-	u := updater.New(cli, cfg)
-	u.CleanupOldSelf(context.Background())
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fmt.Println("isengard", version)
+	return nil
+}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// digestCacheTTL is how long a remote digest is reused for other containers
+// and hosts using the same image.
+const digestCacheTTL = time.Minute
 
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+// session is the setup shared by commands that talk to Docker: the loaded
+// config, the state store (nil when disabled) and the managed Docker hosts.
+type session struct {
+	cfg   config.Config
+	store *state.Store
+	hosts []*host
+}
 
-	go func() {
-		sig := <-sigCh
-		slog.Info("received signal, shutting down gracefully", "signal", sig)
-		cancel()
-	}()
+// host is a managed Docker daemon with its client and updater. The local
+// daemon used when no hosts are configured has an empty name.
+type host struct {
+	name    string
+	cli     *client.Client
+	updater *updater.Updater
+}
 
-	runCycle(ctx, u)
+// loadConfig loads the configuration and installs logging. Logs go to w so
+// that report commands can keep stdout for their output.
+func loadConfig(flags *config.Flags, w io.Writer) (config.Config, error) {
+	cfg, err := config.Load(flags)
+	if err != nil {
+		return config.Config{}, err
+	}
+	setupLogging(cfg, w)
+	return cfg, nil
+}
 
-	if cfg.RunOnce {
-		slog.Info("run-once mode, exiting")
-		return nil
+// newSession creates a Docker client and an updater for every configured
+// host, sharing one digest cache. If requireStore is set, commands fail
+// early when no state directory is configured.
+func newSession(cfg config.Config, requireStore bool) (*session, error) {
+	var store *state.Store
+	var err error
+	switch {
+	case requireStore:
+		store, err = openStore(cfg)
+	case cfg.StateDir != "":
+		store, err = state.Open(cfg.StateDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening state store: %w", err)
 	}
 
-	ticker := time.NewTicker(cfg.Interval)
-	defer ticker.Stop()
+	hosts := cfg.Hosts
+	if len(hosts) == 0 {
+		hosts = []config.Host{{}}
+	}
+	digests := registry.NewDigestCache(digestCacheTTL)
+
+	s := &session{cfg: cfg, store: store}
+	for _, h := range hosts {
+		cli, err := docker.NewClient(docker.Endpoint{
+			Host:          h.URL,
+			TLSDir:        tlsDir(cfg.TLSDir, h.Name),
+			SSHKey:        cfg.SSHKey,
+			SSHKnownHosts: cfg.SSHKnownHosts,
+		})
+		if err != nil {
+			s.Close()
+			return nil, hostError(h.Name, fmt.Errorf("creating Docker client: %w", err))
+		}
 
-	for {
-		select {
-		case <-ctx.Done():
-			slog.Info("shutting down")
-			return nil
-		case <-ticker.C:
-			runCycle(ctx, u)
+		u, err := updater.New(cli, h.Name, cfg, store, digests)
+		if err != nil {
+			cli.Close()
+			s.Close()
+			return nil, err
 		}
+		s.hosts = append(s.hosts, &host{name: h.Name, cli: cli, updater: u})
 	}
+	return s, nil
 }
 
-// checkDockerConfig warns at startup if the Docker config path exists but is
-// a directory. This typically means Docker created it automatically when the
-// file was bind-mounted but did not exist on the host.
-func checkDockerConfig() {
-	configPath := "/root/.docker/config.json"
-	if v := os.Getenv("DOCKER_CONFIG"); v != "" {
-		configPath = v + "/config.json"
+func (s *session) Close() {
+	for _, h := range s.hosts {
+		h.cli.Close()
 	}
+}
 
-	fi, err := os.Stat(configPath)
-	if err != nil {
-		return // does not exist, nothing to warn about
+// target resolves a command argument naming a container, "host/name" or
+// just "name" when a single host is managed.
+func (s *session) target(arg string) (*host, string, error) {
+	hostName, name, qualified := strings.Cut(arg, "/")
+	if !qualified {
+		if len(s.hosts) > 1 {
+			return nil, "", fmt.Errorf("%s: several Docker hosts are managed, use host/%s", arg, arg)
+		}
+		return s.hosts[0], arg, nil
 	}
-	if fi.IsDir() {
-		slog.Warn("docker config path is a directory, not a file (private registry auth will not work)",
-			"path", configPath,
-			"hint", "remove the directory on the host and only mount config.json if the file exists",
-		)
+	for _, h := range s.hosts {
+		if h.name != "" && h.name == hostName {
+			return h, name, nil
+		}
 	}
+	return nil, "", fmt.Errorf("%s: unknown Docker host %q", arg, hostName)
 }
 
-func runCycle(ctx context.Context, u *updater.Updater) {
-	if ctx.Err() != nil {
-		return
+// qualify prefixes a container name with its host's name, if it has one.
+func qualify(hostName, name string) string {
+	if hostName == "" {
+		return name
 	}
+	return hostName + "/" + name
+}
 
-	updated, err := u.RunCycle(ctx)
-	if err != nil {
-		slog.Error("update cycle failed", "error", err)
-		return
+// hostError prefixes err with the name of the host it happened on, if the
+// host has a name.
+func hostError(name string, err error) error {
+	if name == "" {
+		return err
 	}
+	return fmt.Errorf("host %s: %w", name, err)
+}
 
-	if updated > 0 {
-		slog.Info("cycle finished", "updated", updated)
+// tlsDir returns the certificate directory for a host: a subdirectory of dir
+// named after the host if there is one, otherwise dir itself.
+func tlsDir(dir, hostName string) string {
+	if dir == "" || hostName == "" {
+		return dir
+	}
+	if fi, err := os.Stat(filepath.Join(dir, hostName)); err == nil && fi.IsDir() {
+		return filepath.Join(dir, hostName)
 	}
+	return dir
+}
+
+// setupLogging installs pretty logging via charmbracelet/log as the default
+// slog handler.
+func setupLogging(cfg config.Config, w io.Writer) {
+	logger := log.NewWithOptions(w, log.Options{
+		Level:           log.Level(cfg.LogLevel),
+		ReportTimestamp: true,
+	})
+	// Force color output — Docker containers have no TTY so charm
+	// disables colors by default. This sets it on the logger's own renderer.
+	logger.SetColorProfile(termenv.TrueColor)
+	slog.SetDefault(slog.New(logger))
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/dirdmaster/isengard/internal/config"
+)
+
+// configPollInterval is how often the config and policy files are checked
+// for changes. Polling works for bind mounts and Kubernetes ConfigMaps,
+// which replace files through symlink swaps that inotify watchers miss.
+const configPollInterval = 10 * time.Second
+
+// restartOnly lists settings that are read once at startup; changing them
+// only takes effect after a restart.
+var restartOnly = map[string]bool{
+	"StateDir":       true,
+	"APIAddr":        true,
+	"APIToken":       true,
+	"WebhookSecret":  true,
+	"RunOnce":        true,
+	"Events":         true,
+	"EventsDebounce": true,
+	"Hosts":          true,
+	"TLSDir":         true,
+	"SSHKey":         true,
+	"SSHKnownHosts":  true,
+}
+
+// reloadConfig re-reads flags, environment and config file and applies the
+// result to the updaters of every host and the cycle ticker. On any error the current
+// configuration stays in effect and is returned.
+func reloadConfig(flags *config.Flags, current config.Config, hosts []*host, ticker *time.Ticker) config.Config {
+	next, err := config.Load(flags)
+	if err != nil {
+		slog.Error("configuration reload failed, keeping current configuration", "error", err)
+		return current
+	}
+
+	changes := config.Diff(current, next)
+	if len(changes) == 0 {
+		slog.Info("configuration unchanged")
+		// The policy file may have changed without its path changing.
+		if err := reloadHosts(hosts, next); err != nil {
+			slog.Error("configuration reload failed, keeping current configuration", "error", err)
+		}
+		return current
+	}
+
+	if err := reloadHosts(hosts, next); err != nil {
+		slog.Error("configuration reload failed, keeping current configuration", "error", err)
+		return current
+	}
+
+	for _, c := range changes {
+		if restartOnly[c.Field] {
+			slog.Warn("setting changed but requires a restart", "setting", c.Field, "old", c.Old, "new", c.New)
+			continue
+		}
+		slog.Info("setting changed", "setting", c.Field, "old", c.Old, "new", c.New)
+	}
+
+	if next.Interval != current.Interval {
+		ticker.Reset(next.Interval)
+	}
+	setupLogging(next, os.Stdout)
+
+	slog.Info("configuration reloaded", "changes", len(changes))
+	return next
+}
+
+// reloadHosts applies cfg to the updater of every host. The updaters load
+// the same files, so if one fails, it fails for the first.
+func reloadHosts(hosts []*host, cfg config.Config) error {
+	for _, h := range hosts {
+		if err := h.updater.Reload(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileWatcher detects changes to files by their size and modification time.
+type fileWatcher map[string]fileStamp
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func newFileWatcher(paths ...string) fileWatcher {
+	w := fileWatcher{}
+	for _, path := range paths {
+		if path != "" {
+			w[path] = stat(path)
+		}
+	}
+	return w
+}
+
+// changed returns the first watched path whose contents appear to have
+// changed since the last call, or "".
+func (w fileWatcher) changed() string {
+	for path, prev := range w {
+		if now := stat(path); now != prev {
+			w[path] = now
+			return path
+		}
+	}
+	return ""
+}
+
+// stat returns the file's stamp, or the zero stamp if it cannot be read.
+func stat(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: fi.Size(), modTime: fi.ModTime()}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dirdmaster/isengard/internal/config"
+	"github.com/dirdmaster/isengard/internal/state"
+)
+
+// runRollback restores a container's previous image and pauses its
+// automatic updates: isengard rollback <container>. With several Docker
+// hosts, the container is named as host/name.
+func runRollback(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: isengard rollback <container>")
+	}
+
+	cfg, err := loadConfig(flags, os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	s, err := newSession(cfg, true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	h, name, err := s.target(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if _, err := h.updater.Rollback(context.Background(), name); err != nil {
+		return fmt.Errorf("rollback: %w", err)
+	}
+	fmt.Printf("rolled back %s; automatic updates are paused until `isengard resume %s`\n", fs.Arg(0), fs.Arg(0))
+	return nil
+}
+
+// runResume re-enables automatic updates for a container paused by a
+// rollback: isengard resume <container>, or host/name with several Docker
+// hosts.
+func runResume(args []string) error {
+	fs := flag.NewFlagSet("resume", flag.ContinueOnError)
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: isengard resume <container>")
+	}
+
+	cfg, err := config.Load(flags)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	resumed, err := store.Resume(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if !resumed {
+		return fmt.Errorf("container %s is not paused", fs.Arg(0))
+	}
+	fmt.Printf("automatic updates resumed for %s\n", fs.Arg(0))
+	return nil
+}
+
+// openStore opens the state store for commands that cannot work without it.
+func openStore(cfg config.Config) (*state.Store, error) {
+	if cfg.StateDir == "" {
+		return nil, errors.New("no state directory: set ISENGARD_STATE_DIR")
+	}
+	return state.Open(cfg.StateDir)
+}